@@ -50,3 +50,11 @@ Create chart name and version as used by the chart label.
 {{- define "cert-manager-webhook-rackspace.credSecretName" -}}
 {{ printf "%s-creds" (include "cert-manager-webhook-rackspace.fullname" .) }}
 {{- end -}}
+
+{{- define "cert-manager-webhook-rackspace.defaultSecretName" -}}
+{{ default (include "cert-manager-webhook-rackspace.credSecretName" .) .Values.defaultCredentials.secretName }}
+{{- end -}}
+
+{{- define "cert-manager-webhook-rackspace.zonePolicyConfigMap" -}}
+{{ default (printf "%s-zone-policy" (include "cert-manager-webhook-rackspace.fullname" .)) .Values.zonePolicy.existingConfigMap }}
+{{- end -}}
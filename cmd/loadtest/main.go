@@ -0,0 +1,78 @@
+// Command loadtest solves a burst of concurrent challenges with the webhook's
+// solver against the fake Rackspace API and prints how it performed.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"github.com/cert-manager/cert-manager/pkg/acme/webhook/apis/acme/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	extapi "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/klog/v2"
+
+	"github.com/rackerlabs/cert-manager-webhook-rackspace/internal/loadtest"
+	"github.com/rackerlabs/cert-manager-webhook-rackspace/pkg/rackspacetest"
+	"github.com/rackerlabs/cert-manager-webhook-rackspace/pkg/solver"
+)
+
+const namespace = "default"
+
+func main() {
+	var opts loadtest.Options
+	flag.IntVar(&opts.Domains, "domains", 1000, "subdomains held in the account")
+	flag.IntVar(&opts.PageSize, "page-size", rackspacetest.DefaultPageSize, "entries per page of a list call")
+	flag.DurationVar(&opts.Latency, "latency", 0, "latency added to every API request")
+	flag.IntVar(&opts.Challenges, "challenges", 100, "challenges to solve, ignored when -duration is set")
+	flag.IntVar(&opts.Concurrency, "concurrency", 32, "challenges solved at a time")
+	flag.DurationVar(&opts.Duration, "duration", 0, "keep solving challenges for this long")
+	verbose := flag.Bool("v", false, "log every request")
+	flag.Parse()
+
+	// the per request log lines would dominate the measurements
+	if !*verbose {
+		log.SetOutput(io.Discard)
+		klog.LogToStderr(false)
+		klog.SetOutput(io.Discard)
+	}
+
+	srv := rackspacetest.NewServer()
+	defer srv.Close()
+
+	tenant := srv.AddAccount("user", "api-key")
+	loadtest.Seed(srv, tenant, opts)
+
+	s := &solver.Solver{
+		IdentityEndpoint: srv.IdentityEndpoint(),
+		Client: fake.NewSimpleClientset(&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "creds", Namespace: namespace},
+			Data: map[string][]byte{
+				"username": []byte("user"),
+				"api-key":  []byte("api-key"),
+			},
+		}),
+	}
+
+	report := loadtest.Run(srv, s, newChallenge, opts)
+	report.Write(os.Stdout)
+
+	if len(report.Failures) > 0 {
+		fmt.Fprintf(os.Stderr, "%d of %d challenges failed\n", len(report.Failures), report.Challenges)
+		os.Exit(1)
+	}
+}
+
+func newChallenge(i int) *v1alpha1.ChallengeRequest {
+	return &v1alpha1.ChallengeRequest{
+		ResourceNamespace: namespace,
+		ResolvedZone:      loadtest.Zone + ".",
+		ResolvedFQDN:      fmt.Sprintf("_acme-challenge.host%d.%s.", i, loadtest.Zone),
+		Key:               fmt.Sprintf("key-%d", i),
+		Config:            &extapi.JSON{Raw: []byte(`{"authSecretRef":"creds"}`)},
+	}
+}
@@ -7,6 +7,8 @@ import (
 	"time"
 
 	acmetest "github.com/cert-manager/cert-manager/test/acme"
+
+	"github.com/rackerlabs/cert-manager-webhook-rackspace/pkg/rackspacetest"
 )
 
 var (
@@ -15,6 +17,10 @@ var (
 )
 
 func TestRunsSuite(t *testing.T) {
+	if zone == "" {
+		t.Skip("TEST_ZONE_NAME is not set, skipping the live Rackspace conformance suite")
+	}
+
 	// The manifest path should contain a file named config.json that is a
 	// snippet of valid configuration that should be included on the
 	// ChallengeRequest passed as part of the test cases.
@@ -30,7 +36,7 @@ func TestRunsSuite(t *testing.T) {
 	fqdn = GetRandomString(20) + "." + zone
 
 	// Uncomment the below fixture when implementing your custom DNS provider
-	fixture := acmetest.NewFixture(&rackspaceDNSProviderSolver{},
+	fixture := acmetest.NewFixture(newSolver(),
 		acmetest.SetResolvedZone(zone),
 		acmetest.SetResolvedFQDN(fqdn),
 		acmetest.SetAllowAmbientCredentials(false),
@@ -42,6 +48,38 @@ func TestRunsSuite(t *testing.T) {
 
 }
 
+// TestRunsSuiteOffline runs the strict conformance suite against the fake
+// Rackspace API, which also serves the presented records over DNS.
+func TestRunsSuiteOffline(t *testing.T) {
+	srv := rackspacetest.NewServer()
+	defer srv.Close()
+
+	// must match testdata/rackspace-offline
+	tenant := srv.AddAccount("offline-user", "offline-api-key")
+	srv.AddDomain(tenant, "example.com")
+
+	dnsServer, err := srv.StartDNS("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := newSolver()
+	s.IdentityEndpoint = srv.IdentityEndpoint()
+
+	fixture := acmetest.NewFixture(s,
+		acmetest.SetResolvedZone("example.com."),
+		acmetest.SetResolvedFQDN(GetRandomString(20)+".example.com."),
+		acmetest.SetAllowAmbientCredentials(false),
+		acmetest.SetManifestPath("../../testdata/rackspace-offline"),
+		acmetest.SetDNSServer(dnsServer),
+		acmetest.SetUseAuthoritative(false),
+		acmetest.SetStrict(true),
+		acmetest.SetPollInterval(100*time.Millisecond),
+		acmetest.SetPropagationLimit(10*time.Second),
+	)
+	fixture.RunConformance(t)
+}
+
 func GetRandomString(n int) string {
 	letters := []rune("abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ")
 
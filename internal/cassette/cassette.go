@@ -0,0 +1,331 @@
+// Package cassette records Rackspace API interactions into fixture files,
+// with credentials scrubbed, and replays them in place of the real API.
+//
+// A Recorder wraps a real transport while the Cassette is saved afterwards.
+// A Replayer answers requests from a loaded Cassette without any network
+// access and fails requests the cassette doesn't hold, so changes to the
+// requests made by goraxauth or goclouddns show up as test failures.
+package cassette
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Placeholders scrubbed values are replaced with.
+const (
+	Username = "USERNAME"
+	ApiKey   = "API_KEY"
+	Token    = "TOKEN"
+	TenantID = "TENANT_ID"
+	UserID   = "USER_ID"
+)
+
+// Cassette is a recorded sequence of interactions.
+type Cassette struct {
+	// Vars holds values the recording depends on, such as the zone used.
+	Vars         map[string]string `json:"vars,omitempty"`
+	Interactions []Interaction     `json:"interactions"`
+}
+
+// Interaction is a request and the response it received.
+type Interaction struct {
+	Request  Request  `json:"request"`
+	Response Response `json:"response"`
+}
+
+// Request is a recorded request, headers are not kept.
+type Request struct {
+	Method string          `json:"method"`
+	URL    string          `json:"url"`
+	Body   json.RawMessage `json:"body,omitempty"`
+}
+
+// Response is a recorded response.
+type Response struct {
+	Status      int             `json:"status"`
+	ContentType string          `json:"contentType,omitempty"`
+	RetryAfter  string          `json:"retryAfter,omitempty"`
+	Body        json.RawMessage `json:"body,omitempty"`
+	Text        string          `json:"text,omitempty"`
+}
+
+// Load reads a cassette file.
+func Load(path string) (*Cassette, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read cassette: %w", err)
+	}
+
+	var c Cassette
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, fmt.Errorf("unable to decode cassette `%s`: %w", path, err)
+	}
+
+	return &c, nil
+}
+
+// Save writes the cassette to a file.
+func (c *Cassette) Save(path string) error {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(c); err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, buf.Bytes(), 0o644)
+}
+
+// Recorder records every interaction passing through Base into Cassette.
+type Recorder struct {
+	Base     http.RoundTripper
+	Cassette *Cassette
+
+	mu      sync.Mutex
+	secrets map[string]string // value to placeholder
+}
+
+// NewRecorder records through base, http.DefaultTransport when nil. The
+// username and API key are scrubbed, as are the tokens, tenant and user IDs
+// learned from the identity service.
+func NewRecorder(base http.RoundTripper, username string, apiKey string) *Recorder {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	return &Recorder{
+		Base:     base,
+		Cassette: &Cassette{Vars: make(map[string]string)},
+		secrets:  map[string]string{username: Username, apiKey: ApiKey},
+	}
+}
+
+// RoundTrip implements http.RoundTripper.
+func (r *Recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	reqBody, err := readBody(&req.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := r.Base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	respBody, err := readBody(&resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if strings.HasSuffix(req.URL.Path, "/tokens") {
+		r.learn(respBody)
+	}
+
+	recorded := Interaction{
+		Request: Request{
+			Method: req.Method,
+			URL:    r.scrub(req.URL.String()),
+			Body:   jsonOrNil(r.scrub(string(reqBody))),
+		},
+		Response: Response{
+			Status:      resp.StatusCode,
+			ContentType: resp.Header.Get("Content-Type"),
+			RetryAfter:  resp.Header.Get("Retry-After"),
+		},
+	}
+
+	body := r.scrub(string(respBody))
+	if recorded.Response.Body = jsonOrNil(body); recorded.Response.Body == nil {
+		recorded.Response.Text = body
+	}
+
+	r.Cassette.Interactions = append(r.Cassette.Interactions, recorded)
+
+	return resp, nil
+}
+
+// learn picks the identifiers to scrub out of a token response, must hold
+// r.mu.
+func (r *Recorder) learn(body []byte) {
+	var token struct {
+		Access struct {
+			Token struct {
+				ID     string `json:"id"`
+				Tenant struct {
+					ID string `json:"id"`
+				} `json:"tenant"`
+			} `json:"token"`
+			User struct {
+				ID string `json:"id"`
+			} `json:"user"`
+		} `json:"access"`
+	}
+	if json.Unmarshal(body, &token) != nil {
+		return
+	}
+
+	// the tenant ID comes first as accounts may use it as their user ID too
+	for _, learned := range [][2]string{
+		{token.Access.Token.Tenant.ID, TenantID},
+		{token.Access.Token.ID, Token},
+		{token.Access.User.ID, UserID},
+	} {
+		if _, known := r.secrets[learned[0]]; learned[0] != "" && !known {
+			r.secrets[learned[0]] = learned[1]
+		}
+	}
+}
+
+// scrub replaces every known secret, longest first so that one secret
+// containing another is replaced whole, must hold r.mu.
+func (r *Recorder) scrub(s string) string {
+	values := make([]string, 0, len(r.secrets))
+	for value := range r.secrets {
+		if value != "" {
+			values = append(values, value)
+		}
+	}
+	sort.Slice(values, func(i, j int) bool { return len(values[i]) > len(values[j]) })
+
+	for _, value := range values {
+		s = strings.ReplaceAll(s, value, r.secrets[value])
+	}
+	return s
+}
+
+// Replayer answers requests from a cassette. Every interaction is used once,
+// in order among those matching the same request.
+type Replayer struct {
+	cassette *Cassette
+
+	mu   sync.Mutex
+	used []bool
+}
+
+// NewReplayer replays c.
+func NewReplayer(c *Cassette) *Replayer {
+	return &Replayer{cassette: c, used: make([]bool, len(c.Interactions))}
+}
+
+// RoundTrip implements http.RoundTripper.
+func (r *Replayer) RoundTrip(req *http.Request) (*http.Response, error) {
+	body, err := readBody(&req.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i, interaction := range r.cassette.Interactions {
+		if r.used[i] || !matches(interaction.Request, req, body) {
+			continue
+		}
+		r.used[i] = true
+
+		return interaction.Response.http(req), nil
+	}
+
+	return nil, fmt.Errorf("cassette holds no interaction for %s %s with body %s", req.Method, req.URL, body)
+}
+
+// Unused returns the interactions that were never replayed.
+func (r *Replayer) Unused() []Interaction {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var unused []Interaction
+	for i, interaction := range r.cassette.Interactions {
+		if !r.used[i] {
+			unused = append(unused, interaction)
+		}
+	}
+	return unused
+}
+
+// matches compares the method, the path, the query parameters in any order
+// and the JSON body of a request. The host is ignored, requests never leave
+// the process.
+func matches(recorded Request, req *http.Request, body []byte) bool {
+	if recorded.Method != req.Method {
+		return false
+	}
+
+	u, err := url.Parse(recorded.URL)
+	if err != nil || u.Path != req.URL.Path || !reflect.DeepEqual(u.Query(), req.URL.Query()) {
+		return false
+	}
+
+	if len(recorded.Body) == 0 || len(body) == 0 {
+		return len(recorded.Body) == 0 && len(body) == 0
+	}
+
+	var want, got any
+	if json.Unmarshal(recorded.Body, &want) != nil || json.Unmarshal(body, &got) != nil {
+		return false
+	}
+	return reflect.DeepEqual(want, got)
+}
+
+func (r Response) http(req *http.Request) *http.Response {
+	body := []byte(r.Text)
+	if len(r.Body) > 0 {
+		body = r.Body
+	}
+
+	header := make(http.Header)
+	if r.ContentType != "" {
+		header.Set("Content-Type", r.ContentType)
+	}
+	if r.RetryAfter != "" {
+		header.Set("Retry-After", r.RetryAfter)
+	}
+
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", r.Status, http.StatusText(r.Status)),
+		StatusCode:    r.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}
+}
+
+// readBody reads a body while leaving an identical one in its place.
+func readBody(body *io.ReadCloser) ([]byte, error) {
+	if *body == nil || *body == http.NoBody {
+		return nil, nil
+	}
+
+	data, err := io.ReadAll(*body)
+	_ = (*body).Close()
+	*body = io.NopCloser(bytes.NewReader(data))
+
+	return data, err
+}
+
+// jsonOrNil returns s as raw JSON, nil when empty or not JSON.
+func jsonOrNil(s string) json.RawMessage {
+	s = strings.TrimSpace(s)
+	if s == "" || !json.Valid([]byte(s)) {
+		return nil
+	}
+	return json.RawMessage(s)
+}
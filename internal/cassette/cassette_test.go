@@ -0,0 +1,152 @@
+package cassette
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gophercloud/gophercloud/v2"
+	"github.com/gophercloud/gophercloud/v2/openstack"
+	tokens2 "github.com/gophercloud/gophercloud/v2/openstack/identity/v2/tokens"
+	"github.com/rackerlabs/goclouddns"
+	"github.com/rackerlabs/goclouddns/records"
+	"github.com/rackerlabs/goraxauth"
+
+	"github.com/rackerlabs/cert-manager-webhook-rackspace/pkg/rackspacetest"
+)
+
+func newClient(t *testing.T, transport http.RoundTripper, endpoint string, username string, apiKey string) *gophercloud.ServiceClient {
+	t.Helper()
+
+	provider, err := openstack.NewClient(endpoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+	provider.HTTPClient.Transport = transport
+
+	err = openstack.AuthenticateV2(context.Background(), provider, goraxauth.AuthOptions{
+		AuthOptions: tokens2.AuthOptions{IdentityEndpoint: endpoint, Username: username},
+		ApiKey:      apiKey,
+	}, gophercloud.EndpointOpts{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	service, err := goclouddns.NewCloudDNS(provider, gophercloud.EndpointOpts{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return service
+}
+
+func createRecord(t *testing.T, service *gophercloud.ServiceClient, domID string) error {
+	t.Helper()
+
+	_, err := records.Create(context.Background(), service, domID, records.CreateOpts{
+		Name: "_acme-challenge.example.com",
+		Type: "TXT",
+		Data: "key",
+		TTL:  300,
+	}).Extract()
+	return err
+}
+
+func TestRecordAndReplay(t *testing.T) {
+	srv := rackspacetest.NewServer()
+	defer srv.Close()
+
+	tenant := srv.AddAccount("secret-user", "secret-api-key")
+	domID := srv.AddDomain(tenant, "example.com")
+
+	recorder := NewRecorder(nil, "secret-user", "secret-api-key")
+	service := newClient(t, recorder, srv.IdentityEndpoint(), "secret-user", "secret-api-key")
+	if err := createRecord(t, service, domID); err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "cassette.json")
+	if err := recorder.Cassette.Save(path); err != nil {
+		t.Fatal(err)
+	}
+
+	saved, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, secret := range []string{"secret-user", "secret-api-key", "token-", "/" + tenant + "/"} {
+		if strings.Contains(string(saved), secret) {
+			t.Errorf("the cassette holds %q", secret)
+		}
+	}
+	if !strings.Contains(string(saved), "/v1.0/"+TenantID+"/domains/") {
+		t.Errorf("the tenant ID is not replaced by %s", TenantID)
+	}
+
+	cassette, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a recorded domain ID containing the tenant ID would be scrubbed too,
+	// the replayed client only ever sees scrubbed values
+	scrubbedDomID := strings.ReplaceAll(domID, tenant, TenantID)
+
+	replayer := NewReplayer(cassette)
+	service = newClient(t, replayer, "https://identity.invalid/v2.0/", Username, ApiKey)
+	if err := createRecord(t, service, scrubbedDomID); err != nil {
+		t.Fatal(err)
+	}
+	if got := replayer.Unused(); len(got) != 0 {
+		t.Errorf("expected none, got %v", got)
+	}
+
+	// the interactions are used up and nothing else is answered
+	if err := createRecord(t, service, scrubbedDomID); err == nil || !strings.Contains(err.Error(), "cassette holds no interaction") {
+		t.Errorf("expected error %q, got %v", "cassette holds no interaction", err)
+	}
+}
+
+func TestReplayDetectsChangedRequests(t *testing.T) {
+	replayer := NewReplayer(&Cassette{Interactions: []Interaction{{
+		Request:  Request{Method: http.MethodGet, URL: "https://dns.invalid/v1.0/TENANT_ID/domains?name=example.com&limit=10"},
+		Response: Response{Status: http.StatusOK, Body: []byte(`{"domains":[]}`)},
+	}, {
+		Request:  Request{Method: http.MethodPost, URL: "https://dns.invalid/v1.0/TENANT_ID/domains/1/records", Body: []byte(`{"records":[{"name":"a"}]}`)},
+		Response: Response{Status: http.StatusAccepted, Text: "accepted"},
+	}}})
+	client := &http.Client{Transport: replayer}
+
+	_, err := client.Get("https://other.invalid/v1.0/TENANT_ID/domains?name=example.com")
+	if err == nil || !strings.Contains(err.Error(), "cassette holds no interaction") {
+		t.Errorf("expected error %q, got %v", "cassette holds no interaction", err)
+	}
+
+	_, err = client.Post("https://other.invalid/v1.0/TENANT_ID/domains/1/records", "application/json", strings.NewReader(`{"records":[{"name":"b"}]}`))
+	if err == nil || !strings.Contains(err.Error(), "cassette holds no interaction") {
+		t.Errorf("expected error %q, got %v", "cassette holds no interaction", err)
+	}
+
+	resp, err := client.Get("https://other.invalid/v1.0/TENANT_ID/domains?limit=10&name=example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("resp.StatusCode = %v, want %v", resp.StatusCode, http.StatusOK)
+	}
+
+	resp, err = client.Post("https://other.invalid/v1.0/TENANT_ID/domains/1/records", "application/json", strings.NewReader(`{ "records": [ {"name": "a"} ] }`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusAccepted {
+		t.Errorf("resp.StatusCode = %v, want %v", resp.StatusCode, http.StatusAccepted)
+	}
+
+	if got := replayer.Unused(); len(got) != 0 {
+		t.Errorf("expected none, got %v", got)
+	}
+}
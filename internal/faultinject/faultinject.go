@@ -0,0 +1,288 @@
+// Package faultinject provides an http.RoundTripper that makes the Rackspace
+// API misbehave on purpose. It sits under gophercloud in tests and, when
+// RACKSPACE_FAULTS is set, in a development deployment of the webhook.
+package faultinject
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Kind is a failure mode.
+type Kind string
+
+const (
+	// RateLimit answers 413 with a Retry-After header, the way Rackspace
+	// reports exceeded rate limits.
+	RateLimit Kind = "ratelimit"
+	// ServerError answers with Status, 503 unless set.
+	ServerError Kind = "servererror"
+	// Slow delays the request by Latency before sending it.
+	Slow Kind = "slow"
+	// TruncatedJSON cuts the response body in half.
+	TruncatedJSON Kind = "truncate"
+	// ExpiredToken answers 401 to authenticated requests as if the token
+	// had expired while an operation was in progress.
+	ExpiredToken Kind = "expiretoken"
+	// JobError accepts changes as asynchronous jobs without sending them on,
+	// and reports those jobs as ended in ERROR once polled.
+	JobError Kind = "joberror"
+)
+
+// Rule injects one kind of failure into matching requests.
+type Rule struct {
+	Kind Kind
+
+	// Method and Path select requests, empty values match everything. Path
+	// matches when it is contained in the request path.
+	Method string
+	Path   string
+
+	// After lets that many matching requests through untouched first and
+	// Count stops injecting after that many faults, 0 meaning never.
+	After int
+	Count int
+
+	Latency    time.Duration
+	RetryAfter time.Duration
+	Status     int
+	Message    string
+}
+
+type ruleState struct {
+	Rule
+	seen     int
+	injected int
+}
+
+// Transport injects faults into requests sent through Base.
+type Transport struct {
+	Base http.RoundTripper
+
+	mu     sync.Mutex
+	rules  []*ruleState
+	failed map[string]string // injected job ID to error message
+	nextID int
+}
+
+// New wraps base, http.DefaultTransport when nil, with the rules.
+func New(base http.RoundTripper, rules ...Rule) *Transport {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	t := &Transport{Base: base}
+	for _, r := range rules {
+		t.rules = append(t.rules, &ruleState{Rule: r})
+	}
+	return t
+}
+
+// Injected returns how many faults each rule has injected so far.
+func (t *Transport) Injected() []int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	counts := make([]int, len(t.rules))
+	for i, r := range t.rules {
+		counts[i] = r.injected
+	}
+	return counts
+}
+
+// active returns the rules that fire for the request.
+func (t *Transport) active(req *http.Request) []Rule {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var rules []Rule
+	for _, r := range t.rules {
+		if !r.matches(req) {
+			continue
+		}
+
+		r.seen++
+		if r.seen <= r.After || (r.Count > 0 && r.injected >= r.Count) {
+			continue
+		}
+
+		r.injected++
+		rules = append(rules, r.Rule)
+	}
+	return rules
+}
+
+func (r *ruleState) matches(req *http.Request) bool {
+	if r.Method != "" && !strings.EqualFold(r.Method, req.Method) {
+		return false
+	}
+
+	if r.Path != "" && !strings.Contains(req.URL.Path, r.Path) {
+		return false
+	}
+
+	// only changes to domains and records run as jobs
+	if r.Kind == JobError && (req.Method == http.MethodGet || !strings.Contains(req.URL.Path, "/domains")) {
+		return false
+	}
+
+	// tokens are only checked on authenticated requests
+	if r.Kind == ExpiredToken && req.Header.Get("X-Auth-Token") == "" {
+		return false
+	}
+
+	return true
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if resp := t.failedJob(req); resp != nil {
+		return resp, nil
+	}
+
+	rules := t.active(req)
+
+	for _, r := range rules {
+		switch r.Kind {
+		case Slow:
+			select {
+			case <-time.After(r.Latency):
+			case <-req.Context().Done():
+				return nil, req.Context().Err()
+			}
+		case RateLimit:
+			resp := response(req, http.StatusRequestEntityTooLarge,
+				`{"overLimit":{"code":413,"message":"OverLimit Retry...","details":"Error Details..."}}`)
+			resp.Header.Set("Retry-After", strconv.Itoa(int(r.RetryAfter.Seconds())))
+			return resp, nil
+		case ServerError:
+			status := r.Status
+			if status == 0 {
+				status = http.StatusServiceUnavailable
+			}
+			return response(req, status, fmt.Sprintf(`{"code":%d,"message":"injected server error"}`, status)), nil
+		case ExpiredToken:
+			return response(req, http.StatusUnauthorized,
+				`{"unauthorized":{"code":401,"message":"No valid token provided. Please use the 'X-Auth-Token' header with a valid token."}}`), nil
+		case JobError:
+			return t.acceptJob(req, r.Message), nil
+		}
+	}
+
+	resp, err := t.Base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, r := range rules {
+		switch r.Kind {
+		case TruncatedJSON:
+			if err := rewriteBody(resp, func(body []byte) ([]byte, error) {
+				return body[:len(body)/2], nil
+			}); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return resp, nil
+}
+
+func response(req *http.Request, status int, body string) *http.Response {
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", status, http.StatusText(status)),
+		StatusCode:    status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{"Content-Type": []string{"application/json"}},
+		Body:          io.NopCloser(strings.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}
+}
+
+func rewriteBody(resp *http.Response, rewrite func([]byte) ([]byte, error)) error {
+	body, err := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if err != nil {
+		return err
+	}
+
+	body, err = rewrite(body)
+	if err != nil {
+		return err
+	}
+
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	resp.ContentLength = int64(len(body))
+	resp.Header.Del("Content-Length")
+
+	return nil
+}
+
+// acceptJob answers a change with a running job that is bound to fail.
+func (t *Transport) acceptJob(req *http.Request, message string) *http.Response {
+	if message == "" {
+		message = "injected job failure"
+	}
+
+	t.mu.Lock()
+	if t.failed == nil {
+		t.failed = make(map[string]string)
+	}
+	t.nextID++
+	id := fmt.Sprintf("injected-%d", t.nextID)
+	t.failed[id] = message
+	t.mu.Unlock()
+
+	// status lives next to domains under the tenant, /v1.0/<tenant>/status/<id>
+	prefix, _, _ := strings.Cut(req.URL.Path, "/domains")
+	callback := *req.URL
+	callback.Path = prefix + "/status/" + id
+	callback.RawQuery = ""
+
+	body, _ := json.Marshal(map[string]any{
+		"status":      "RUNNING",
+		"verb":        req.Method,
+		"jobId":       id,
+		"callbackUrl": callback.String(),
+		"requestUrl":  req.URL.String(),
+	})
+	return response(req, http.StatusAccepted, string(body))
+}
+
+// failedJob answers status requests for jobs started by acceptJob.
+func (t *Transport) failedJob(req *http.Request) *http.Response {
+	_, id, ok := strings.Cut(req.URL.Path, "/status/")
+	if !ok || req.Method != http.MethodGet {
+		return nil
+	}
+
+	t.mu.Lock()
+	message, ok := t.failed[id]
+	t.mu.Unlock()
+	if !ok {
+		return nil
+	}
+
+	body, _ := json.Marshal(map[string]any{
+		"status":      "ERROR",
+		"verb":        req.Method,
+		"jobId":       id,
+		"callbackUrl": req.URL.String(),
+		"error": map[string]any{
+			"code":    http.StatusBadRequest,
+			"message": message,
+			"details": message,
+		},
+	})
+	return response(req, http.StatusOK, string(body))
+}
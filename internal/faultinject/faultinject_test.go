@@ -0,0 +1,110 @@
+package faultinject
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		spec    string
+		want    []Rule
+		wantErr string
+	}{
+		{
+			name: "empty",
+		},
+		{
+			name: "several rules",
+			spec: "ratelimit:count=2,retryafter=3s; slow:latency=1s,path=/domains,method=get;joberror",
+			want: []Rule{
+				{Kind: RateLimit, Count: 2, RetryAfter: 3 * time.Second},
+				{Kind: Slow, Latency: time.Second, Path: "/domains", Method: http.MethodGet},
+				{Kind: JobError},
+			},
+		},
+		{
+			name:    "unknown kind",
+			spec:    "explode",
+			wantErr: "unknown fault `explode`",
+		},
+		{
+			name:    "bad option",
+			spec:    "servererror:status=five",
+			wantErr: "invalid option `status=five`",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := Parse(tc.spec)
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Errorf("expected error %q, got %v", tc.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("got %+v, want %+v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestTransport(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"domains":[{"id":"1"}]}`))
+	}))
+	defer srv.Close()
+
+	transport := New(nil,
+		Rule{Kind: ServerError, Path: "/fail", After: 1, Count: 2, Status: http.StatusBadGateway},
+		Rule{Kind: RateLimit, Path: "/limited", RetryAfter: 5 * time.Second},
+		Rule{Kind: TruncatedJSON, Path: "/truncated"},
+	)
+	client := &http.Client{Transport: transport}
+
+	get := func(path string) (*http.Response, string) {
+		resp, err := client.Get(srv.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp, string(body)
+	}
+
+	var statuses []int
+	for range 4 {
+		resp, _ := get("/fail")
+		statuses = append(statuses, resp.StatusCode)
+	}
+	if want := []int{200, 502, 502, 200}; !slices.Equal(statuses, want) {
+		t.Errorf("statuses %v, want %v", statuses, want)
+	}
+
+	resp, _ := get("/limited")
+	if resp.StatusCode != http.StatusRequestEntityTooLarge || resp.Header.Get("Retry-After") != "5" {
+		t.Errorf("rate limited with %d, retry after %q", resp.StatusCode, resp.Header.Get("Retry-After"))
+	}
+
+	_, body := get("/truncated")
+	if body != `{"domains":[` {
+		t.Errorf("truncated body %q", body)
+	}
+
+	if injected, want := transport.Injected(), []int{2, 1, 1}; !slices.Equal(injected, want) {
+		t.Errorf("injected %v, want %v", injected, want)
+	}
+}
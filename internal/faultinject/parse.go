@@ -0,0 +1,78 @@
+package faultinject
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Parse reads rules from a spec such as
+//
+//	ratelimit:count=2,retryafter=1s;slow:latency=3s,path=/domains
+//
+// Rules are separated by `;` and each is a Kind optionally followed by `:` and
+// comma separated options named after the Rule fields.
+func Parse(spec string) ([]Rule, error) {
+	var rules []Rule
+	for _, part := range strings.Split(spec, ";") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		kind, options, _ := strings.Cut(part, ":")
+		rule := Rule{Kind: Kind(strings.ToLower(strings.TrimSpace(kind)))}
+
+		switch rule.Kind {
+		case RateLimit, ServerError, Slow, TruncatedJSON, ExpiredToken, JobError:
+		default:
+			return nil, fmt.Errorf("unknown fault `%s`", kind)
+		}
+
+		for _, option := range strings.Split(options, ",") {
+			if strings.TrimSpace(option) == "" {
+				continue
+			}
+			if err := rule.set(option); err != nil {
+				return nil, fmt.Errorf("invalid option `%s` for fault `%s`: %w", option, kind, err)
+			}
+		}
+
+		rules = append(rules, rule)
+	}
+
+	return rules, nil
+}
+
+func (r *Rule) set(option string) error {
+	key, value, ok := strings.Cut(option, "=")
+	if !ok {
+		return fmt.Errorf("expected key=value")
+	}
+	value = strings.TrimSpace(value)
+
+	var err error
+	switch strings.ToLower(strings.TrimSpace(key)) {
+	case "method":
+		r.Method = strings.ToUpper(value)
+	case "path":
+		r.Path = value
+	case "after":
+		r.After, err = strconv.Atoi(value)
+	case "count":
+		r.Count, err = strconv.Atoi(value)
+	case "latency":
+		r.Latency, err = time.ParseDuration(value)
+	case "retryafter":
+		r.RetryAfter, err = time.ParseDuration(value)
+	case "status":
+		r.Status, err = strconv.Atoi(value)
+	case "message":
+		r.Message = value
+	default:
+		return fmt.Errorf("unknown option")
+	}
+
+	return err
+}
@@ -0,0 +1,188 @@
+// Package loadtest drives a solver with bursts of concurrent challenges
+// against the fake Rackspace API and reports how it performed.
+package loadtest
+
+import (
+	"fmt"
+	"io"
+	"runtime"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/cert-manager/cert-manager/pkg/acme/webhook/apis/acme/v1alpha1"
+
+	"github.com/rackerlabs/cert-manager-webhook-rackspace/pkg/rackspacetest"
+)
+
+// Zone is the domain every challenge is presented in.
+const Zone = "example.com"
+
+// Solver is the part of a cert-manager webhook solver that is exercised.
+type Solver interface {
+	Present(ch *v1alpha1.ChallengeRequest) error
+	CleanUp(ch *v1alpha1.ChallengeRequest) error
+}
+
+// Options shape the account and the burst of challenges.
+type Options struct {
+	// Domains is the number of subdomains of Zone held in the account
+	// besides Zone itself, so that finding Zone has to page through them.
+	Domains int
+	// PageSize is the number of entries per page returned by the fake.
+	PageSize int
+	// Latency is added to every API request.
+	Latency time.Duration
+	// Challenges is the number of challenges to solve, Concurrency of them
+	// at a time.
+	Challenges  int
+	Concurrency int
+	// Duration keeps solving challenges until it has passed instead of
+	// stopping after Challenges when set.
+	Duration time.Duration
+}
+
+// Report is the outcome of a Run. Challenges holds the number actually
+// solved. Latencies cover Present and CleanUp of one challenge. Allocations
+// are counted process wide, so they include the fake.
+type Report struct {
+	Options
+
+	Failures []error
+	Elapsed  time.Duration
+	P50      time.Duration
+	P99      time.Duration
+
+	// Calls counts API requests per route.
+	Calls              map[string]int
+	CallsPerChallenge  float64
+	AllocsPerChallenge float64
+	BytesPerChallenge  float64
+}
+
+// Seed fills the account of tenantID with the domains and sets up the fake
+// according to opts.
+func Seed(srv *rackspacetest.Server, tenantID string, opts Options) {
+	if opts.PageSize > 0 {
+		srv.PageSize = opts.PageSize
+	}
+	srv.SetLatency(opts.Latency)
+
+	srv.AddDomain(tenantID, Zone)
+	for i := range opts.Domains {
+		srv.AddDomain(tenantID, fmt.Sprintf("d%05d.%s", i, Zone))
+	}
+}
+
+// Run solves opts.Challenges challenges built by newChallenge, or as many as
+// fit in opts.Duration, each presented and then cleaned up, and reports the
+// API calls, latencies and allocations.
+func Run(srv *rackspacetest.Server, solver Solver, newChallenge func(i int) *v1alpha1.ChallengeRequest, opts Options) Report {
+	concurrency := max(opts.Concurrency, 1)
+	report := Report{Options: opts}
+
+	var mu sync.Mutex
+	latencies := make([]time.Duration, 0, opts.Challenges)
+
+	work := make(chan int)
+	var wg sync.WaitGroup
+
+	srv.ResetRequests()
+
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	start := time.Now()
+
+	for range concurrency {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range work {
+				ch := newChallenge(i)
+
+				began := time.Now()
+				err := solver.Present(ch)
+				if err == nil {
+					err = solver.CleanUp(ch)
+				}
+				took := time.Since(began)
+
+				mu.Lock()
+				latencies = append(latencies, took)
+				if err != nil {
+					report.Failures = append(report.Failures, fmt.Errorf("challenge %d: %w", i, err))
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	solved := 0
+	if opts.Duration > 0 {
+		for deadline := start.Add(opts.Duration); time.Now().Before(deadline); solved++ {
+			work <- solved
+		}
+	} else {
+		for ; solved < opts.Challenges; solved++ {
+			work <- solved
+		}
+	}
+	close(work)
+	wg.Wait()
+
+	report.Elapsed = time.Since(start)
+	report.Challenges = solved
+	runtime.ReadMemStats(&after)
+
+	report.Calls = srv.Requests()
+
+	slices.Sort(latencies)
+	report.P50 = percentile(latencies, 50)
+	report.P99 = percentile(latencies, 99)
+
+	if n := float64(solved); n > 0 {
+		calls := 0
+		for _, c := range report.Calls {
+			calls += c
+		}
+		report.CallsPerChallenge = float64(calls) / n
+		report.AllocsPerChallenge = float64(after.Mallocs-before.Mallocs) / n
+		report.BytesPerChallenge = float64(after.TotalAlloc-before.TotalAlloc) / n
+	}
+
+	return report
+}
+
+// percentile picks the p-th percentile of sorted durations by nearest rank.
+func percentile(sorted []time.Duration, p int) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+
+	rank := (p*len(sorted) + 99) / 100
+	return sorted[max(rank, 1)-1]
+}
+
+// Write prints the report in a readable form.
+func (r Report) Write(w io.Writer) {
+	fmt.Fprintf(w, "domains=%d page-size=%d latency=%s challenges=%d concurrency=%d duration=%s\n",
+		r.Domains, r.PageSize, r.Latency, r.Challenges, r.Concurrency, r.Duration)
+	fmt.Fprintf(w, "elapsed %s, %d failed\n", r.Elapsed.Round(time.Millisecond), len(r.Failures))
+	fmt.Fprintf(w, "latency p50 %s, p99 %s\n", r.P50.Round(time.Microsecond), r.P99.Round(time.Microsecond))
+	fmt.Fprintf(w, "per challenge: %.1f API calls, %.0f allocs, %.0f bytes\n",
+		r.CallsPerChallenge, r.AllocsPerChallenge, r.BytesPerChallenge)
+
+	routes := make([]string, 0, len(r.Calls))
+	for route := range r.Calls {
+		routes = append(routes, route)
+	}
+	sort.Strings(routes)
+	for _, route := range routes {
+		fmt.Fprintf(w, "  %-60s %d\n", route, r.Calls[route])
+	}
+
+	for _, err := range r.Failures {
+		fmt.Fprintf(w, "failed: %v\n", err)
+	}
+}
@@ -0,0 +1,56 @@
+package loadtest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cert-manager/cert-manager/pkg/acme/webhook/apis/acme/v1alpha1"
+
+	"github.com/rackerlabs/cert-manager-webhook-rackspace/pkg/rackspacetest"
+)
+
+func TestPercentile(t *testing.T) {
+	var sorted []time.Duration
+	for i := 1; i <= 200; i++ {
+		sorted = append(sorted, time.Duration(i))
+	}
+
+	for _, tc := range []struct {
+		durations []time.Duration
+		p         int
+		want      time.Duration
+	}{
+		{durations: nil, p: 50, want: 0},
+		{durations: []time.Duration{7}, p: 99, want: 7},
+		{durations: sorted, p: 50, want: 100},
+		{durations: sorted, p: 99, want: 198},
+		{durations: sorted, p: 0, want: 1},
+	} {
+		if got := percentile(tc.durations, tc.p); got != tc.want {
+			t.Errorf("p%d of %d durations = %v, want %v", tc.p, len(tc.durations), got, tc.want)
+		}
+	}
+}
+
+type stubSolver struct{}
+
+func (stubSolver) Present(*v1alpha1.ChallengeRequest) error { time.Sleep(time.Millisecond); return nil }
+func (stubSolver) CleanUp(*v1alpha1.ChallengeRequest) error { return nil }
+
+func TestRunDuration(t *testing.T) {
+	srv := rackspacetest.NewServer()
+	defer srv.Close()
+
+	newChallenge := func(int) *v1alpha1.ChallengeRequest { return &v1alpha1.ChallengeRequest{} }
+	report := Run(srv, stubSolver{}, newChallenge, Options{Challenges: 1, Concurrency: 4, Duration: 50 * time.Millisecond})
+
+	if report.Elapsed < 50*time.Millisecond {
+		t.Errorf("stopped after %s", report.Elapsed)
+	}
+	if report.Challenges <= 1 {
+		t.Errorf("solved %d challenges, want as many as fit in the duration", report.Challenges)
+	}
+	if len(report.Failures) > 0 {
+		t.Errorf("unexpected failures: %v", report.Failures)
+	}
+}
@@ -0,0 +1,153 @@
+// Package redact masks credentials and account identifiers in log lines and
+// errors. Values are registered as they are loaded or learned from the
+// identity service and every occurrence of them is replaced afterwards.
+package redact
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Mask replaces every registered value.
+const Mask = "<redacted>"
+
+// minLength stops very short values from masking unrelated text.
+const minLength = 4
+
+// maxValues bounds the registry since a token is registered on every login.
+const maxValues = 4096
+
+var (
+	mu     sync.RWMutex
+	values = map[string]struct{}{}
+	// order holds the values from the least to the most recently registered
+	order []string
+	// longest holds the values from the longest to the shortest, so that a
+	// value containing another is masked whole
+	longest []string
+	enabled = true
+)
+
+// SetEnabled turns redaction on or off, it is meant for debugging only.
+func SetEnabled(on bool) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	enabled = on
+}
+
+// Register adds sensitive values that must never be shown. Registering a
+// value again keeps it from being forgotten, credentials are registered
+// every time they are loaded.
+func Register(secrets ...string) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	added := false
+	for _, v := range secrets {
+		if len(v) < minLength {
+			continue
+		}
+		if _, ok := values[v]; ok {
+			if i := slices.Index(order, v); i != len(order)-1 {
+				order = append(slices.Delete(order, i, i+1), v)
+			}
+			continue
+		}
+
+		values[v] = struct{}{}
+		order = append(order, v)
+		added = true
+	}
+
+	// forget the least recently registered values first, they are the least
+	// likely to be in use
+	for len(order) > maxValues {
+		delete(values, order[0])
+		order = order[1:]
+	}
+
+	if added {
+		longest = slices.Clone(order)
+		sort.SliceStable(longest, func(i, j int) bool { return len(longest[i]) > len(longest[j]) })
+	}
+}
+
+// String masks every registered value in s.
+func String(s string) string {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	if !enabled {
+		return s
+	}
+
+	for _, v := range longest {
+		s = strings.ReplaceAll(s, v, Mask)
+	}
+	return s
+}
+
+// Error wraps err so that its message is masked while errors.Is and
+// errors.As still see the original error.
+func Error(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &redactedError{err: err}
+}
+
+type redactedError struct {
+	err error
+}
+
+func (e *redactedError) Error() string {
+	return String(e.err.Error())
+}
+
+func (e *redactedError) Unwrap() error {
+	return e.err
+}
+
+// Writer masks everything written through it before passing it on to w.
+func Writer(w io.Writer) io.Writer {
+	return writer{w: w}
+}
+
+type writer struct {
+	w io.Writer
+}
+
+func (w writer) Write(p []byte) (int, error) {
+	if _, err := io.WriteString(w.w, String(string(p))); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// LogFilter implements klog.LogFilter, masking every log call.
+type LogFilter struct{}
+
+func (LogFilter) Filter(args []interface{}) []interface{} {
+	return []interface{}{String(fmt.Sprint(args...))}
+}
+
+func (LogFilter) FilterF(format string, args []interface{}) (string, []interface{}) {
+	return "%s", []interface{}{String(fmt.Sprintf(format, args...))}
+}
+
+func (LogFilter) FilterS(msg string, keysAndValues []interface{}) (string, []interface{}) {
+	masked := make([]interface{}, len(keysAndValues))
+	for i, kv := range keysAndValues {
+		if i%2 == 0 {
+			masked[i] = kv
+			continue
+		}
+		masked[i] = String(fmt.Sprint(kv))
+	}
+	return String(msg), masked
+}
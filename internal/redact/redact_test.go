@@ -0,0 +1,132 @@
+package redact
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestString(t *testing.T) {
+	Register("some-user", "0123456789abcdef", "abc")
+
+	for in, want := range map[string]string{
+		"unable to authenticate as `some-user`": "unable to authenticate as `<redacted>`",
+		"key 0123456789abcdef rejected":         "key <redacted> rejected",
+		// values shorter than minLength are ignored
+		"abc": "abc",
+	} {
+		if got := String(in); got != want {
+			t.Errorf("String(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestStringNested(t *testing.T) {
+	// a tenant ID inside a token, registered in either order
+	Register("98765", "tok98765abc")
+	Register("tok-inner-abc", "inner")
+
+	for i := 0; i < 100; i++ {
+		for in, want := range map[string]string{
+			"token tok98765abc for tenant 98765": "token <redacted> for tenant <redacted>",
+			"token tok-inner-abc":                "token <redacted>",
+		} {
+			if got := String(in); got != want {
+				t.Fatalf("String(%q) = %q, want %q", in, got, want)
+			}
+		}
+	}
+}
+
+func TestSetEnabled(t *testing.T) {
+	Register("debug-user")
+
+	SetEnabled(false)
+	if got := String("debug-user"); got != "debug-user" {
+		t.Errorf("masked %q while disabled", got)
+	}
+
+	SetEnabled(true)
+	if got := String("debug-user"); got != Mask {
+		t.Errorf("did not mask %q once enabled", got)
+	}
+}
+
+func TestError(t *testing.T) {
+	Register("123456")
+
+	cause := errors.New("GET https://dns.example.com/v1.0/123456/domains failed")
+	err := Error(fmt.Errorf("unable to fetch domains: %w", cause))
+
+	if strings.Contains(err.Error(), "123456") {
+		t.Errorf("leaked in %q", err)
+	}
+	if !errors.Is(err, cause) {
+		t.Error("the cause is lost")
+	}
+	if Error(nil) != nil {
+		t.Error("a nil error is wrapped")
+	}
+}
+
+func TestWriter(t *testing.T) {
+	Register("tok-abcdef")
+
+	var buf bytes.Buffer
+	n, err := Writer(&buf).Write([]byte("X-Auth-Token: tok-abcdef\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 25 {
+		t.Errorf("wrote %d bytes, want the 25 given", n)
+	}
+	if got := buf.String(); got != "X-Auth-Token: <redacted>\n" {
+		t.Errorf("wrote %q", got)
+	}
+}
+
+func TestLogFilter(t *testing.T) {
+	Register("filter-user")
+
+	format, args := LogFilter{}.FilterF("user %s", []interface{}{"filter-user"})
+	if got := fmt.Sprintf(format, args...); got != "user <redacted>" {
+		t.Errorf("FilterF gave %q", got)
+	}
+
+	msg, kv := LogFilter{}.FilterS("login filter-user", []interface{}{"user", "filter-user"})
+	if msg != "login <redacted>" {
+		t.Errorf("FilterS gave %q", msg)
+	}
+	if want := []interface{}{"user", Mask}; !reflect.DeepEqual(kv, want) {
+		t.Errorf("FilterS gave %v, want %v", kv, want)
+	}
+}
+
+func TestRegisterBounded(t *testing.T) {
+	Register("long-lived-api-key")
+
+	for i := 0; i < maxValues+10; i++ {
+		Register(fmt.Sprintf("token-%08d", i))
+		if i == maxValues/2 {
+			// credentials are registered again every time they are loaded
+			Register("long-lived-api-key")
+		}
+	}
+
+	mu.RLock()
+	size, ordered, sorted := len(values), len(order), len(longest)
+	mu.RUnlock()
+
+	if size > maxValues || ordered != size || sorted != size {
+		t.Errorf("holds %d values, %d in order and %d sorted, at most %d expected", size, ordered, sorted, maxValues)
+	}
+	if got := String("key long-lived-api-key"); got != "key <redacted>" {
+		t.Errorf("a value in use was forgotten: %q", got)
+	}
+	if got := String("token-00000000"); got != "token-00000000" {
+		t.Errorf("the oldest token was kept: %q", got)
+	}
+}
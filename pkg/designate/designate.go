@@ -0,0 +1,168 @@
+// Package designate implements dnsprovider.Provider on top of OpenStack
+// Designate, logging in through Keystone v3 with a password or an
+// application credential.
+package designate
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/gophercloud/gophercloud/v2"
+	"github.com/gophercloud/gophercloud/v2/openstack"
+	tokens3 "github.com/gophercloud/gophercloud/v2/openstack/identity/v3/tokens"
+
+	"github.com/rackerlabs/cert-manager-webhook-rackspace/internal/redact"
+)
+
+// The Keystone auth methods a Config can use.
+const (
+	AuthMethodPassword              = "password"
+	AuthMethodApplicationCredential = "application-credential"
+)
+
+// Config holds the credentials of a single OpenStack project.
+type Config struct {
+	// AuthOptions log in to Keystone, IdentityEndpoint being the v3 auth URL
+	// such as `https://keystone.example.com/v3`. With an application
+	// credential the Scope is not requested but checked against the project
+	// the credential is bound to.
+	AuthOptions gophercloud.AuthOptions
+	// Region selects the DNS endpoint from the service catalog, the first
+	// one found when empty.
+	Region string
+	// SecretRef is the `namespace/name` of the Secret the credentials came from.
+	SecretRef string
+
+	// Transport carries every request to OpenStack, nil meaning the default
+	// transport.
+	Transport http.RoundTripper
+	// UserAgent is prepended to the User-Agent of every request.
+	UserAgent string
+}
+
+// Connect logs in to the project and returns a Provider for its Designate
+// service.
+func Connect(ctx context.Context, c Config) (*Provider, error) {
+	service, err := AuthenticateClient(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+	return New(service), nil
+}
+
+// AuthenticateClient logs in to the project and returns a client for its
+// Designate service.
+func AuthenticateClient(ctx context.Context, c Config) (*gophercloud.ServiceClient, error) {
+	provider, err := newProvider(ctx, c.AuthOptions, c.Transport)
+	if err != nil {
+		return nil, fmt.Errorf("unable to authenticate to keystone as `%s`: %w", identity(c.AuthOptions), err)
+	}
+
+	registerAuthResult(provider)
+
+	if c.UserAgent != "" {
+		provider.UserAgent.Prepend(c.UserAgent)
+	}
+
+	service, err := openstack.NewDNSV2(provider, gophercloud.EndpointOpts{Region: c.Region})
+	if err != nil {
+		return nil, fmt.Errorf("unable to find dns endpoint for keystone as `%s`: %w", identity(c.AuthOptions), err)
+	}
+
+	return service, nil
+}
+
+// newProvider logs in through transport. Tokens that expire part way through
+// an operation are renewed once by gophercloud and the request retried.
+func newProvider(ctx context.Context, ao gophercloud.AuthOptions, transport http.RoundTripper) (*gophercloud.ProviderClient, error) {
+	provider, err := openstack.NewClient(ao.IdentityEndpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	if transport != nil {
+		provider.HTTPClient.Transport = transport
+	}
+
+	// Keystone rejects application credentials that ask for a scope, they
+	// are always bound to the project they were created in
+	scope := ao.Scope
+	appCred := authMethod(ao) == AuthMethodApplicationCredential
+	if appCred {
+		ao.Scope = nil
+	}
+
+	ao.AllowReauth = true
+	if err := openstack.AuthenticateV3(ctx, provider, &ao, gophercloud.EndpointOpts{}); err != nil {
+		return nil, err
+	}
+
+	if appCred && scope != nil {
+		if err := checkScope(provider, *scope); err != nil {
+			return nil, err
+		}
+	}
+
+	return provider, nil
+}
+
+// checkScope makes sure the token is scoped to the expected project.
+func checkScope(provider *gophercloud.ProviderClient, scope gophercloud.AuthScope) error {
+	result, ok := provider.GetAuthResult().(tokens3.CreateResult)
+	if !ok {
+		return fmt.Errorf("unable to check the project of the token")
+	}
+
+	project, err := result.ExtractProject()
+	if err != nil {
+		return err
+	}
+	if project == nil {
+		return fmt.Errorf("token is not scoped to a project")
+	}
+
+	mismatch := (scope.ProjectID != "" && scope.ProjectID != project.ID) ||
+		(scope.ProjectName != "" && scope.ProjectName != project.Name) ||
+		(scope.DomainID != "" && scope.DomainID != project.Domain.ID) ||
+		(scope.DomainName != "" && scope.DomainName != project.Domain.Name)
+	if mismatch {
+		return fmt.Errorf("application credential is bound to project `%s` in domain `%s`, not the configured project", project.Name, project.Domain.Name)
+	}
+
+	return nil
+}
+
+// authMethod tells which credentials the options carry.
+func authMethod(ao gophercloud.AuthOptions) string {
+	if ao.ApplicationCredentialID != "" || ao.ApplicationCredentialName != "" {
+		return AuthMethodApplicationCredential
+	}
+	return AuthMethodPassword
+}
+
+// registerAuthResult marks the token and project ID of a login as sensitive.
+func registerAuthResult(provider *gophercloud.ProviderClient) {
+	redact.Register(provider.Token())
+
+	if result, ok := provider.GetAuthResult().(tokens3.CreateResult); ok {
+		if project, err := result.ExtractProject(); err == nil && project != nil {
+			redact.Register(project.ID)
+		}
+	}
+}
+
+// identity names the credentials in errors, the username or the application
+// credential ID.
+func identity(ao gophercloud.AuthOptions) string {
+	switch {
+	case ao.ApplicationCredentialID != "":
+		return ao.ApplicationCredentialID
+	case ao.ApplicationCredentialName != "":
+		return ao.Username + "/" + ao.ApplicationCredentialName
+	case ao.Username != "":
+		return ao.Username
+	default:
+		return ao.UserID
+	}
+}
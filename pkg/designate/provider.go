@@ -0,0 +1,248 @@
+package designate
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/gophercloud/gophercloud/v2"
+	"github.com/gophercloud/gophercloud/v2/openstack/dns/v2/recordsets"
+	"github.com/gophercloud/gophercloud/v2/openstack/dns/v2/zones"
+
+	"github.com/rackerlabs/cert-manager-webhook-rackspace/pkg/dnsprovider"
+)
+
+// Provider is a dnsprovider.Provider for the Designate service of a single
+// OpenStack project.
+//
+// Designate keeps every value of a name and type in one recordset, so each
+// TXT value is exposed as its own dnsprovider.Record with an ID made of the
+// recordset ID and the value. Adding and removing a value rewrites the
+// recordset.
+type Provider struct {
+	service *gophercloud.ServiceClient
+}
+
+var (
+	_ dnsprovider.Provider   = (*Provider)(nil)
+	_ dnsprovider.Lister     = (*Provider)(nil)
+	_ dnsprovider.RecordSets = (*Provider)(nil)
+)
+
+// New wraps an authenticated Designate client.
+func New(service *gophercloud.ServiceClient) *Provider {
+	return &Provider{service: service}
+}
+
+// FindZone looks the zone up by its exact name.
+func (p *Provider) FindZone(ctx context.Context, zoneName string) (string, error) {
+	pages, err := zones.List(p.service, zones.ListOpts{Name: absolute(zoneName)}).AllPages(ctx)
+	if err != nil {
+		return "", fmt.Errorf("unable to fetch zones in designate project: %w", err)
+	}
+
+	zoneList, err := zones.ExtractZones(pages)
+	if err != nil {
+		return "", fmt.Errorf("unable to fetch zones in designate project: %w", err)
+	}
+
+	for _, zone := range zoneList {
+		if relative(zone.Name) == zoneName {
+			return zone.ID, nil
+		}
+	}
+
+	return "", fmt.Errorf("failed to find zone `%s`", zoneName)
+}
+
+// CreateTXTRecord adds the value to the name's TXT recordset, creating the
+// recordset when it does not exist yet.
+func (p *Provider) CreateTXTRecord(ctx context.Context, zoneID string, record dnsprovider.Record) (dnsprovider.Record, error) {
+	set, err := p.TXTRecordSet(ctx, zoneID, record.Name)
+	if err != nil {
+		return dnsprovider.Record{}, err
+	}
+	return p.AddTXTRecord(ctx, zoneID, set, record)
+}
+
+// TXTRecordSet returns the name's TXT recordset, whose description every
+// value in it is listed with.
+func (p *Provider) TXTRecordSet(ctx context.Context, zoneID string, name string) (dnsprovider.RecordSet, error) {
+	existing, err := p.recordSets(ctx, zoneID, name)
+	if err != nil || len(existing) == 0 {
+		return dnsprovider.RecordSet{}, err
+	}
+
+	rs := existing[0]
+	set := dnsprovider.RecordSet{ID: rs.ID, Comment: rs.Description}
+	for _, value := range rs.Records {
+		set.Records = append(set.Records, toRecord(rs, unquote(value)))
+	}
+	return set, nil
+}
+
+// AddTXTRecord rewrites the recordset with the value added, as it was
+// looked up, or creates it with the value when it has no ID.
+func (p *Provider) AddTXTRecord(ctx context.Context, zoneID string, set dnsprovider.RecordSet, record dnsprovider.Record) (dnsprovider.Record, error) {
+	if set.ID == "" {
+		created, err := recordsets.Create(ctx, p.service, zoneID, recordsets.CreateOpts{
+			Name:        absolute(record.Name),
+			Type:        "TXT",
+			Records:     []string{quote(record.Data)},
+			TTL:         record.TTL,
+			Description: record.Comment,
+		}).Extract()
+		if err != nil {
+			return dnsprovider.Record{}, err
+		}
+		return toRecord(*created, record.Data), nil
+	}
+
+	values := make([]string, 0, len(set.Records)+1)
+	for _, r := range set.Records {
+		if r.Data == record.Data {
+			return r, nil
+		}
+		values = append(values, quote(r.Data))
+	}
+
+	updated, err := recordsets.Update(ctx, p.service, zoneID, set.ID, recordsets.UpdateOpts{
+		Records: append(values, quote(record.Data)),
+	}).Extract()
+	if err != nil {
+		return dnsprovider.Record{}, err
+	}
+	return toRecord(*updated, record.Data), nil
+}
+
+// FindTXTRecords returns the values of the name's TXT recordsets that match
+// data.
+func (p *Provider) FindTXTRecords(ctx context.Context, zoneID string, name string, data string) ([]dnsprovider.Record, error) {
+	sets, err := p.recordSets(ctx, zoneID, name)
+	if err != nil {
+		return nil, err
+	}
+
+	var found []dnsprovider.Record
+	for _, rs := range sets {
+		for _, value := range rs.Records {
+			if unquote(value) == data {
+				found = append(found, toRecord(rs, data))
+			}
+		}
+	}
+
+	return found, nil
+}
+
+// ListTXTRecords returns every value of the zone's TXT recordsets. A value
+// is only known to be as old as the last change to its recordset.
+func (p *Provider) ListTXTRecords(ctx context.Context, zoneID string) ([]dnsprovider.Record, error) {
+	sets, err := p.recordSets(ctx, zoneID, "")
+	if err != nil {
+		return nil, err
+	}
+
+	var found []dnsprovider.Record
+	for _, rs := range sets {
+		for _, value := range rs.Records {
+			found = append(found, toRecord(rs, unquote(value)))
+		}
+	}
+
+	return found, nil
+}
+
+// DeleteRecord removes a single value from its recordset, deleting the
+// recordset along with its last value.
+func (p *Provider) DeleteRecord(ctx context.Context, zoneID string, recordID string) error {
+	rsID, data, ok := strings.Cut(recordID, "/")
+	if !ok {
+		return fmt.Errorf("invalid designate record ID `%s`", recordID)
+	}
+
+	rs, err := recordsets.Get(ctx, p.service, zoneID, rsID).Extract()
+	if err != nil {
+		return err
+	}
+
+	remaining := slices.DeleteFunc(slices.Clone(rs.Records), func(value string) bool {
+		return unquote(value) == data
+	})
+
+	if len(remaining) == 0 {
+		return recordsets.Delete(ctx, p.service, zoneID, rsID).ExtractErr()
+	}
+
+	if len(remaining) == len(rs.Records) {
+		return nil
+	}
+
+	_, err = recordsets.Update(ctx, p.service, zoneID, rsID, recordsets.UpdateOpts{
+		Records: remaining,
+	}).Extract()
+	return err
+}
+
+// recordSets lists the TXT recordsets with the name across every page, all
+// of them when name is empty.
+func (p *Provider) recordSets(ctx context.Context, zoneID string, name string) ([]recordsets.RecordSet, error) {
+	opts := recordsets.ListOpts{
+		Type: "TXT",
+	}
+	if name != "" {
+		opts.Name = absolute(name)
+	}
+
+	pages, err := recordsets.ListByZone(p.service, zoneID, opts).AllPages(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("unable to fetch DNS recordsets in designate project: %w", err)
+	}
+
+	sets, err := recordsets.ExtractRecordSets(pages)
+	if err != nil {
+		return nil, fmt.Errorf("unable to fetch DNS recordsets in designate project: %w", err)
+	}
+
+	return sets, nil
+}
+
+func toRecord(rs recordsets.RecordSet, data string) dnsprovider.Record {
+	changed := rs.UpdatedAt
+	if changed.IsZero() {
+		changed = rs.CreatedAt
+	}
+
+	return dnsprovider.Record{
+		ID:      rs.ID + "/" + data,
+		Name:    relative(rs.Name),
+		Type:    rs.Type,
+		Data:    data,
+		TTL:     rs.TTL,
+		Comment: rs.Description,
+		Created: changed,
+	}
+}
+
+// absolute gives a name the trailing dot Designate requires.
+func absolute(name string) string {
+	return name + "."
+}
+
+// relative puts a name returned by Designate in the dnsprovider form.
+func relative(name string) string {
+	return strings.ToLower(strings.TrimSuffix(name, "."))
+}
+
+// quote wraps a TXT value in the quotes Designate requires.
+func quote(data string) string {
+	return `"` + data + `"`
+}
+
+func unquote(value string) string {
+	if len(value) >= 2 && strings.HasPrefix(value, `"`) && strings.HasSuffix(value, `"`) {
+		return value[1 : len(value)-1]
+	}
+	return value
+}
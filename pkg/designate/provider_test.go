@@ -0,0 +1,417 @@
+package designate
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gophercloud/gophercloud/v2"
+
+	"github.com/rackerlabs/cert-manager-webhook-rackspace/pkg/designatetest"
+	"github.com/rackerlabs/cert-manager-webhook-rackspace/pkg/dnsprovider"
+)
+
+func connect(t *testing.T, srv *designatetest.Server) *Provider {
+	t.Helper()
+
+	provider, err := Connect(context.Background(), Config{
+		AuthOptions: gophercloud.AuthOptions{
+			IdentityEndpoint: srv.AuthURL(),
+			Username:         "user",
+			Password:         "password",
+			DomainName:       designatetest.DefaultDomain,
+			TenantName:       "dns",
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return provider
+}
+
+func TestConnect(t *testing.T) {
+	srv := designatetest.NewServer()
+	defer srv.Close()
+
+	project := srv.AddProject("dns")
+	srv.AddUser(project, "user", "password")
+	srv.AddApplicationCredential(project, "app-cred", "app-secret")
+	srv.AddUserApplicationCredential("user", "named", "named-secret")
+	srv.AddZone(project, "example.com")
+
+	for _, tc := range []struct {
+		name    string
+		ao      gophercloud.AuthOptions
+		region  string
+		wantErr string
+	}{
+		{
+			name: "password",
+			ao: gophercloud.AuthOptions{
+				Username: "user", Password: "password", DomainName: designatetest.DefaultDomain,
+				TenantName: "dns",
+			},
+		},
+		{
+			name: "password by project ID",
+			ao: gophercloud.AuthOptions{
+				Username: "user", Password: "password", DomainName: designatetest.DefaultDomain,
+				TenantID: project,
+			},
+			region: designatetest.Region,
+		},
+		{
+			name: "application credential",
+			ao:   gophercloud.AuthOptions{ApplicationCredentialID: "app-cred", ApplicationCredentialSecret: "app-secret"},
+		},
+		{
+			name: "application credential by name",
+			ao: gophercloud.AuthOptions{
+				Username: "user", DomainName: designatetest.DefaultDomain,
+				ApplicationCredentialName: "named", ApplicationCredentialSecret: "named-secret",
+			},
+		},
+		{
+			name: "application credential in the expected project",
+			ao: gophercloud.AuthOptions{
+				ApplicationCredentialID: "app-cred", ApplicationCredentialSecret: "app-secret",
+				Scope: &gophercloud.AuthScope{ProjectName: "dns", DomainName: designatetest.DefaultDomain},
+			},
+		},
+		{
+			name: "application credential in another project",
+			ao: gophercloud.AuthOptions{
+				ApplicationCredentialID: "app-cred", ApplicationCredentialSecret: "app-secret",
+				Scope: &gophercloud.AuthScope{ProjectID: "other"},
+			},
+			wantErr: "application credential is bound to project `dns` in domain `Default`",
+		},
+		{
+			name: "wrong password",
+			ao: gophercloud.AuthOptions{
+				Username: "user", Password: "wrong", DomainName: designatetest.DefaultDomain,
+				TenantName: "dns",
+			},
+			wantErr: "unable to authenticate to keystone as `user`",
+		},
+		{
+			name:    "wrong application credential secret",
+			ao:      gophercloud.AuthOptions{ApplicationCredentialID: "app-cred", ApplicationCredentialSecret: "wrong"},
+			wantErr: "unable to authenticate to keystone as `app-cred`",
+		},
+		{
+			name: "unknown region",
+			ao: gophercloud.AuthOptions{
+				ApplicationCredentialID: "app-cred", ApplicationCredentialSecret: "app-secret",
+			},
+			region:  "RegionTwo",
+			wantErr: "unable to find dns endpoint",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.ao.IdentityEndpoint = srv.AuthURL()
+
+			provider, err := Connect(context.Background(), Config{AuthOptions: tc.ao, Region: tc.region})
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Errorf("expected error %q, got %v", tc.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, err = provider.FindZone(context.Background(), "example.com")
+			if err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestFindZone(t *testing.T) {
+	srv := designatetest.NewServer()
+	defer srv.Close()
+
+	project := srv.AddProject("dns")
+	srv.AddUser(project, "user", "password")
+	zoneID := srv.AddZone(project, "example.com")
+	srv.AddZone(project, "sub.example.com")
+	srv.AddZone(srv.AddProject("other"), "example.org")
+
+	provider := connect(t, srv)
+
+	id, err := provider.FindZone(context.Background(), "example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != zoneID {
+		t.Errorf("id = %v, want %v", id, zoneID)
+	}
+
+	// zones of other projects are not visible
+	_, err = provider.FindZone(context.Background(), "example.org")
+	if err == nil || !strings.Contains(err.Error(), "failed to find zone `example.org`") {
+		t.Errorf("expected error %q, got %v", "failed to find zone `example.org`", err)
+	}
+}
+
+func TestRecords(t *testing.T) {
+	srv := designatetest.NewServer()
+	defer srv.Close()
+
+	project := srv.AddProject("dns")
+	srv.AddUser(project, "user", "password")
+	zoneID := srv.AddZone(project, "example.com")
+
+	provider := connect(t, srv)
+	ctx := context.Background()
+
+	const name = "_acme-challenge.example.com"
+
+	first, err := provider.CreateTXTRecord(ctx, zoneID, dnsprovider.Record{
+		Name: name, Type: "TXT", Data: "key-1", TTL: 300, Comment: "created by test",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.Name != name {
+		t.Errorf("first.Name = %v, want %v", first.Name, name)
+	}
+	if first.Data != "key-1" {
+		t.Errorf("first.Data = %v, want %v", first.Data, "key-1")
+	}
+	if first.TTL != 300 {
+		t.Errorf("first.TTL = %v, want %v", first.TTL, 300)
+	}
+	if first.Comment != "created by test" {
+		t.Errorf("first.Comment = %v, want %v", first.Comment, "created by test")
+	}
+
+	// a second challenge for the same name shares the recordset
+	_, err = provider.CreateTXTRecord(ctx, zoneID, dnsprovider.Record{Name: name, Type: "TXT", Data: "key-2", TTL: 300})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// presenting the same value again does not duplicate it
+	_, err = provider.CreateTXTRecord(ctx, zoneID, dnsprovider.Record{Name: name, Type: "TXT", Data: "key-2", TTL: 300})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sets := srv.RecordSets(zoneID)
+	if len(sets) != 1 {
+		t.Fatalf("expected 1, got %v", sets)
+	}
+	if got, want := sets[0].Records, []string{`"key-1"`, `"key-2"`}; !slices.Equal(got, want) {
+		t.Errorf("sets[0].Records = %v, want %v", got, want)
+	}
+
+	found, err := provider.FindTXTRecords(ctx, zoneID, name, "key-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(found) != 1 {
+		t.Fatalf("expected 1, got %v", found)
+	}
+	if got := found[0].ID; got != first.ID {
+		t.Errorf("found[0].ID = %v, want %v", got, first.ID)
+	}
+
+	found, err = provider.FindTXTRecords(ctx, zoneID, name, "missing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(found) != 0 {
+		t.Errorf("expected none, got %v", found)
+	}
+
+	if err := provider.DeleteRecord(ctx, zoneID, first.ID); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := srv.TXTRecords(name), []string{"key-2"}; !slices.Equal(got, want) {
+		t.Errorf("srv.TXTRecords(name) = %v, want %v", got, want)
+	}
+
+	found, err = provider.FindTXTRecords(ctx, zoneID, name, "key-2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(found) != 1 {
+		t.Fatalf("expected 1, got %v", found)
+	}
+
+	if err := provider.DeleteRecord(ctx, zoneID, found[0].ID); err != nil {
+		t.Fatal(err)
+	}
+	if got := srv.RecordSets(zoneID); len(got) != 0 {
+		t.Errorf("expected none, got %v", got)
+	}
+
+	if err := provider.DeleteRecord(ctx, zoneID, "no-separator"); err == nil || !strings.Contains(err.Error(), "invalid designate record ID") {
+		t.Errorf("expected error %q, got %v", "invalid designate record ID", err)
+	}
+}
+
+func TestTXTRecordSet(t *testing.T) {
+	srv := designatetest.NewServer()
+	defer srv.Close()
+
+	project := srv.AddProject("dns")
+	srv.AddUser(project, "user", "password")
+	zoneID := srv.AddZone(project, "example.com")
+
+	provider := connect(t, srv)
+	ctx := context.Background()
+
+	const name = "_acme-challenge.example.com"
+
+	set, err := provider.TXTRecordSet(ctx, zoneID, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if set.ID != "" {
+		t.Fatalf("expected no recordset, got %v", set)
+	}
+
+	for _, comment := range []string{"created by blue", "created by green"} {
+		if _, err := provider.AddTXTRecord(ctx, zoneID, set, dnsprovider.Record{Name: name, Type: "TXT", Data: comment, TTL: 300, Comment: comment}); err != nil {
+			t.Fatal(err)
+		}
+		if set, err = provider.TXTRecordSet(ctx, zoneID, name); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the recordset keeps the description it was created with
+	if set.Comment != "created by blue" {
+		t.Errorf("set.Comment = %q, want the first one", set.Comment)
+	}
+	var values []string
+	for _, r := range set.Records {
+		values = append(values, r.Data)
+	}
+	if want := []string{"created by blue", "created by green"}; !slices.Equal(values, want) {
+		t.Errorf("got %v, want %v", values, want)
+	}
+
+	// a value already in the set is returned as it is
+	record, err := provider.AddTXTRecord(ctx, zoneID, set, dnsprovider.Record{Name: name, Type: "TXT", Data: "created by green", TTL: 300})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if record != set.Records[1] {
+		t.Errorf("got %v, want %v", record, set.Records[1])
+	}
+	if got := srv.TXTRecords(name); len(got) != 2 {
+		t.Errorf("expected 2, got %v", got)
+	}
+}
+
+func TestListTXTRecords(t *testing.T) {
+	srv := designatetest.NewServer()
+	defer srv.Close()
+
+	project := srv.AddProject("dns")
+	srv.AddUser(project, "user", "password")
+	zoneID := srv.AddZone(project, "example.com")
+
+	provider := connect(t, srv)
+	ctx := context.Background()
+
+	for _, r := range []dnsprovider.Record{
+		{Name: "_acme-challenge.example.com", Data: "key-1", Comment: "created by test"},
+		{Name: "_acme-challenge.example.com", Data: "key-2"},
+		{Name: "_acme-challenge.www.example.com", Data: "key-3"},
+	} {
+		r.Type, r.TTL = "TXT", 300
+		_, err := provider.CreateTXTRecord(ctx, zoneID, r)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	listed, err := provider.ListTXTRecords(ctx, zoneID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(listed) != 3 {
+		t.Fatalf("expected 3, got %v", listed)
+	}
+
+	var data []string
+	for _, r := range listed {
+		data = append(data, r.Data)
+		if d := time.Since(r.Created); d < 0 || d > time.Minute {
+			t.Errorf("created at %s", r.Created)
+		}
+	}
+	slices.Sort(data)
+	if !slices.Equal(data, []string{"key-1", "key-2", "key-3"}) {
+		t.Errorf("data = %v, want [key-1 key-2 key-3]", data)
+	}
+
+	// every value lists with an ID it can be deleted by
+	if err := provider.DeleteRecord(ctx, zoneID, listed[0].ID); err != nil {
+		t.Fatal(err)
+	}
+	listed, err = provider.ListTXTRecords(ctx, zoneID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(listed) != 2 {
+		t.Errorf("expected 2, got %v", listed)
+	}
+}
+
+func TestTokenRenewal(t *testing.T) {
+	srv := designatetest.NewServer()
+	defer srv.Close()
+
+	project := srv.AddProject("dns")
+	srv.AddUser(project, "user", "password")
+	srv.AddApplicationCredential(project, "app-cred", "app-secret")
+	srv.AddZone(project, "example.com")
+
+	for _, tc := range []struct {
+		name string
+		ao   gophercloud.AuthOptions
+	}{
+		{
+			name: "password",
+			ao: gophercloud.AuthOptions{
+				Username: "user", Password: "password", DomainName: designatetest.DefaultDomain,
+				TenantName: "dns",
+			},
+		},
+		{
+			name: "application credential",
+			ao: gophercloud.AuthOptions{
+				ApplicationCredentialID: "app-cred", ApplicationCredentialSecret: "app-secret",
+				Scope: &gophercloud.AuthScope{ProjectID: project},
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.ao.IdentityEndpoint = srv.AuthURL()
+
+			provider, err := Connect(context.Background(), Config{AuthOptions: tc.ao})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// the token is reused until it expires, then renewed once
+			srv.ExpireTokens()
+
+			_, err = provider.FindZone(context.Background(), "example.com")
+			if err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
@@ -0,0 +1,297 @@
+package designatetest
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// timeLayout is how Designate formats times, in UTC without a zone.
+const timeLayout = "2006-01-02T15:04:05.000000"
+
+type recordSetRequest struct {
+	Name        string   `json:"name"`
+	Type        string   `json:"type"`
+	Records     []string `json:"records"`
+	TTL         *int     `json:"ttl"`
+	Description *string  `json:"description"`
+}
+
+func (z *Zone) toJSON() map[string]any {
+	return map[string]any{
+		"id":         z.ID,
+		"name":       z.Name,
+		"project_id": z.ProjectID,
+		"status":     "ACTIVE",
+		"type":       "PRIMARY",
+	}
+}
+
+func (rs *RecordSet) toJSON(zone *Zone) map[string]any {
+	var updated any
+	if !rs.Updated.IsZero() {
+		updated = rs.Updated.UTC().Format(timeLayout)
+	}
+
+	return map[string]any{
+		"id":          rs.ID,
+		"zone_id":     rs.ZoneID,
+		"zone_name":   zone.Name,
+		"project_id":  zone.ProjectID,
+		"name":        rs.Name,
+		"type":        rs.Type,
+		"records":     rs.Records,
+		"ttl":         rs.TTL,
+		"description": rs.Description,
+		"status":      "ACTIVE",
+		"action":      "NONE",
+		"created_at":  rs.Created.UTC().Format(timeLayout),
+		"updated_at":  updated,
+	}
+}
+
+// validRecords reports whether every value is acceptable for the type,
+// Designate requires TXT values to be quoted.
+func validRecords(typ string, records []string) bool {
+	if len(records) == 0 {
+		return false
+	}
+	for _, r := range records {
+		if typ == "TXT" && (len(r) < 2 || !strings.HasPrefix(r, `"`) || !strings.HasSuffix(r, `"`)) {
+			return false
+		}
+	}
+	return true
+}
+
+// projectZone returns the zone of the request path when it belongs to the
+// project. Callers must hold s.mu.
+func (s *Server) projectZone(r *http.Request, projectID string) (*Zone, bool) {
+	zone, ok := s.zones[r.PathValue("zone")]
+	if !ok || zone.ProjectID != projectID {
+		return nil, false
+	}
+	return zone, true
+}
+
+// zoneRecordSet returns the recordset of the request path when it belongs
+// to the zone. Callers must hold s.mu.
+func (s *Server) zoneRecordSet(r *http.Request, zone *Zone) (*RecordSet, bool) {
+	rs, ok := s.recordSets[r.PathValue("recordset")]
+	if !ok || rs.ZoneID != zone.ID {
+		return nil, false
+	}
+	return rs, true
+}
+
+// handleVersions serves the version discovery document gophercloud reads
+// before using the DNS endpoint.
+func (s *Server) handleVersions(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{
+		"versions": []map[string]any{{
+			"id":     "v2.0",
+			"status": "CURRENT",
+			"links":  []map[string]any{{"href": s.URL + "/dns/v2/", "rel": "self"}},
+		}},
+	})
+}
+
+func (s *Server) handleListZones(w http.ResponseWriter, r *http.Request, projectID string) {
+	name := r.URL.Query().Get("name")
+
+	s.mu.Lock()
+	var zones []*Zone
+	for _, z := range s.zones {
+		if z.ProjectID != projectID {
+			continue
+		}
+		if name != "" && z.Name != strings.ToLower(name) {
+			continue
+		}
+		zones = append(zones, z)
+	}
+	sort.Slice(zones, func(i, j int) bool { return zones[i].ID < zones[j].ID })
+
+	list := make([]map[string]any, 0, len(zones))
+	for _, z := range zones {
+		list = append(list, z.toJSON())
+	}
+	s.mu.Unlock()
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"zones":    list,
+		"links":    map[string]any{"self": s.URL + r.URL.String()},
+		"metadata": map[string]any{"total_count": len(list)},
+	})
+}
+
+func (s *Server) handleListRecordSets(w http.ResponseWriter, r *http.Request, projectID string) {
+	query := r.URL.Query()
+
+	s.mu.Lock()
+	zone, ok := s.projectZone(r, projectID)
+	if !ok {
+		s.mu.Unlock()
+		writeError(w, http.StatusNotFound, "Could not find Zone")
+		return
+	}
+
+	var sets []*RecordSet
+	for _, rs := range s.recordSets {
+		if rs.ZoneID != zone.ID {
+			continue
+		}
+		if name := query.Get("name"); name != "" && rs.Name != strings.ToLower(name) {
+			continue
+		}
+		if typ := query.Get("type"); typ != "" && rs.Type != typ {
+			continue
+		}
+		sets = append(sets, rs)
+	}
+	sort.Slice(sets, func(i, j int) bool { return sets[i].ID < sets[j].ID })
+
+	list := make([]map[string]any, 0, len(sets))
+	for _, rs := range sets {
+		list = append(list, rs.toJSON(zone))
+	}
+	s.mu.Unlock()
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"recordsets": list,
+		"links":      map[string]any{"self": s.URL + r.URL.String()},
+		"metadata":   map[string]any{"total_count": len(list)},
+	})
+}
+
+func (s *Server) handleCreateRecordSet(w http.ResponseWriter, r *http.Request, projectID string) {
+	var req recordSetRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid json request body")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	zone, ok := s.projectZone(r, projectID)
+	if !ok {
+		writeError(w, http.StatusNotFound, "Could not find Zone")
+		return
+	}
+
+	name := strings.ToLower(req.Name)
+	if !strings.HasSuffix(name, ".") || (name != zone.Name && !strings.HasSuffix(name, "."+zone.Name)) {
+		writeError(w, http.StatusBadRequest, "RecordSet is not contained within it's parent zone")
+		return
+	}
+	if !validRecords(req.Type, req.Records) {
+		writeError(w, http.StatusBadRequest, "Invalid records for type "+req.Type)
+		return
+	}
+
+	for _, rs := range s.recordSets {
+		if rs.ZoneID == zone.ID && rs.Name == name && rs.Type == req.Type {
+			writeError(w, http.StatusConflict, "Duplicate RecordSet")
+			return
+		}
+	}
+
+	rs := &RecordSet{
+		ID:      s.newID(),
+		ZoneID:  zone.ID,
+		Name:    name,
+		Type:    req.Type,
+		Records: req.Records,
+		TTL:     3600,
+		Created: time.Now(),
+	}
+	if req.TTL != nil {
+		rs.TTL = *req.TTL
+	}
+	if req.Description != nil {
+		rs.Description = *req.Description
+	}
+	s.recordSets[rs.ID] = rs
+
+	writeJSON(w, http.StatusAccepted, rs.toJSON(zone))
+}
+
+func (s *Server) handleGetRecordSet(w http.ResponseWriter, r *http.Request, projectID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	zone, ok := s.projectZone(r, projectID)
+	if !ok {
+		writeError(w, http.StatusNotFound, "Could not find Zone")
+		return
+	}
+	rs, ok := s.zoneRecordSet(r, zone)
+	if !ok {
+		writeError(w, http.StatusNotFound, "Could not find RecordSet")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, rs.toJSON(zone))
+}
+
+func (s *Server) handleUpdateRecordSet(w http.ResponseWriter, r *http.Request, projectID string) {
+	var req recordSetRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid json request body")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	zone, ok := s.projectZone(r, projectID)
+	if !ok {
+		writeError(w, http.StatusNotFound, "Could not find Zone")
+		return
+	}
+	rs, ok := s.zoneRecordSet(r, zone)
+	if !ok {
+		writeError(w, http.StatusNotFound, "Could not find RecordSet")
+		return
+	}
+
+	if req.Records != nil {
+		if !validRecords(rs.Type, req.Records) {
+			writeError(w, http.StatusBadRequest, "Invalid records for type "+rs.Type)
+			return
+		}
+		rs.Records = req.Records
+	}
+	if req.TTL != nil {
+		rs.TTL = *req.TTL
+	}
+	if req.Description != nil {
+		rs.Description = *req.Description
+	}
+	rs.Updated = time.Now()
+
+	writeJSON(w, http.StatusAccepted, rs.toJSON(zone))
+}
+
+func (s *Server) handleDeleteRecordSet(w http.ResponseWriter, r *http.Request, projectID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	zone, ok := s.projectZone(r, projectID)
+	if !ok {
+		writeError(w, http.StatusNotFound, "Could not find Zone")
+		return
+	}
+	rs, ok := s.zoneRecordSet(r, zone)
+	if !ok {
+		writeError(w, http.StatusNotFound, "Could not find RecordSet")
+		return
+	}
+
+	delete(s.recordSets, rs.ID)
+
+	writeJSON(w, http.StatusAccepted, rs.toJSON(zone))
+}
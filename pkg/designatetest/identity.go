@@ -0,0 +1,190 @@
+package designatetest
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// tokenLifetime is how long issued tokens claim to be valid for.
+const tokenLifetime = 24 * time.Hour
+
+type domainRef struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type tokenRequest struct {
+	Auth struct {
+		Identity struct {
+			Methods  []string `json:"methods"`
+			Password *struct {
+				User struct {
+					Name     string     `json:"name"`
+					Password string     `json:"password"`
+					Domain   *domainRef `json:"domain"`
+				} `json:"user"`
+			} `json:"password"`
+			ApplicationCredential *struct {
+				ID     string `json:"id"`
+				Name   string `json:"name"`
+				Secret string `json:"secret"`
+				User   *struct {
+					Name   string     `json:"name"`
+					Domain *domainRef `json:"domain"`
+				} `json:"user"`
+			} `json:"application_credential"`
+		} `json:"identity"`
+		Scope *struct {
+			Project *struct {
+				ID     string     `json:"id"`
+				Name   string     `json:"name"`
+				Domain *domainRef `json:"domain"`
+			} `json:"project"`
+		} `json:"scope"`
+	} `json:"auth"`
+}
+
+// isDefaultDomain reports whether the reference names DefaultDomain, the
+// only domain of the fake.
+func isDefaultDomain(d *domainRef) bool {
+	return d != nil && (d.ID == strings.ToLower(DefaultDomain) || d.Name == DefaultDomain)
+}
+
+func (s *Server) handleTokens(w http.ResponseWriter, r *http.Request) {
+	var req tokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid json request body")
+		return
+	}
+
+	identity := req.Auth.Identity
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var projectID string
+	switch {
+	case identity.Password != nil:
+		u := identity.Password.User
+		if !isDefaultDomain(u.Domain) {
+			writeError(w, http.StatusUnauthorized, "The request you have made requires authentication.")
+			return
+		}
+		user, ok := s.users[userKey(DefaultDomain, u.Name)]
+		if !ok || user.Password != u.Password {
+			writeError(w, http.StatusUnauthorized, "The request you have made requires authentication.")
+			return
+		}
+		projectID = s.scopedProject(req, user.ProjectID)
+	case identity.ApplicationCredential != nil:
+		ac := identity.ApplicationCredential
+		cred, ok := s.appCreds[ac.ID]
+		if ac.ID == "" && ac.User != nil && isDefaultDomain(ac.User.Domain) {
+			cred, ok = s.namedApplicationCredential(ac.User.Name, ac.Name)
+		}
+		if !ok || cred.Secret != ac.Secret {
+			writeError(w, http.StatusUnauthorized, "The request you have made requires authentication.")
+			return
+		}
+		if req.Auth.Scope != nil {
+			writeError(w, http.StatusUnauthorized, "Application credentials cannot request a scope.")
+			return
+		}
+		projectID = cred.ProjectID
+	default:
+		writeError(w, http.StatusBadRequest, "no supported credentials in request")
+		return
+	}
+
+	if projectID == "" {
+		writeError(w, http.StatusUnauthorized, "User has no access to project")
+		return
+	}
+
+	token := "token-" + s.newID()
+	s.tokens[token] = projectID
+	project := s.projects[projectID]
+
+	w.Header().Set("X-Subject-Token", token)
+	writeJSON(w, http.StatusCreated, map[string]any{
+		"token": map[string]any{
+			"methods":    identity.Methods,
+			"expires_at": time.Now().Add(tokenLifetime).UTC().Format("2006-01-02T15:04:05.000000Z"),
+			"project": map[string]any{
+				"id":     project.ID,
+				"name":   project.Name,
+				"domain": map[string]any{"id": strings.ToLower(project.Domain), "name": project.Domain},
+			},
+			"catalog": []map[string]any{{
+				"id":   "dns",
+				"name": "designate",
+				"type": "dns",
+				"endpoints": []map[string]any{{
+					"id":        "dns-public",
+					"interface": "public",
+					"region":    Region,
+					"region_id": Region,
+					"url":       s.URL + "/dns/",
+				}},
+			}},
+		},
+	})
+}
+
+// namedApplicationCredential finds a user's application credential by name.
+func (s *Server) namedApplicationCredential(username string, name string) (ApplicationCredential, bool) {
+	for _, cred := range s.appCreds {
+		if cred.User != "" && cred.User == username && cred.Name == name {
+			return cred, true
+		}
+	}
+	return ApplicationCredential{}, false
+}
+
+// scopedProject returns the project a password token is scoped to, empty
+// when the user has no role on the requested project.
+func (s *Server) scopedProject(req tokenRequest, userProjectID string) string {
+	if req.Auth.Scope == nil || req.Auth.Scope.Project == nil {
+		return ""
+	}
+
+	scope := req.Auth.Scope.Project
+	project, ok := s.projects[userProjectID]
+	if !ok {
+		return ""
+	}
+
+	switch {
+	case scope.ID != "":
+		if scope.ID != project.ID {
+			return ""
+		}
+	case scope.Name != "":
+		if scope.Name != project.Name || !isDefaultDomain(scope.Domain) {
+			return ""
+		}
+	default:
+		return ""
+	}
+
+	return project.ID
+}
+
+// authorized rejects requests without a valid token and passes the token's
+// project to the handler.
+func (s *Server) authorized(next func(w http.ResponseWriter, r *http.Request, projectID string)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		projectID, ok := s.tokens[r.Header.Get("X-Auth-Token")]
+		s.mu.Unlock()
+
+		if !ok {
+			writeError(w, http.StatusUnauthorized, "The request you have made requires authentication.")
+			return
+		}
+
+		next(w, r, projectID)
+	}
+}
@@ -0,0 +1,275 @@
+// Package designatetest provides an in-process fake of the OpenStack Keystone
+// v3 and Designate v2 APIs for use in tests.
+//
+// The fake implements enough of both APIs for gophercloud: password and
+// application credential token issuance with a service catalog, zone listing
+// and recordset listing, creation, update and deletion. State is kept in
+// memory and can be inspected or seeded from tests.
+//
+//	srv := designatetest.NewServer()
+//	defer srv.Close()
+//	project := srv.AddProject("dns")
+//	srv.AddUser(project, "user", "password")
+//	srv.AddZone(project, "example.com")
+//	opts.IdentityEndpoint = srv.AuthURL()
+package designatetest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultDomain is the Keystone domain projects and users are created in.
+const DefaultDomain = "Default"
+
+// Region is the region of the DNS endpoint in the service catalog.
+const Region = "RegionOne"
+
+// Project is a Keystone project that owns zones.
+type Project struct {
+	ID     string
+	Name   string
+	Domain string
+}
+
+// User is a Keystone user with a role on a single project.
+type User struct {
+	Name      string
+	Password  string
+	Domain    string
+	ProjectID string
+}
+
+// ApplicationCredential is a Keystone application credential, always scoped
+// to the project it was created in. Credentials owned by a user may also be
+// looked up by their name and the user's name.
+type ApplicationCredential struct {
+	ID        string
+	Name      string
+	User      string
+	Secret    string
+	ProjectID string
+}
+
+// Zone is a Designate zone held by the fake.
+type Zone struct {
+	ID        string
+	Name      string // with the trailing dot
+	ProjectID string
+}
+
+// RecordSet is a Designate recordset held by the fake.
+type RecordSet struct {
+	ID          string
+	ZoneID      string
+	Name        string // with the trailing dot
+	Type        string
+	Records     []string
+	TTL         int
+	Description string
+	Created     time.Time
+	Updated     time.Time // zero until the recordset is first updated
+}
+
+// Server is a fake Keystone and Designate API served over HTTP.
+type Server struct {
+	*httptest.Server
+
+	mu         sync.Mutex
+	projects   map[string]Project
+	users      map[string]User // by domain and name
+	appCreds   map[string]ApplicationCredential
+	tokens     map[string]string // token to project ID
+	zones      map[string]*Zone
+	recordSets map[string]*RecordSet
+	latency    time.Duration
+	nextID     int
+}
+
+// NewServer starts a fake Keystone and Designate API. Close it once done.
+func NewServer() *Server {
+	s := &Server{
+		projects:   make(map[string]Project),
+		users:      make(map[string]User),
+		appCreds:   make(map[string]ApplicationCredential),
+		tokens:     make(map[string]string),
+		zones:      make(map[string]*Zone),
+		recordSets: make(map[string]*RecordSet),
+		nextID:     1000,
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /v3/auth/tokens", s.handleTokens)
+	mux.HandleFunc("GET /dns/{$}", s.handleVersions)
+	mux.HandleFunc("GET /dns/v2/zones", s.authorized(s.handleListZones))
+	mux.HandleFunc("GET /dns/v2/zones/{zone}/recordsets", s.authorized(s.handleListRecordSets))
+	mux.HandleFunc("POST /dns/v2/zones/{zone}/recordsets", s.authorized(s.handleCreateRecordSet))
+	mux.HandleFunc("GET /dns/v2/zones/{zone}/recordsets/{recordset}", s.authorized(s.handleGetRecordSet))
+	mux.HandleFunc("PUT /dns/v2/zones/{zone}/recordsets/{recordset}", s.authorized(s.handleUpdateRecordSet))
+	mux.HandleFunc("DELETE /dns/v2/zones/{zone}/recordsets/{recordset}", s.authorized(s.handleDeleteRecordSet))
+
+	s.Server = httptest.NewServer(s.delayed(mux))
+
+	return s
+}
+
+// AuthURL is the URL to use as the Keystone v3 identity endpoint.
+func (s *Server) AuthURL() string {
+	return s.URL + "/v3"
+}
+
+// AddProject creates a project in DefaultDomain and returns its ID.
+func (s *Server) AddProject(name string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id := s.newID()
+	s.projects[id] = Project{ID: id, Name: name, Domain: DefaultDomain}
+
+	return id
+}
+
+// AddUser registers a user in DefaultDomain with a role on the project.
+func (s *Server) AddUser(projectID string, name string, password string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.users[userKey(DefaultDomain, name)] = User{Name: name, Password: password, Domain: DefaultDomain, ProjectID: projectID}
+}
+
+// AddApplicationCredential registers an application credential for the
+// project.
+func (s *Server) AddApplicationCredential(projectID string, id string, secret string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.appCreds[id] = ApplicationCredential{ID: id, Secret: secret, ProjectID: projectID}
+}
+
+// AddUserApplicationCredential registers an application credential owned by
+// a user, bound to the user's project, and returns its ID.
+func (s *Server) AddUserApplicationCredential(username string, name string, secret string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user := s.users[userKey(DefaultDomain, username)]
+
+	id := "app-cred-" + s.newID()
+	s.appCreds[id] = ApplicationCredential{ID: id, Name: name, User: user.Name, Secret: secret, ProjectID: user.ProjectID}
+
+	return id
+}
+
+// AddZone creates a zone in the project and returns its ID.
+func (s *Server) AddZone(projectID string, name string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id := s.newID()
+	s.zones[id] = &Zone{ID: id, Name: fqdn(name), ProjectID: projectID}
+
+	return id
+}
+
+// RecordSets returns the recordsets held in a zone, ordered by ID.
+func (s *Server) RecordSets(zoneID string) []RecordSet {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var sets []RecordSet
+	for _, rs := range s.recordSets {
+		if rs.ZoneID == zoneID {
+			sets = append(sets, *rs)
+		}
+	}
+	sort.Slice(sets, func(i, j int) bool { return sets[i].ID < sets[j].ID })
+
+	return sets
+}
+
+// TXTRecords returns the unquoted values of every TXT recordset with the
+// given name, across all zones.
+func (s *Server) TXTRecords(name string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	name = fqdn(name)
+
+	var data []string
+	for _, rs := range s.recordSets {
+		if rs.Type != "TXT" || rs.Name != name {
+			continue
+		}
+		for _, r := range rs.Records {
+			data = append(data, strings.Trim(r, `"`))
+		}
+	}
+	sort.Strings(data)
+
+	return data
+}
+
+// SetLatency delays every response by d.
+func (s *Server) SetLatency(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.latency = d
+}
+
+// ExpireTokens invalidates every issued token, the next request made with
+// one of them fails with 401 as it would once a token expires.
+func (s *Server) ExpireTokens() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.tokens = make(map[string]string)
+}
+
+// delayed applies the latency set by SetLatency before serving a request.
+func (s *Server) delayed(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		latency := s.latency
+		s.mu.Unlock()
+
+		time.Sleep(latency)
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *Server) newID() string {
+	s.nextID++
+	return strconv.Itoa(s.nextID)
+}
+
+func userKey(domain string, name string) string {
+	return domain + "/" + name
+}
+
+// fqdn lower cases a name and gives it the trailing dot Designate uses.
+func fqdn(name string) string {
+	return strings.ToLower(strings.TrimSuffix(name, ".")) + "."
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]any{
+		"code":    status,
+		"type":    strings.ReplaceAll(strings.ToLower(http.StatusText(status)), " ", "_"),
+		"message": message,
+		"details": fmt.Sprintf("%d %s", status, http.StatusText(status)),
+	})
+}
@@ -0,0 +1,180 @@
+package designatetest
+
+import (
+	"context"
+	"net/http"
+	"slices"
+	"testing"
+
+	"github.com/gophercloud/gophercloud/v2"
+	"github.com/gophercloud/gophercloud/v2/openstack"
+	"github.com/gophercloud/gophercloud/v2/openstack/dns/v2/recordsets"
+	"github.com/gophercloud/gophercloud/v2/openstack/dns/v2/zones"
+)
+
+func newClient(t *testing.T, srv *Server, ao gophercloud.AuthOptions) (*gophercloud.ServiceClient, error) {
+	t.Helper()
+
+	ao.IdentityEndpoint = srv.AuthURL()
+
+	provider, err := openstack.AuthenticatedClient(context.Background(), ao)
+	if err != nil {
+		return nil, err
+	}
+
+	return openstack.NewDNSV2(provider, gophercloud.EndpointOpts{})
+}
+
+func TestAuthentication(t *testing.T) {
+	srv := NewServer()
+	defer srv.Close()
+
+	project := srv.AddProject("dns")
+	srv.AddUser(project, "user", "password")
+	srv.AddApplicationCredential(project, "app-cred", "app-secret")
+
+	for _, tc := range []struct {
+		name   string
+		ao     gophercloud.AuthOptions
+		wantOK bool
+	}{
+		{
+			name:   "password scoped by name",
+			ao:     gophercloud.AuthOptions{Username: "user", Password: "password", DomainName: DefaultDomain, TenantName: "dns"},
+			wantOK: true,
+		},
+		{
+			name:   "password scoped by ID",
+			ao:     gophercloud.AuthOptions{Username: "user", Password: "password", DomainID: "default", TenantID: project},
+			wantOK: true,
+		},
+		{
+			name: "password for another project",
+			ao:   gophercloud.AuthOptions{Username: "user", Password: "password", DomainName: DefaultDomain, TenantName: "other"},
+		},
+		{
+			name: "wrong password",
+			ao:   gophercloud.AuthOptions{Username: "user", Password: "wrong", DomainName: DefaultDomain, TenantName: "dns"},
+		},
+		{
+			name:   "application credential",
+			ao:     gophercloud.AuthOptions{ApplicationCredentialID: "app-cred", ApplicationCredentialSecret: "app-secret"},
+			wantOK: true,
+		},
+		{
+			name: "wrong application credential secret",
+			ao:   gophercloud.AuthOptions{ApplicationCredentialID: "app-cred", ApplicationCredentialSecret: "wrong"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := newClient(t, srv, tc.ao)
+			if tc.wantOK {
+				if err != nil {
+					t.Error(err)
+				}
+			} else {
+				if !gophercloud.ResponseCodeIs(err, http.StatusUnauthorized) {
+					t.Errorf("got %v", err)
+				}
+			}
+		})
+	}
+}
+
+func TestRecordSetLifecycle(t *testing.T) {
+	srv := NewServer()
+	defer srv.Close()
+
+	project := srv.AddProject("dns")
+	srv.AddApplicationCredential(project, "app-cred", "app-secret")
+	zoneID := srv.AddZone(project, "Example.com")
+	srv.AddZone(srv.AddProject("other"), "example.org")
+
+	client, err := newClient(t, srv, gophercloud.AuthOptions{ApplicationCredentialID: "app-cred", ApplicationCredentialSecret: "app-secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+
+	pages, err := zones.List(client, zones.ListOpts{}).AllPages(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zoneList, err := zones.ExtractZones(pages)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(zoneList) != 1 {
+		t.Fatalf("zones of other projects are hidden: expected 1, got %v", zoneList)
+	}
+	if got := zoneList[0].Name; got != "example.com." {
+		t.Errorf("zoneList[0].Name = %v, want %v", got, "example.com.")
+	}
+
+	_, err = recordsets.Create(ctx, client, zoneID, recordsets.CreateOpts{
+		Name: "_acme-challenge.example.com.", Type: "TXT", Records: []string{"unquoted"},
+	}).Extract()
+	if !gophercloud.ResponseCodeIs(err, http.StatusBadRequest) {
+		t.Errorf("TXT values must be quoted, got %v", err)
+	}
+
+	_, err = recordsets.Create(ctx, client, zoneID, recordsets.CreateOpts{
+		Name: "_acme-challenge.example.org.", Type: "TXT", Records: []string{`"key"`},
+	}).Extract()
+	if !gophercloud.ResponseCodeIs(err, http.StatusBadRequest) {
+		t.Errorf("names outside the zone are rejected, got %v", err)
+	}
+
+	rs, err := recordsets.Create(ctx, client, zoneID, recordsets.CreateOpts{
+		Name: "_acme-challenge.example.com.", Type: "TXT", Records: []string{`"key"`}, TTL: 300,
+	}).Extract()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := srv.TXTRecords("_acme-challenge.example.com"), []string{"key"}; !slices.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	_, err = recordsets.Create(ctx, client, zoneID, recordsets.CreateOpts{
+		Name: "_acme-challenge.example.com.", Type: "TXT", Records: []string{`"other"`},
+	}).Extract()
+	if !gophercloud.ResponseCodeIs(err, http.StatusConflict) {
+		t.Errorf("got %v", err)
+	}
+
+	_, err = recordsets.Update(ctx, client, zoneID, rs.ID, recordsets.UpdateOpts{Records: []string{`"key"`, `"other"`}}).Extract()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := srv.TXTRecords("_acme-challenge.example.com"), []string{"key", "other"}; !slices.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	if err := recordsets.Delete(ctx, client, zoneID, rs.ID).ExtractErr(); err != nil {
+		t.Fatal(err)
+	}
+	if got := srv.RecordSets(zoneID); len(got) != 0 {
+		t.Errorf("expected none, got %v", got)
+	}
+}
+
+func TestExpireTokens(t *testing.T) {
+	srv := NewServer()
+	defer srv.Close()
+
+	project := srv.AddProject("dns")
+	srv.AddApplicationCredential(project, "app-cred", "app-secret")
+
+	client, err := newClient(t, srv, gophercloud.AuthOptions{ApplicationCredentialID: "app-cred", ApplicationCredentialSecret: "app-secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv.ExpireTokens()
+
+	_, err = zones.List(client, zones.ListOpts{}).AllPages(context.Background())
+	if !gophercloud.ResponseCodeIs(err, http.StatusUnauthorized) {
+		t.Errorf("got %v", err)
+	}
+}
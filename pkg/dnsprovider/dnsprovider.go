@@ -0,0 +1,67 @@
+// Package dnsprovider defines what the solver needs from a DNS hosting
+// service, so that the solver can be embedded in other tools and run against
+// different backends.
+package dnsprovider
+
+import (
+	"context"
+	"time"
+)
+
+// Record is a DNS record held by a provider.
+type Record struct {
+	ID      string
+	Name    string
+	Type    string
+	Data    string
+	TTL     int
+	Comment string
+	// Created is when the record was created, or last changed for providers
+	// that do not tell, zero when unknown.
+	Created time.Time
+}
+
+// Provider manages the records of the zones in a single account. Names are
+// passed in lower case and without a trailing dot.
+type Provider interface {
+	// FindZone returns the ID of the zone named exactly zone.
+	FindZone(ctx context.Context, zone string) (string, error)
+
+	// CreateTXTRecord creates a TXT record in a zone and returns it as stored.
+	CreateTXTRecord(ctx context.Context, zoneID string, record Record) (Record, error)
+
+	// FindTXTRecords returns every TXT record in a zone with the name and data.
+	FindTXTRecords(ctx context.Context, zoneID string, name string, data string) ([]Record, error)
+
+	// DeleteRecord deletes a record from a zone.
+	DeleteRecord(ctx context.Context, zoneID string, recordID string) error
+}
+
+// Lister is implemented by providers that can list every TXT record of a
+// zone, which sweeping stale records needs.
+type Lister interface {
+	// ListTXTRecords returns every TXT record in a zone.
+	ListTXTRecords(ctx context.Context, zoneID string) ([]Record, error)
+}
+
+// RecordSet is the TXT record set of a name.
+type RecordSet struct {
+	// ID is empty when the name has no record set yet.
+	ID      string
+	Comment string
+	// Records holds a Record for every value of the set.
+	Records []Record
+}
+
+// RecordSets is implemented by providers that keep every TXT value of a name
+// in a single record set with a single comment, so that a value cannot be told
+// apart from the others by its comment. The set is looked up once and then
+// added to as it was found.
+type RecordSets interface {
+	// TXTRecordSet returns the name's TXT record set.
+	TXTRecordSet(ctx context.Context, zoneID string, name string) (RecordSet, error)
+
+	// AddTXTRecord adds a value to a record set returned by TXTRecordSet,
+	// creating the set when it has no ID, and returns the value as stored.
+	AddTXTRecord(ctx context.Context, zoneID string, set RecordSet, record Record) (Record, error)
+}
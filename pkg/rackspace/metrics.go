@@ -0,0 +1,48 @@
+package rackspace
+
+import (
+	"k8s.io/component-base/metrics"
+	"k8s.io/component-base/metrics/legacyregistry"
+)
+
+const (
+	apiKeyPrimary   = "primary"
+	apiKeySecondary = "secondary"
+)
+
+// apiKeyInUse reports which API key from a credentials Secret last
+// authenticated successfully, so key rotation can be confirmed as complete.
+var apiKeyInUse = metrics.NewGaugeVec(
+	&metrics.GaugeOpts{
+		Namespace:      "cert_manager_webhook_rackspace",
+		Name:           "api_key_in_use",
+		Help:           "Set to 1 for the API key of a credentials Secret that last authenticated successfully.",
+		StabilityLevel: metrics.ALPHA,
+	},
+	[]string{"secret", "key"},
+)
+
+// createdParseErrors counts records listed with a creation time that could not
+// be parsed, which the sweeper then never deletes.
+var createdParseErrors = metrics.NewCounter(
+	&metrics.CounterOpts{
+		Namespace:      "cert_manager_webhook_rackspace",
+		Name:           "record_created_parse_errors_total",
+		Help:           "Number of listed records whose creation time could not be parsed.",
+		StabilityLevel: metrics.ALPHA,
+	},
+)
+
+func init() {
+	legacyregistry.MustRegister(apiKeyInUse, createdParseErrors)
+}
+
+func recordApiKeyInUse(secretRef string, key string) {
+	for _, k := range []string{apiKeyPrimary, apiKeySecondary} {
+		value := 0.0
+		if k == key {
+			value = 1
+		}
+		apiKeyInUse.WithLabelValues(secretRef, k).Set(value)
+	}
+}
@@ -0,0 +1,169 @@
+package rackspace
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"k8s.io/klog/v2"
+
+	"github.com/gophercloud/gophercloud/v2"
+	"github.com/gophercloud/gophercloud/v2/pagination"
+
+	"github.com/rackerlabs/cert-manager-webhook-rackspace/pkg/dnsprovider"
+	"github.com/rackerlabs/goclouddns/domains"
+	"github.com/rackerlabs/goclouddns/records"
+)
+
+// Provider is a dnsprovider.Provider for the Cloud DNS service of a single
+// Rackspace account.
+type Provider struct {
+	service *gophercloud.ServiceClient
+}
+
+var (
+	_ dnsprovider.Provider = (*Provider)(nil)
+	_ dnsprovider.Lister   = (*Provider)(nil)
+)
+
+// New wraps an authenticated Cloud DNS client.
+func New(service *gophercloud.ServiceClient) *Provider {
+	return &Provider{service: service}
+}
+
+// FindZone pages through the domains matching the name, Rackspace also
+// returns subdomains, until it finds the domain itself.
+func (p *Provider) FindZone(ctx context.Context, domainName string) (string, error) {
+	var domId string
+
+	opts := domains.ListOpts{
+		Name: domainName,
+	}
+
+	pager := domains.List(ctx, p.service, opts)
+
+	listErr := pager.EachPage(ctx, func(ctx context.Context, page pagination.Page) (bool, error) {
+		domainList, err := domains.ExtractDomains(page)
+
+		if err != nil {
+			return false, err
+		}
+
+		if len(domainList) == 0 {
+			return false, fmt.Errorf("failed to find domain for `%s`", domainName)
+		}
+
+		for _, domain := range domainList {
+			if domain.Name == domainName {
+				domId = domain.ID
+				return true, err
+			}
+		}
+
+		// go to the next page
+		return true, err
+	})
+
+	if listErr != nil {
+		return domId, fmt.Errorf("unable to fetch domains in rackspace account: %w", listErr)
+	}
+
+	if domId == "" {
+		return domId, fmt.Errorf("failed to find domain `%s`", domainName)
+	}
+
+	return domId, nil
+}
+
+// CreateTXTRecord creates the record and waits for the job to complete.
+func (p *Provider) CreateTXTRecord(ctx context.Context, domId string, record dnsprovider.Record) (dnsprovider.Record, error) {
+	opts := records.CreateOpts{
+		Name:    record.Name,
+		Type:    "TXT",
+		Data:    record.Data,
+		TTL:     uint(record.TTL),
+		Comment: record.Comment,
+	}
+
+	created, err := records.Create(ctx, p.service, domId, opts).Extract()
+	if err != nil {
+		return dnsprovider.Record{}, err
+	}
+
+	return dnsprovider.Record{
+		ID:      created.ID,
+		Name:    created.Name,
+		Type:    created.Type,
+		Data:    created.Data,
+		TTL:     int(created.TTL),
+		Comment: created.Comment,
+	}, nil
+}
+
+// FindTXTRecords lists the matching records across every page.
+func (p *Provider) FindTXTRecords(ctx context.Context, domId string, name string, data string) ([]dnsprovider.Record, error) {
+	return p.listRecords(ctx, domId, records.ListOpts{
+		Name: name,
+		Type: "TXT",
+		Data: data,
+	})
+}
+
+// ListTXTRecords lists every TXT record of the domain across every page.
+func (p *Provider) ListTXTRecords(ctx context.Context, domId string) ([]dnsprovider.Record, error) {
+	return p.listRecords(ctx, domId, records.ListOpts{
+		Type: "TXT",
+	})
+}
+
+// createdLayout is how Cloud DNS formats the time a record was created.
+const createdLayout = "2006-01-02T15:04:05.000-0700"
+
+func (p *Provider) listRecords(ctx context.Context, domId string, opts records.ListOpts) ([]dnsprovider.Record, error) {
+	var found []dnsprovider.Record
+
+	pager := records.List(ctx, p.service, domId, opts)
+
+	listErr := pager.EachPage(ctx, func(ctx context.Context, page pagination.Page) (bool, error) {
+		// records.RecordList leaves out when the record was created
+		var recordList struct {
+			Records []struct {
+				records.RecordList
+				Created string `json:"created"`
+			} `json:"records"`
+		}
+		if err := page.(records.RecordPage).ExtractInto(&recordList); err != nil {
+			return false, err
+		}
+
+		for _, r := range recordList.Records {
+			// an unknown time is left zero, which never makes a record stale
+			created, err := time.Parse(createdLayout, r.Created)
+			if err != nil {
+				klog.Warningf("Unable to parse when record %s of domain %s was created: %v", r.ID, domId, err)
+				createdParseErrors.Inc()
+			}
+			found = append(found, dnsprovider.Record{
+				ID:      r.ID,
+				Name:    r.Name,
+				Type:    r.Type,
+				Data:    r.Data,
+				TTL:     int(r.TTL),
+				Comment: r.Comment,
+				Created: created,
+			})
+		}
+		return true, nil
+	})
+
+	if listErr != nil {
+		return nil, fmt.Errorf("unable to fetch DNS records in rackspace account: %w", listErr)
+	}
+
+	return found, nil
+}
+
+// DeleteRecord deletes the record and waits for the job to complete.
+func (p *Provider) DeleteRecord(ctx context.Context, domId string, recordId string) error {
+	return records.Delete(ctx, p.service, domId, recordId).ExtractErr()
+}
@@ -0,0 +1,227 @@
+package rackspace
+
+import (
+	"context"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	tokens2 "github.com/gophercloud/gophercloud/v2/openstack/identity/v2/tokens"
+	"github.com/rackerlabs/goraxauth"
+	"k8s.io/component-base/metrics/testutil"
+
+	"github.com/rackerlabs/cert-manager-webhook-rackspace/pkg/dnsprovider"
+	"github.com/rackerlabs/cert-manager-webhook-rackspace/pkg/rackspacetest"
+)
+
+func connect(t *testing.T, srv *rackspacetest.Server) *Provider {
+	t.Helper()
+
+	provider, err := Connect(context.Background(), Config{
+		AuthOptions: goraxauth.AuthOptions{
+			AuthOptions: tokens2.AuthOptions{
+				IdentityEndpoint: srv.IdentityEndpoint(),
+				Username:         "user",
+			},
+			ApiKey: "api-key",
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return provider
+}
+
+func TestFindZone(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		domains  []string
+		pageSize int
+		lookup   string
+		wantErr  string
+	}{
+		{
+			name:   "found",
+			lookup: "example.com",
+		},
+		{
+			name:    "unknown domain",
+			lookup:  "example.org",
+			wantErr: "failed to find domain `example.org`",
+		},
+		{
+			name:     "found on a later page",
+			domains:  []string{"a.sub.example.com", "b.sub.example.com", "c.sub.example.com", "sub.example.com"},
+			pageSize: 1,
+			lookup:   "sub.example.com",
+		},
+		{
+			name:     "only subdomains exist",
+			domains:  []string{"a.sub.example.com", "b.sub.example.com"},
+			pageSize: 1,
+			lookup:   "sub.example.com",
+			wantErr:  "failed to find domain `sub.example.com`",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			srv := rackspacetest.NewServer()
+			defer srv.Close()
+
+			tenant := srv.AddAccount("user", "api-key")
+			domID := srv.AddDomain(tenant, "example.com")
+
+			ids := map[string]string{"example.com": domID}
+			for _, name := range tc.domains {
+				ids[name] = srv.AddDomain(tenant, name)
+			}
+			if tc.pageSize > 0 {
+				srv.PageSize = tc.pageSize
+			}
+
+			domId, err := connect(t, srv).FindZone(context.Background(), tc.lookup)
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Errorf("expected error %q, got %v", tc.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if domId != ids[tc.lookup] {
+				t.Errorf("domId = %v, want %v", domId, ids[tc.lookup])
+			}
+		})
+	}
+}
+
+func TestRecords(t *testing.T) {
+	srv := rackspacetest.NewServer()
+	defer srv.Close()
+
+	tenant := srv.AddAccount("user", "api-key")
+	domID := srv.AddDomain(tenant, "example.com")
+	srv.AddRecord(rackspacetest.Record{DomainID: domID, Name: "_acme-challenge.example.com", Type: "TXT", Data: "other"})
+
+	ctx := context.Background()
+	provider := connect(t, srv)
+
+	created, err := provider.CreateTXTRecord(ctx, domID, dnsprovider.Record{
+		Name:    "_acme-challenge.example.com",
+		Data:    "key",
+		TTL:     300,
+		Comment: "test",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created.Type != "TXT" {
+		t.Errorf("created.Type = %v, want %v", created.Type, "TXT")
+	}
+	if created.TTL != 300 {
+		t.Errorf("created.TTL = %v, want %v", created.TTL, 300)
+	}
+
+	found, err := provider.FindTXTRecords(ctx, domID, "_acme-challenge.example.com", "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(found) != 1 {
+		t.Fatalf("expected 1, got %v", found)
+	}
+	if got := found[0].ID; got != created.ID {
+		t.Errorf("found[0].ID = %v, want %v", got, created.ID)
+	}
+
+	if err := provider.DeleteRecord(ctx, domID, created.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	found, err = provider.FindTXTRecords(ctx, domID, "_acme-challenge.example.com", "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(found) != 0 {
+		t.Errorf("expected none, got %v", found)
+	}
+	if got, want := srv.TXTRecords("_acme-challenge.example.com"), []string{"other"}; !slices.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestListTXTRecords(t *testing.T) {
+	srv := rackspacetest.NewServer()
+	defer srv.Close()
+
+	tenant := srv.AddAccount("user", "api-key")
+	domID := srv.AddDomain(tenant, "example.com")
+
+	created := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	srv.AddRecord(rackspacetest.Record{DomainID: domID, Name: "_acme-challenge.example.com", Type: "TXT", Data: "old", Comment: "created by test", Created: created})
+	srv.AddRecord(rackspacetest.Record{DomainID: domID, Name: "_acme-challenge.www.example.com", Type: "TXT", Data: "new"})
+	srv.AddRecord(rackspacetest.Record{DomainID: domID, Name: "www.example.com", Type: "A", Data: "192.0.2.1"})
+	srv.PageSize = 1
+
+	listed, err := connect(t, srv).ListTXTRecords(context.Background(), domID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(listed) != 2 {
+		t.Fatalf("expected 2, got %v", listed)
+	}
+
+	byData := map[string]dnsprovider.Record{}
+	for _, r := range listed {
+		byData[r.Data] = r
+	}
+	if got := byData["old"].Comment; got != "created by test" {
+		t.Errorf("got %v, want %v", got, "created by test")
+	}
+	if !created.Equal(byData["old"].Created) {
+		t.Errorf("created at %s", byData["old"].Created)
+	}
+	if d := time.Since(byData["new"].Created); d < 0 || d > time.Minute {
+		t.Errorf("created at %s", byData["new"].Created)
+	}
+}
+
+func TestListTXTRecordsUnparsedCreated(t *testing.T) {
+	srv := rackspacetest.NewServer()
+	defer srv.Close()
+
+	tenant := srv.AddAccount("user", "api-key")
+	domID := srv.AddDomain(tenant, "example.com")
+	srv.AddFault(rackspacetest.Fault{
+		Method: http.MethodGet,
+		Path:   "/records",
+		Status: http.StatusOK,
+		Body:   `{"records":[{"id":"TXT-1","name":"_acme-challenge.example.com","type":"TXT","data":"key","created":"yesterday"}],"totalEntries":1}`,
+	})
+
+	before, err := testutil.GetCounterMetricValue(createdParseErrors)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	listed, err := connect(t, srv).ListTXTRecords(context.Background(), domID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(listed) != 1 {
+		t.Fatalf("expected 1, got %v", listed)
+	}
+	if !listed[0].Created.IsZero() {
+		t.Errorf("created at %s, want the zero time", listed[0].Created)
+	}
+
+	after, err := testutil.GetCounterMetricValue(createdParseErrors)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := after - before; got != 1 {
+		t.Errorf("counted %v parse errors, want 1", got)
+	}
+}
@@ -0,0 +1,139 @@
+// Package rackspace implements dnsprovider.Provider on top of Rackspace
+// Cloud DNS, logging in through the Rackspace identity service with an API
+// key.
+package rackspace
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"k8s.io/klog/v2"
+
+	"github.com/gophercloud/gophercloud/v2"
+	"github.com/gophercloud/gophercloud/v2/openstack"
+	tokens2 "github.com/gophercloud/gophercloud/v2/openstack/identity/v2/tokens"
+
+	"github.com/rackerlabs/cert-manager-webhook-rackspace/internal/redact"
+	"github.com/rackerlabs/goclouddns"
+	"github.com/rackerlabs/goraxauth"
+)
+
+// DefaultIdentityEndpoint is the Rackspace identity service used to log in.
+const DefaultIdentityEndpoint = "https://identity.api.rackspacecloud.com/v2.0/"
+
+// Config holds the credentials of a single Rackspace account.
+type Config struct {
+	DomainName  string
+	AuthOptions goraxauth.AuthOptions
+	// SecondaryApiKey is tried when AuthOptions is rejected, allowing the
+	// API key to be rotated without failing challenges.
+	SecondaryApiKey string
+	// SecretRef is the `namespace/name` of the Secret the credentials came from.
+	SecretRef string
+
+	// Transport carries every request to Rackspace, nil meaning the default
+	// transport.
+	Transport http.RoundTripper
+	// UserAgent is prepended to the User-Agent of every request.
+	UserAgent string
+}
+
+// Connect logs in to the account and returns a Provider for its Cloud DNS
+// service.
+func Connect(ctx context.Context, c Config) (*Provider, error) {
+	service, err := AuthenticateClient(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+	return New(service), nil
+}
+
+// AuthenticateClient logs in to the account and returns a client for its
+// Cloud DNS service.
+func AuthenticateClient(ctx context.Context, c Config) (*gophercloud.ServiceClient, error) {
+	provider, err := authenticateProvider(ctx, c)
+	if err != nil {
+		return nil, fmt.Errorf("unable to authenticate to rackspace as `%s`: %w", c.AuthOptions.Username, err)
+	}
+
+	if c.UserAgent != "" {
+		provider.UserAgent.Prepend(c.UserAgent)
+	}
+
+	service, err := goclouddns.NewCloudDNS(provider, gophercloud.EndpointOpts{})
+	if err != nil {
+		return nil, fmt.Errorf("unable to find cloud dns endpoint for rackspace as `%s`: %w", c.AuthOptions.Username, err)
+	}
+
+	return service, nil
+}
+
+// authenticateProvider logs in with the primary API key and, when that key is
+// rejected and a secondary key is available, retries with the secondary key.
+func authenticateProvider(ctx context.Context, c Config) (*gophercloud.ProviderClient, error) {
+	provider, err := newProvider(ctx, c.AuthOptions, c.Transport)
+	if err == nil {
+		registerAuthResult(provider)
+		recordApiKeyInUse(c.SecretRef, apiKeyPrimary)
+		return provider, nil
+	}
+
+	if c.SecondaryApiKey == "" || !isAuthFailure(err) {
+		return nil, err
+	}
+
+	klog.Warningf("Primary api-key from secret `%s` was rejected, falling back to api-key-next", c.SecretRef)
+
+	ao := c.AuthOptions
+	ao.ApiKey = c.SecondaryApiKey
+
+	provider, nextErr := newProvider(ctx, ao, c.Transport)
+	if nextErr != nil {
+		return nil, fmt.Errorf("api-key-next also failed (%v) after api-key failed: %w", nextErr, err)
+	}
+
+	registerAuthResult(provider)
+	recordApiKeyInUse(c.SecretRef, apiKeySecondary)
+
+	return provider, nil
+}
+
+// newProvider logs in through transport. Tokens that expire part way through
+// an operation are renewed once by gophercloud and the request retried.
+func newProvider(ctx context.Context, ao goraxauth.AuthOptions, transport http.RoundTripper) (*gophercloud.ProviderClient, error) {
+	provider, err := openstack.NewClient(ao.IdentityEndpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	if transport != nil {
+		provider.HTTPClient.Transport = transport
+	}
+
+	ao.AllowReauth = true
+	if err := openstack.AuthenticateV2(ctx, provider, ao, gophercloud.EndpointOpts{}); err != nil {
+		return nil, err
+	}
+
+	return provider, nil
+}
+
+// registerAuthResult marks the token and tenant ID of a login as sensitive,
+// the tenant ID is part of every Cloud DNS URL.
+func registerAuthResult(provider *gophercloud.ProviderClient) {
+	redact.Register(provider.Token())
+
+	if result, ok := provider.GetAuthResult().(tokens2.CreateResult); ok {
+		if token, err := result.ExtractToken(); err == nil {
+			redact.Register(token.Tenant.ID)
+		}
+	}
+}
+
+// isAuthFailure reports whether the identity service rejected the credentials
+// as opposed to being unreachable or otherwise failing.
+func isAuthFailure(err error) bool {
+	return gophercloud.ResponseCodeIs(err, http.StatusUnauthorized) ||
+		gophercloud.ResponseCodeIs(err, http.StatusForbidden)
+}
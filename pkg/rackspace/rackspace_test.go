@@ -0,0 +1,71 @@
+package rackspace
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	tokens2 "github.com/gophercloud/gophercloud/v2/openstack/identity/v2/tokens"
+	"github.com/rackerlabs/goraxauth"
+	"k8s.io/component-base/metrics/testutil"
+
+	"github.com/rackerlabs/cert-manager-webhook-rackspace/pkg/rackspacetest"
+)
+
+func keyConfig(srv *rackspacetest.Server, secretRef string, apiKey string, next string) Config {
+	return Config{
+		SecretRef: secretRef,
+		AuthOptions: goraxauth.AuthOptions{
+			AuthOptions: tokens2.AuthOptions{
+				IdentityEndpoint: srv.IdentityEndpoint(),
+				Username:         "user",
+			},
+			ApiKey: apiKey,
+		},
+		SecondaryApiKey: next,
+	}
+}
+
+func keyInUse(t *testing.T, secretRef string, key string) float64 {
+	t.Helper()
+
+	v, err := testutil.GetGaugeMetricValue(apiKeyInUse.WithLabelValues(secretRef, key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+func TestSecondaryApiKey(t *testing.T) {
+	srv := rackspacetest.NewServer()
+	defer srv.Close()
+	srv.AddAccount("user", "new")
+
+	ctx := context.Background()
+
+	// mid-rotation, the Secret still holds the old key as primary
+	if _, err := Connect(ctx, keyConfig(srv, "default/rotating", "old", "new")); err != nil {
+		t.Fatalf("falling back to api-key-next: %v", err)
+	}
+	if keyInUse(t, "default/rotating", apiKeySecondary) != 1 || keyInUse(t, "default/rotating", apiKeyPrimary) != 0 {
+		t.Error("the secondary key is not reported in use")
+	}
+
+	// rotation completed
+	if _, err := Connect(ctx, keyConfig(srv, "default/rotating", "new", "old")); err != nil {
+		t.Fatal(err)
+	}
+	if keyInUse(t, "default/rotating", apiKeyPrimary) != 1 || keyInUse(t, "default/rotating", apiKeySecondary) != 0 {
+		t.Error("the primary key is not reported in use")
+	}
+
+	_, err := Connect(ctx, keyConfig(srv, "default/stale", "old", "older"))
+	if err == nil || !strings.Contains(err.Error(), "api-key-next also failed") {
+		t.Errorf("expected both keys to fail, got %v", err)
+	}
+
+	_, err = Connect(ctx, keyConfig(srv, "default/single", "old", ""))
+	if err == nil || strings.Contains(err.Error(), "api-key-next") {
+		t.Errorf("expected the primary key to fail alone, got %v", err)
+	}
+}
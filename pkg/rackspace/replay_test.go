@@ -0,0 +1,122 @@
+package rackspace
+
+import (
+	"context"
+	"flag"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+
+	tokens2 "github.com/gophercloud/gophercloud/v2/openstack/identity/v2/tokens"
+	"github.com/rackerlabs/goraxauth"
+
+	"github.com/rackerlabs/cert-manager-webhook-rackspace/internal/cassette"
+	"github.com/rackerlabs/cert-manager-webhook-rackspace/pkg/dnsprovider"
+)
+
+const rackspaceCassette = "../../testdata/cassettes/rackspace.json"
+
+var recordCassettes = flag.Bool("record-cassettes", false,
+	"record the cassettes from the live API using OS_USERNAME, RAX_API_KEY and TEST_ZONE_NAME")
+
+// cassetteFlow exercises every Rackspace call the solver relies on: logging
+// in, finding the zone, creating a TXT record, finding it and deleting it.
+func cassetteFlow(t *testing.T, endpoint string, transport http.RoundTripper, zone string, username string, apiKey string) {
+	t.Helper()
+
+	ctx := context.Background()
+	zone = strings.ToLower(strings.TrimRight(zone, "."))
+
+	provider, err := Connect(ctx, Config{
+		AuthOptions: goraxauth.AuthOptions{
+			AuthOptions: tokens2.AuthOptions{
+				IdentityEndpoint: endpoint,
+				Username:         username,
+			},
+			ApiKey: apiKey,
+		},
+		SecretRef: "replay/creds",
+		Transport: transport,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	domId, err := provider.FindZone(ctx, zone)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	name := "_acme-challenge.replay." + zone
+	created, err := provider.CreateTXTRecord(ctx, domId, dnsprovider.Record{
+		Name:    name,
+		Type:    "TXT",
+		Data:    "replay-test-key",
+		TTL:     300,
+		Comment: "created by cert-manager-webhook-rackspace replay tests",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	found, err := provider.FindTXTRecords(ctx, domId, name, "replay-test-key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(found) != 1 {
+		t.Fatalf("expected 1 record, got %v", found)
+	}
+	if got := found[0].ID; got != created.ID {
+		t.Errorf("found[0].ID = %v, want %v", got, created.ID)
+	}
+
+	if err := provider.DeleteRecord(ctx, domId, created.ID); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// recordCassette runs the cassette flow against endpoint and saves what was
+// exchanged, scrubbed, to path.
+func recordCassette(t *testing.T, path string, endpoint string, zone string, username string, apiKey string) {
+	t.Helper()
+
+	recorder := cassette.NewRecorder(nil, username, apiKey)
+
+	cassetteFlow(t, endpoint, recorder, zone, username, apiKey)
+
+	recorder.Cassette.Vars["zone"] = strings.ToLower(strings.TrimRight(zone, "."))
+	recorder.Cassette.Vars["identityEndpoint"] = endpoint
+	if err := recorder.Cassette.Save(path); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestReplayCassette replays recorded Rackspace interactions through the
+// provider so that changes in the requests goraxauth or goclouddns make, or in
+// the responses they expect, are caught. The cassette only holds what the
+// API answered when it was recorded, re-record with `make record-cassettes`.
+// The committed cassette still comes from pkg/rackspacetest, not the live API.
+func TestReplayCassette(t *testing.T) {
+	if *recordCassettes {
+		username, apiKey, zone := os.Getenv("OS_USERNAME"), os.Getenv("RAX_API_KEY"), os.Getenv("TEST_ZONE_NAME")
+		if username == "" || apiKey == "" || zone == "" {
+			t.Fatal("OS_USERNAME, RAX_API_KEY and TEST_ZONE_NAME are required to record cassettes")
+		}
+		recordCassette(t, rackspaceCassette, DefaultIdentityEndpoint, zone, username, apiKey)
+		return
+	}
+
+	recorded, err := cassette.Load(rackspaceCassette)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	replayer := cassette.NewReplayer(recorded)
+
+	cassetteFlow(t, DefaultIdentityEndpoint, replayer, recorded.Vars["zone"], cassette.Username, cassette.ApiKey)
+
+	if got := replayer.Unused(); len(got) != 0 {
+		t.Errorf("recorded interactions were not replayed: expected none, got %v", got)
+	}
+}
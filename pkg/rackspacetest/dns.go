@@ -0,0 +1,298 @@
+package rackspacetest
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type recordJSON struct {
+	ID      string `json:"id"`
+	Name    string `json:"name"`
+	Type    string `json:"type"`
+	Data    string `json:"data"`
+	TTL     uint   `json:"ttl"`
+	Comment string `json:"comment,omitempty"`
+	Created string `json:"created"`
+	Updated string `json:"updated"`
+}
+
+func (r *Record) toJSON() recordJSON {
+	ts := r.Created.UTC().Format("2006-01-02T15:04:05.000+0000")
+	return recordJSON{
+		ID:      r.ID,
+		Name:    r.Name,
+		Type:    r.Type,
+		Data:    r.Data,
+		TTL:     r.TTL,
+		Comment: r.Comment,
+		Created: ts,
+		Updated: ts,
+	}
+}
+
+// addRecord stores a record, must hold s.mu.
+func (s *Server) addRecord(r Record) *Record {
+	r.ID = r.Type + "-" + s.newID()
+	r.Name = strings.ToLower(strings.TrimSuffix(r.Name, "."))
+	if r.Created.IsZero() {
+		r.Created = time.Now()
+	}
+
+	s.records[r.ID] = &r
+	return &r
+}
+
+// domainRecords lists a domain's records ordered by ID, must hold s.mu.
+func (s *Server) domainRecords(domainID string) []Record {
+	var out []Record
+	for _, r := range s.records {
+		if r.DomainID == domainID {
+			out = append(out, *r)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].ID < out[j].ID })
+	return out
+}
+
+// tenantDomain looks up a domain owned by the request's tenant, must hold s.mu.
+func (s *Server) tenantDomain(r *http.Request) (*Domain, bool) {
+	d, ok := s.domains[r.PathValue("domain")]
+	if !ok || d.TenantID != r.PathValue("tenant") {
+		return nil, false
+	}
+	return d, true
+}
+
+// page slices out the entries selected by the limit and offset parameters
+// and builds the links pointing to the next page.
+func (s *Server) page(r *http.Request, total int) (int, int, []map[string]string) {
+	limit := s.PageSize
+	if v, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && v > 0 && v < limit {
+		limit = v
+	}
+	offset, _ := strconv.Atoi(r.URL.Query().Get("offset"))
+	if offset < 0 || offset > total {
+		offset = total
+	}
+
+	end := offset + limit
+	if end > total {
+		end = total
+	}
+
+	var links []map[string]string
+	if end < total {
+		q := r.URL.Query()
+		q.Set("limit", strconv.Itoa(limit))
+		q.Set("offset", strconv.Itoa(end))
+		next := url.URL{Scheme: "http", Host: r.Host, Path: r.URL.Path, RawQuery: q.Encode()}
+		links = append(links, map[string]string{"href": next.String(), "rel": "next"})
+	}
+
+	return offset, end, links
+}
+
+func (s *Server) handleListDomains(w http.ResponseWriter, r *http.Request) {
+	tenantID := r.PathValue("tenant")
+	name := strings.ToLower(r.URL.Query().Get("name"))
+
+	s.mu.Lock()
+	var matched []*Domain
+	for _, d := range s.domains {
+		if d.TenantID != tenantID {
+			continue
+		}
+		// like Rackspace, filtering by name also returns subdomains
+		if name != "" && d.Name != name && !strings.HasSuffix(d.Name, "."+name) {
+			continue
+		}
+		matched = append(matched, d)
+	}
+	s.mu.Unlock()
+
+	sort.Slice(matched, func(i, j int) bool { return matched[i].Name < matched[j].Name })
+
+	start, end, links := s.page(r, len(matched))
+
+	domains := make([]map[string]any, 0, end-start)
+	for _, d := range matched[start:end] {
+		domains = append(domains, map[string]any{
+			"id":           d.ID,
+			"name":         d.Name,
+			"accountId":    d.TenantID,
+			"emailAddress": "hostmaster@" + d.Name,
+		})
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"domains":      domains,
+		"totalEntries": len(matched),
+		"links":        links,
+	})
+}
+
+func (s *Server) handleListRecords(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	s.mu.Lock()
+	d, ok := s.tenantDomain(r)
+	if !ok {
+		s.mu.Unlock()
+		writeError(w, http.StatusNotFound, "Object not Found.")
+		return
+	}
+
+	var matched []Record
+	for _, rec := range s.domainRecords(d.ID) {
+		if v := q.Get("name"); v != "" && rec.Name != strings.ToLower(v) {
+			continue
+		}
+		if v := q.Get("type"); v != "" && rec.Type != v {
+			continue
+		}
+		if v := q.Get("data"); v != "" && rec.Data != v {
+			continue
+		}
+		matched = append(matched, rec)
+	}
+	s.mu.Unlock()
+
+	start, end, links := s.page(r, len(matched))
+
+	records := make([]recordJSON, 0, end-start)
+	for _, rec := range matched[start:end] {
+		records = append(records, rec.toJSON())
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"records":      records,
+		"totalEntries": len(matched),
+		"links":        links,
+	})
+}
+
+func (s *Server) handleCreateRecords(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Records []recordJSON `json:"records"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || len(body.Records) == 0 {
+		writeError(w, http.StatusBadRequest, "Invalid records in request body.")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	d, ok := s.tenantDomain(r)
+	if !ok {
+		writeError(w, http.StatusNotFound, "Object not Found.")
+		return
+	}
+
+	for _, rec := range body.Records {
+		name := strings.ToLower(rec.Name)
+		if name != d.Name && !strings.HasSuffix(name, "."+d.Name) {
+			writeError(w, http.StatusBadRequest, "Record name "+rec.Name+" is not in domain "+d.Name)
+			return
+		}
+	}
+
+	if s.jobError != "" {
+		s.writeJob(w, r, job{Verb: r.Method, Error: s.jobError})
+		return
+	}
+
+	created := make([]recordJSON, 0, len(body.Records))
+	for _, rec := range body.Records {
+		stored := s.addRecord(Record{
+			DomainID: d.ID,
+			Name:     rec.Name,
+			Type:     rec.Type,
+			Data:     rec.Data,
+			TTL:      rec.TTL,
+			Comment:  rec.Comment,
+		})
+		created = append(created, stored.toJSON())
+	}
+
+	s.writeJob(w, r, job{Verb: r.Method, Response: map[string]any{"records": created}})
+}
+
+func (s *Server) handleDeleteRecord(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	d, ok := s.tenantDomain(r)
+	if !ok {
+		writeError(w, http.StatusNotFound, "Object not Found.")
+		return
+	}
+
+	rec, ok := s.records[r.PathValue("record")]
+	if !ok || rec.DomainID != d.ID {
+		writeError(w, http.StatusNotFound, "Object not Found.")
+		return
+	}
+
+	if s.jobError != "" {
+		s.writeJob(w, r, job{Verb: r.Method, Error: s.jobError})
+		return
+	}
+
+	delete(s.records, rec.ID)
+
+	s.writeJob(w, r, job{Verb: r.Method})
+}
+
+// writeJob replies with an asynchronous job that is already finished, the
+// client picks up the outcome from the status endpoint. Must hold s.mu.
+func (s *Server) writeJob(w http.ResponseWriter, r *http.Request, j job) {
+	id := "job-" + s.newID()
+	j.Request = r.URL.String()
+	s.jobs[id] = j
+
+	writeJSON(w, http.StatusAccepted, map[string]any{
+		"status":      "RUNNING",
+		"verb":        j.Verb,
+		"jobId":       id,
+		"callbackUrl": s.URL + "/v1.0/" + r.PathValue("tenant") + "/status/" + id,
+		"requestUrl":  s.URL + r.URL.Path,
+	})
+}
+
+func (s *Server) handleStatus(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	j, ok := s.jobs[r.PathValue("job")]
+	s.mu.Unlock()
+
+	if !ok {
+		writeError(w, http.StatusNotFound, "Object not Found.")
+		return
+	}
+
+	status := map[string]any{
+		"status":      "COMPLETED",
+		"verb":        j.Verb,
+		"jobId":       r.PathValue("job"),
+		"callbackUrl": s.URL + r.URL.Path,
+		"requestUrl":  j.Request,
+	}
+
+	if j.Error != "" {
+		status["status"] = "ERROR"
+		status["error"] = map[string]any{
+			"code":    http.StatusBadRequest,
+			"message": j.Error,
+			"details": j.Error,
+		}
+	} else if j.Response != nil {
+		status["response"] = j.Response
+	}
+
+	writeJSON(w, http.StatusOK, status)
+}
@@ -0,0 +1,137 @@
+package rackspacetest
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// StartDNS serves the fake's records over DNS on a UDP address such as
+// `127.0.0.1:0`, acting as the authoritative server for every domain. It
+// returns the address it is listening on and stops when the Server is closed.
+func (s *Server) StartDNS(addr string) (string, error) {
+	conn, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return "", fmt.Errorf("unable to listen for DNS on %s: %w", addr, err)
+	}
+
+	started := make(chan struct{})
+	server := &dns.Server{
+		PacketConn:        conn,
+		Handler:           dns.HandlerFunc(s.handleDNS),
+		NotifyStartedFunc: func() { close(started) },
+	}
+
+	go func() {
+		_ = server.ActivateAndServe()
+	}()
+	<-started
+
+	s.mu.Lock()
+	s.dnsServers = append(s.dnsServers, server)
+	s.mu.Unlock()
+
+	return conn.LocalAddr().String(), nil
+}
+
+// Close stops the DNS servers and the HTTP server.
+func (s *Server) Close() {
+	s.mu.Lock()
+	servers := s.dnsServers
+	s.dnsServers = nil
+	s.mu.Unlock()
+
+	for _, server := range servers {
+		_ = server.Shutdown()
+	}
+
+	s.Server.Close()
+}
+
+func (s *Server) handleDNS(w dns.ResponseWriter, req *dns.Msg) {
+	msg := new(dns.Msg)
+	msg.SetReply(req)
+	msg.Authoritative = true
+
+	for _, q := range req.Question {
+		s.answer(msg, q)
+	}
+
+	_ = w.WriteMsg(msg)
+}
+
+// answer adds the records for a question, names outside every domain are
+// refused and unknown names within a domain do not exist.
+func (s *Server) answer(msg *dns.Msg, q dns.Question) {
+	name := strings.ToLower(strings.TrimSuffix(q.Name, "."))
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	zone := s.zoneFor(name)
+	if zone == nil {
+		msg.Rcode = dns.RcodeRefused
+		return
+	}
+
+	soa := &dns.SOA{
+		Hdr:     dns.RR_Header{Name: dns.Fqdn(zone.Name), Rrtype: dns.TypeSOA, Class: dns.ClassINET, Ttl: 5},
+		Ns:      "ns.rackspacetest.invalid.",
+		Mbox:    "hostmaster.rackspacetest.invalid.",
+		Serial:  1,
+		Refresh: 5,
+		Retry:   5,
+		Expire:  5,
+		Minttl:  5,
+	}
+
+	switch {
+	case q.Qtype == dns.TypeSOA && name == zone.Name:
+		msg.Answer = append(msg.Answer, soa)
+		return
+	case q.Qtype == dns.TypeNS && name == zone.Name:
+		msg.Answer = append(msg.Answer, &dns.NS{
+			Hdr: dns.RR_Header{Name: q.Name, Rrtype: dns.TypeNS, Class: dns.ClassINET, Ttl: 5},
+			Ns:  soa.Ns,
+		})
+		return
+	}
+
+	exists := name == zone.Name
+	for _, r := range s.records {
+		if r.DomainID != zone.ID || r.Name != name {
+			continue
+		}
+		exists = true
+
+		if r.Type == "TXT" && (q.Qtype == dns.TypeTXT || q.Qtype == dns.TypeANY) {
+			msg.Answer = append(msg.Answer, &dns.TXT{
+				Hdr: dns.RR_Header{Name: q.Name, Rrtype: dns.TypeTXT, Class: dns.ClassINET, Ttl: uint32(r.TTL)},
+				Txt: []string{r.Data},
+			})
+		}
+	}
+
+	if len(msg.Answer) == 0 {
+		msg.Ns = append(msg.Ns, soa)
+		if !exists {
+			msg.Rcode = dns.RcodeNameError
+		}
+	}
+}
+
+// zoneFor finds the most specific domain containing name, must hold s.mu.
+func (s *Server) zoneFor(name string) *Domain {
+	var best *Domain
+	for _, d := range s.domains {
+		if name != d.Name && !strings.HasSuffix(name, "."+d.Name) {
+			continue
+		}
+		if best == nil || len(d.Name) > len(best.Name) {
+			best = d
+		}
+	}
+	return best
+}
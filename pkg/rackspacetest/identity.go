@@ -0,0 +1,114 @@
+package rackspacetest
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// tokenLifetime is how long issued tokens claim to be valid for.
+const tokenLifetime = 24 * time.Hour
+
+type tokenRequest struct {
+	Auth struct {
+		ApiKeyCredentials *struct {
+			Username string `json:"username"`
+			ApiKey   string `json:"apiKey"`
+		} `json:"RAX-KSKEY:apiKeyCredentials"`
+		PasswordCredentials *struct {
+			Username string `json:"username"`
+			Password string `json:"password"`
+		} `json:"passwordCredentials"`
+	} `json:"auth"`
+}
+
+// ExpireTokens invalidates every issued token, the next request made with
+// one of them fails with 401 as it would once a token expires.
+func (s *Server) ExpireTokens() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.tokens = make(map[string]string)
+}
+
+func (s *Server) handleTokens(w http.ResponseWriter, r *http.Request) {
+	var req tokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid json request body")
+		return
+	}
+
+	var username, secret string
+	switch {
+	case req.Auth.ApiKeyCredentials != nil:
+		username, secret = req.Auth.ApiKeyCredentials.Username, req.Auth.ApiKeyCredentials.ApiKey
+	case req.Auth.PasswordCredentials != nil:
+		username, secret = req.Auth.PasswordCredentials.Username, req.Auth.PasswordCredentials.Password
+	default:
+		writeError(w, http.StatusBadRequest, "no supported credentials in request")
+		return
+	}
+
+	s.mu.Lock()
+	account, ok := s.accounts[username]
+	if !ok || account.ApiKey != secret {
+		s.mu.Unlock()
+		writeJSON(w, http.StatusUnauthorized, map[string]any{
+			"unauthorized": map[string]any{
+				"code":    http.StatusUnauthorized,
+				"message": "Username or api key is invalid.",
+			},
+		})
+		return
+	}
+
+	token := "token-" + s.newID()
+	s.tokens[token] = account.TenantID
+	s.mu.Unlock()
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"access": map[string]any{
+			"token": map[string]any{
+				"id":      token,
+				"expires": time.Now().Add(tokenLifetime).UTC().Format("2006-01-02T15:04:05.000Z"),
+				"tenant": map[string]any{
+					"id":   account.TenantID,
+					"name": account.TenantID,
+				},
+			},
+			"serviceCatalog": []map[string]any{{
+				"name": "cloudDNS",
+				"type": "rax:dns",
+				"endpoints": []map[string]any{{
+					"publicURL": s.URL + "/v1.0/" + account.TenantID,
+					"tenantId":  account.TenantID,
+				}},
+			}},
+			"user": map[string]any{
+				"id":   account.TenantID,
+				"name": account.Username,
+			},
+		},
+	})
+}
+
+// authorized rejects requests without a valid token for the path's tenant.
+func (s *Server) authorized(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		tenantID, ok := s.tokens[r.Header.Get("X-Auth-Token")]
+		s.mu.Unlock()
+
+		if !ok || tenantID != r.PathValue("tenant") {
+			writeJSON(w, http.StatusUnauthorized, map[string]any{
+				"unauthorized": map[string]any{
+					"code":    http.StatusUnauthorized,
+					"message": "No valid token provided. Please use the 'X-Auth-Token' header with a valid token.",
+				},
+			})
+			return
+		}
+
+		next(w, r)
+	}
+}
@@ -0,0 +1,343 @@
+// Package rackspacetest provides an in-process fake of the Rackspace identity
+// v2.0 and Cloud DNS v1.0 APIs for use in tests.
+//
+// The fake implements enough of both APIs for goraxauth and goclouddns:
+// token issuance with a service catalog, domain listing, record listing,
+// creation and deletion, and the asynchronous job status endpoint. State is
+// kept in memory and can be inspected or seeded from tests. StartDNS serves
+// the stored records over DNS so that propagation checks work offline.
+//
+//	srv := rackspacetest.NewServer()
+//	defer srv.Close()
+//	tenant := srv.AddAccount("user", "api-key")
+//	srv.AddDomain(tenant, "example.com")
+//	opts.IdentityEndpoint = srv.IdentityEndpoint()
+package rackspacetest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// DefaultPageSize is the number of entries returned per page, matching the
+// Rackspace default.
+const DefaultPageSize = 100
+
+// Account is a Rackspace account that can authenticate against the fake.
+type Account struct {
+	Username string
+	ApiKey   string
+	TenantID string
+}
+
+// Domain is a Cloud DNS domain held by the fake.
+type Domain struct {
+	ID       string
+	Name     string
+	TenantID string
+}
+
+// Record is a Cloud DNS record held by the fake.
+type Record struct {
+	ID       string
+	DomainID string
+	Name     string
+	Type     string
+	Data     string
+	TTL      uint
+	Comment  string
+	Created  time.Time
+}
+
+// Fault makes matching requests fail with Status and Body. A Fault with
+// Times set stops matching after that many requests.
+type Fault struct {
+	// Method and Path select requests, empty values match everything. Path
+	// matches when it is contained in the request path.
+	Method string
+	Path   string
+
+	Status int
+	Body   string
+	Times  int
+}
+
+// Hook is called before every request is served. Returning true means the
+// hook wrote the response itself.
+type Hook func(w http.ResponseWriter, r *http.Request) bool
+
+// Server is a fake Rackspace API served over HTTP.
+type Server struct {
+	*httptest.Server
+
+	// PageSize limits the entries returned per page of a list call.
+	PageSize int
+
+	mu       sync.Mutex
+	accounts map[string]Account // by username
+	tokens   map[string]string  // token to tenant ID
+	domains  map[string]*Domain
+	records  map[string]*Record
+	jobs     map[string]job
+	faults   []*Fault
+	hooks    []Hook
+	requests map[string]int // by route
+	latency  time.Duration
+	jobError string
+	nextID   int
+
+	dnsServers []*dns.Server
+}
+
+type job struct {
+	Verb     string
+	Request  string
+	Response any
+	Error    string
+}
+
+// NewServer starts a fake Rackspace API. Close it once done.
+func NewServer() *Server {
+	s := &Server{
+		PageSize: DefaultPageSize,
+		accounts: make(map[string]Account),
+		tokens:   make(map[string]string),
+		domains:  make(map[string]*Domain),
+		records:  make(map[string]*Record),
+		jobs:     make(map[string]job),
+		requests: make(map[string]int),
+		nextID:   1000,
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /v2.0/tokens", s.handleTokens)
+	mux.HandleFunc("GET /v1.0/{tenant}/domains", s.authorized(s.handleListDomains))
+	mux.HandleFunc("GET /v1.0/{tenant}/domains/{domain}/records", s.authorized(s.handleListRecords))
+	mux.HandleFunc("POST /v1.0/{tenant}/domains/{domain}/records", s.authorized(s.handleCreateRecords))
+	mux.HandleFunc("DELETE /v1.0/{tenant}/domains/{domain}/records/{record}", s.authorized(s.handleDeleteRecord))
+	mux.HandleFunc("GET /v1.0/{tenant}/status/{job}", s.authorized(s.handleStatus))
+
+	s.Server = httptest.NewServer(s.intercept(mux))
+
+	return s
+}
+
+// IdentityEndpoint is the URL to use as the identity endpoint.
+func (s *Server) IdentityEndpoint() string {
+	return s.URL + "/v2.0/"
+}
+
+// AddAccount registers credentials and returns the tenant ID of the account.
+func (s *Server) AddAccount(username string, apiKey string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tenantID := s.newID()
+	s.accounts[username] = Account{Username: username, ApiKey: apiKey, TenantID: tenantID}
+
+	return tenantID
+}
+
+// AddDomain creates a domain in an account and returns its ID.
+func (s *Server) AddDomain(tenantID string, name string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id := s.newID()
+	s.domains[id] = &Domain{ID: id, Name: strings.ToLower(name), TenantID: tenantID}
+
+	return id
+}
+
+// AddRecord stores a record directly, bypassing the API, and returns its ID.
+func (s *Server) AddRecord(r Record) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.addRecord(r).ID
+}
+
+// Records returns the records held in a domain, ordered by ID.
+func (s *Server) Records(domainID string) []Record {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.domainRecords(domainID)
+}
+
+// TXTRecords returns the data of every TXT record with the given name,
+// across all domains.
+func (s *Server) TXTRecords(name string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+
+	var data []string
+	for _, r := range s.records {
+		if r.Type == "TXT" && r.Name == name {
+			data = append(data, r.Data)
+		}
+	}
+	sort.Strings(data)
+
+	return data
+}
+
+// AddFault injects an error response for matching requests.
+func (s *Server) AddFault(f Fault) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.faults = append(s.faults, &f)
+}
+
+// AddHook installs a hook that sees every request before it is served.
+func (s *Server) AddHook(h Hook) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.hooks = append(s.hooks, h)
+}
+
+// SetLatency delays every response by d.
+func (s *Server) SetLatency(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.latency = d
+}
+
+// SetJobError makes every asynchronous job end in ERROR with the message,
+// an empty message makes them succeed again.
+func (s *Server) SetJobError(message string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.jobError = message
+}
+
+// Requests returns how many requests were received per route since the last
+// ResetRequests, keyed by the route pattern such as
+// `GET /v1.0/{tenant}/domains`.
+func (s *Server) Requests() map[string]int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	requests := make(map[string]int, len(s.requests))
+	for route, n := range s.requests {
+		requests[route] = n
+	}
+	return requests
+}
+
+// ResetRequests zeroes the request counts.
+func (s *Server) ResetRequests() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.requests = make(map[string]int)
+}
+
+// Reset drops all faults, hooks and latency.
+func (s *Server) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.faults = nil
+	s.hooks = nil
+	s.latency = 0
+	s.jobError = ""
+}
+
+// intercept counts requests and applies latency, hooks and faults before
+// serving them.
+func (s *Server) intercept(mux *http.ServeMux) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := "unknown"
+		if _, pattern := mux.Handler(r); pattern != "" {
+			route = pattern
+		}
+
+		s.mu.Lock()
+		s.requests[route]++
+		latency := s.latency
+		hooks := append([]Hook(nil), s.hooks...)
+		fault := s.matchFault(r)
+		s.mu.Unlock()
+
+		if latency > 0 {
+			select {
+			case <-time.After(latency):
+			case <-r.Context().Done():
+				return
+			}
+		}
+
+		for _, h := range hooks {
+			if h(w, r) {
+				return
+			}
+		}
+
+		if fault != nil {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(fault.Status)
+			_, _ = w.Write([]byte(fault.Body))
+			return
+		}
+
+		mux.ServeHTTP(w, r)
+	})
+}
+
+// matchFault finds the first fault for the request, must hold s.mu.
+func (s *Server) matchFault(r *http.Request) *Fault {
+	for i, f := range s.faults {
+		if f.Method != "" && f.Method != r.Method {
+			continue
+		}
+		if f.Path != "" && !strings.Contains(r.URL.Path, f.Path) {
+			continue
+		}
+
+		fault := *f
+		if f.Times > 0 {
+			f.Times--
+			if f.Times == 0 {
+				s.faults = append(s.faults[:i], s.faults[i+1:]...)
+			}
+		}
+		return &fault
+	}
+	return nil
+}
+
+// newID hands out unique numeric IDs, must hold s.mu.
+func (s *Server) newID() string {
+	s.nextID++
+	return strconv.Itoa(s.nextID)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]any{
+		"code":    status,
+		"message": message,
+		"details": fmt.Sprintf("%d %s", status, http.StatusText(status)),
+	})
+}
@@ -0,0 +1,310 @@
+package rackspacetest
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gophercloud/gophercloud/v2"
+	tokens2 "github.com/gophercloud/gophercloud/v2/openstack/identity/v2/tokens"
+	"github.com/gophercloud/gophercloud/v2/pagination"
+	"github.com/miekg/dns"
+	"github.com/rackerlabs/goclouddns"
+	"github.com/rackerlabs/goclouddns/domains"
+	"github.com/rackerlabs/goclouddns/records"
+	"github.com/rackerlabs/goraxauth"
+)
+
+func newClient(t *testing.T, srv *Server, username string, apiKey string) (*gophercloud.ServiceClient, error) {
+	t.Helper()
+
+	provider, err := goraxauth.AuthenticatedClient(context.Background(), goraxauth.AuthOptions{
+		AuthOptions: tokens2.AuthOptions{
+			IdentityEndpoint: srv.IdentityEndpoint(),
+			Username:         username,
+		},
+		ApiKey: apiKey,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return goclouddns.NewCloudDNS(provider, gophercloud.EndpointOpts{})
+}
+
+func TestAuthentication(t *testing.T) {
+	srv := NewServer()
+	defer srv.Close()
+
+	srv.AddAccount("user", "key")
+
+	_, err := newClient(t, srv, "user", "wrong")
+	if !gophercloud.ResponseCodeIs(err, http.StatusUnauthorized) {
+		t.Errorf("expected a %d, got %v", http.StatusUnauthorized, err)
+	}
+
+	_, err = newClient(t, srv, "user", "key")
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRecordLifecycle(t *testing.T) {
+	srv := NewServer()
+	defer srv.Close()
+
+	tenant := srv.AddAccount("user", "key")
+	domID := srv.AddDomain(tenant, "example.com")
+
+	service, err := newClient(t, srv, "user", "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+
+	created, err := records.Create(ctx, service, domID, records.CreateOpts{
+		Name: "_acme-challenge.example.com",
+		Type: "TXT",
+		Data: "token",
+		TTL:  300,
+	}).Extract()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := srv.TXTRecords("_acme-challenge.example.com."), []string{"token"}; !slices.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	var found []records.RecordList
+	err = records.List(ctx, service, domID, records.ListOpts{Name: "_acme-challenge.example.com", Type: "TXT", Data: "token"}).
+		EachPage(ctx, func(_ context.Context, page pagination.Page) (bool, error) {
+			list, err := records.ExtractRecords(page)
+			found = append(found, list...)
+			return true, err
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(found) != 1 {
+		t.Fatalf("expected 1, got %v", found)
+	}
+	if got := found[0].ID; got != created.ID {
+		t.Errorf("found[0].ID = %v, want %v", got, created.ID)
+	}
+
+	if err := records.Delete(ctx, service, domID, created.ID).ExtractErr(); err != nil {
+		t.Fatal(err)
+	}
+	if got := srv.Records(domID); len(got) != 0 {
+		t.Errorf("expected none, got %v", got)
+	}
+
+	if got, want := srv.Requests(), map[string]int{
+		"POST /v2.0/tokens":                                       1,
+		"POST /v1.0/{tenant}/domains/{domain}/records":            1,
+		"GET /v1.0/{tenant}/domains/{domain}/records":             1,
+		"DELETE /v1.0/{tenant}/domains/{domain}/records/{record}": 1,
+		"GET /v1.0/{tenant}/status/{job}":                         2,
+	}; !reflect.DeepEqual(got, want) {
+		t.Errorf("srv.Requests() = %v, want %v", got, want)
+	}
+
+	srv.ResetRequests()
+	if got := srv.Requests(); len(got) != 0 {
+		t.Errorf("expected none, got %v", got)
+	}
+}
+
+func TestDomainPaging(t *testing.T) {
+	srv := NewServer()
+	defer srv.Close()
+
+	srv.PageSize = 2
+	tenant := srv.AddAccount("user", "key")
+	for _, name := range []string{"a.example.com", "b.example.com", "c.example.com", "example.com", "other.org"} {
+		srv.AddDomain(tenant, name)
+	}
+
+	service, err := newClient(t, srv, "user", "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+
+	var names []string
+	pages := 0
+	err = domains.List(ctx, service, domains.ListOpts{Name: "example.com"}).
+		EachPage(ctx, func(_ context.Context, page pagination.Page) (bool, error) {
+			pages++
+			list, err := domains.ExtractDomains(page)
+			for _, d := range list {
+				names = append(names, d.Name)
+			}
+			return true, err
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pages != 2 {
+		t.Errorf("pages = %v, want %v", pages, 2)
+	}
+	if !slices.Equal(names, []string{"a.example.com", "b.example.com", "c.example.com", "example.com"}) {
+		t.Errorf("names = %v, want %v", names, []string{"a.example.com", "b.example.com", "c.example.com", "example.com"})
+	}
+}
+
+func TestTenantIsolation(t *testing.T) {
+	srv := NewServer()
+	defer srv.Close()
+
+	srv.AddAccount("user", "key")
+	other := srv.AddAccount("other", "key")
+	domID := srv.AddDomain(other, "example.com")
+
+	service, err := newClient(t, srv, "user", "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = records.Create(context.Background(), service, domID, records.CreateOpts{
+		Name: "_acme-challenge.example.com", Type: "TXT", Data: "token",
+	}).Extract()
+	if !gophercloud.ResponseCodeIs(err, http.StatusNotFound) {
+		t.Errorf("expected a %d, got %v", http.StatusNotFound, err)
+	}
+}
+
+func TestFaultsAndJobErrors(t *testing.T) {
+	srv := NewServer()
+	defer srv.Close()
+
+	tenant := srv.AddAccount("user", "key")
+	domID := srv.AddDomain(tenant, "example.com")
+
+	service, err := newClient(t, srv, "user", "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	opts := records.CreateOpts{Name: "_acme-challenge.example.com", Type: "TXT", Data: "token"}
+
+	srv.AddFault(Fault{Method: http.MethodPost, Path: "/records", Status: http.StatusServiceUnavailable, Times: 1})
+	_, err = records.Create(ctx, service, domID, opts).Extract()
+	if !gophercloud.ResponseCodeIs(err, http.StatusServiceUnavailable) {
+		t.Errorf("expected a %d, got %v", http.StatusServiceUnavailable, err)
+	}
+
+	srv.SetJobError("Domain is locked")
+	_, err = records.Create(ctx, service, domID, opts).Extract()
+	if err == nil || !strings.Contains(err.Error(), "Domain is locked") {
+		t.Errorf("expected error %q, got %v", "Domain is locked", err)
+	}
+	if got := srv.Records(domID); len(got) != 0 {
+		t.Errorf("expected none, got %v", got)
+	}
+
+	srv.Reset()
+	srv.SetLatency(50 * time.Millisecond)
+	start := time.Now()
+	_, err = records.Create(ctx, service, domID, opts).Extract()
+	if err != nil {
+		t.Error(err)
+	}
+	if d := time.Since(start); d < 100*time.Millisecond {
+		t.Errorf("took %s, want at least 100ms", d)
+	}
+}
+
+func TestExpireTokens(t *testing.T) {
+	srv := NewServer()
+	defer srv.Close()
+
+	tenant := srv.AddAccount("user", "key")
+	srv.AddDomain(tenant, "example.com")
+
+	service, err := newClient(t, srv, "user", "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv.ExpireTokens()
+
+	ctx := context.Background()
+	err = domains.List(ctx, service, nil).EachPage(ctx, func(context.Context, pagination.Page) (bool, error) {
+		return true, nil
+	})
+	if !gophercloud.ResponseCodeIs(err, http.StatusUnauthorized) {
+		t.Errorf("expected a %d, got %v", http.StatusUnauthorized, err)
+	}
+}
+
+func TestDNS(t *testing.T) {
+	srv := NewServer()
+	defer srv.Close()
+
+	tenant := srv.AddAccount("user", "key")
+	domID := srv.AddDomain(tenant, "example.com")
+	srv.AddRecord(Record{DomainID: domID, Name: "_acme-challenge.example.com", Type: "TXT", Data: "one", TTL: 300})
+	srv.AddRecord(Record{DomainID: domID, Name: "_acme-challenge.example.com", Type: "TXT", Data: "two", TTL: 300})
+
+	addr, err := srv.StartDNS("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	query := func(name string, qtype uint16) *dns.Msg {
+		msg := new(dns.Msg)
+		msg.SetQuestion(name, qtype)
+		in, err := dns.Exchange(msg, addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return in
+	}
+
+	in := query("_acme-challenge.EXAMPLE.com.", dns.TypeTXT)
+	if in.Rcode != dns.RcodeSuccess {
+		t.Errorf("in.Rcode = %v, want %v", in.Rcode, dns.RcodeSuccess)
+	}
+	if !in.Authoritative {
+		t.Error("expected an authoritative answer")
+	}
+	var data []string
+	for _, rr := range in.Answer {
+		data = append(data, rr.(*dns.TXT).Txt...)
+	}
+	slices.Sort(data)
+	if !slices.Equal(data, []string{"one", "two"}) {
+		t.Errorf("data = %v, want [one two]", data)
+	}
+
+	in = query("_acme-challenge.example.com.", dns.TypeCNAME)
+	if in.Rcode != dns.RcodeSuccess {
+		t.Errorf("in.Rcode = %v, want %v", in.Rcode, dns.RcodeSuccess)
+	}
+	if len(in.Answer) != 0 {
+		t.Errorf("expected none, got %v", in.Answer)
+	}
+
+	in = query("missing.example.com.", dns.TypeTXT)
+	if in.Rcode != dns.RcodeNameError {
+		t.Errorf("in.Rcode = %v, want %v", in.Rcode, dns.RcodeNameError)
+	}
+
+	in = query("example.com.", dns.TypeSOA)
+	if len(in.Answer) != 1 {
+		t.Fatalf("expected 1, got %v", in.Answer)
+	}
+
+	in = query("example.org.", dns.TypeTXT)
+	if in.Rcode != dns.RcodeRefused {
+		t.Errorf("in.Rcode = %v, want %v", in.Rcode, dns.RcodeRefused)
+	}
+}
@@ -0,0 +1,177 @@
+package solver
+
+import (
+	"fmt"
+
+	"github.com/gophercloud/gophercloud/v2"
+
+	"github.com/rackerlabs/cert-manager-webhook-rackspace/internal/redact"
+	"github.com/rackerlabs/cert-manager-webhook-rackspace/pkg/designate"
+	"github.com/rackerlabs/cert-manager-webhook-rackspace/pkg/rackspace"
+)
+
+// The DNS providers a solver config can select.
+const (
+	ProviderRackspace = "rackspace"
+	ProviderDesignate = "designate"
+)
+
+// defaultKeystoneDomain is the Keystone domain of users and projects when
+// the Secret does not name one.
+const defaultKeystoneDomain = "Default"
+
+// Account holds the credentials of a single DNS provider account, read from
+// a Secret. Only the config matching Provider is set.
+type Account struct {
+	Provider string
+	// SecretRef is the `namespace/name` of the Secret the credentials came from.
+	SecretRef string
+
+	Rackspace rackspace.Config
+	Designate designate.Config
+}
+
+// KeystoneScope selects the project a Designate account logs in to and the
+// domains its user and project belong to. Every field has a Secret key of the
+// same name in kebab case, such as `project-domain-name`, which takes
+// precedence.
+type KeystoneScope struct {
+	ProjectID         string `json:"projectID,omitempty"`
+	ProjectName       string `json:"projectName,omitempty"`
+	ProjectDomainID   string `json:"projectDomainID,omitempty"`
+	ProjectDomainName string `json:"projectDomainName,omitempty"`
+	UserDomainID      string `json:"userDomainID,omitempty"`
+	UserDomainName    string `json:"userDomainName,omitempty"`
+}
+
+// keystoneScope merges the scope of the Secret over the solver config's.
+func keystoneScope(data map[string][]byte, cfg Config) KeystoneScope {
+	var base KeystoneScope
+	if cfg.Scope != nil {
+		base = *cfg.Scope
+	}
+
+	return KeystoneScope{
+		ProjectID:         optionalSecretData(data, "project-id", base.ProjectID),
+		ProjectName:       optionalSecretData(data, "project-name", base.ProjectName),
+		ProjectDomainID:   optionalSecretData(data, "project-domain-id", base.ProjectDomainID),
+		ProjectDomainName: optionalSecretData(data, "project-domain-name", base.ProjectDomainName),
+		UserDomainID:      optionalSecretData(data, "user-domain-id", base.UserDomainID),
+		UserDomainName:    optionalSecretData(data, "user-domain-name", base.UserDomainName),
+	}
+}
+
+// designateConfig reads the Keystone credentials of an OpenStack project. The
+// Secret's `auth-method`, or else the solver config's authMethod, picks
+// between a user's password and an application credential, defaulting to
+// the application credential when the Secret holds one.
+func designateConfig(c *Solver, data map[string][]byte, secretRef string, cfg Config) (designate.Config, error) {
+	var config designate.Config
+
+	authURL, err := stringFromSecretData(data, "auth-url")
+	if err != nil {
+		return config, fmt.Errorf("unable to get auth-url from secret `%s`: %w", secretRef, err)
+	}
+
+	appCredID := optionalSecretData(data, "application-credential-id", "")
+	appCredName := optionalSecretData(data, "application-credential-name", "")
+
+	method := optionalSecretData(data, "auth-method", cfg.AuthMethod)
+	if method == "" {
+		method = designate.AuthMethodPassword
+		if appCredID != "" || appCredName != "" {
+			method = designate.AuthMethodApplicationCredential
+		}
+	}
+
+	scope := keystoneScope(data, cfg)
+
+	ao := gophercloud.AuthOptions{
+		IdentityEndpoint: authURL,
+		DomainID:         scope.UserDomainID,
+		DomainName:       scope.UserDomainName,
+	}
+	if ao.DomainID == "" && ao.DomainName == "" {
+		ao.DomainName = defaultKeystoneDomain
+	}
+
+	switch method {
+	case designate.AuthMethodApplicationCredential:
+		secret, err := stringFromSecretData(data, "application-credential-secret")
+		if err != nil {
+			return config, fmt.Errorf("unable to get application-credential-secret from secret `%s`: %w", secretRef, err)
+		}
+
+		switch {
+		case appCredID != "":
+			ao.ApplicationCredentialID = appCredID
+		case appCredName != "":
+			// a credential's name is only unique per user
+			username, err := stringFromSecretData(data, "username")
+			if err != nil {
+				return config, fmt.Errorf("unable to get username for application-credential-name from secret `%s`: %w", secretRef, err)
+			}
+			ao.ApplicationCredentialName = appCredName
+			ao.Username = username
+		default:
+			return config, fmt.Errorf("unable to get application-credential-id or application-credential-name from secret `%s`", secretRef)
+		}
+
+		redact.Register(appCredID, ao.Username, secret)
+
+		ao.ApplicationCredentialSecret = secret
+	case designate.AuthMethodPassword:
+		username, err := stringFromSecretData(data, "username")
+		if err != nil {
+			return config, fmt.Errorf("unable to get username from secret `%s`: %w", secretRef, err)
+		}
+
+		password, err := stringFromSecretData(data, "password")
+		if err != nil {
+			return config, fmt.Errorf("unable to get password from secret `%s`: %w", secretRef, err)
+		}
+
+		redact.Register(username, password)
+
+		ao.Username = username
+		ao.Password = password
+
+		if scope.ProjectID == "" && scope.ProjectName == "" {
+			return config, fmt.Errorf("unable to get project-id or project-name from secret `%s` or the solver config", secretRef)
+		}
+	default:
+		return config, fmt.Errorf("unknown auth method `%s` for secret `%s`", method, secretRef)
+	}
+
+	// application credentials only check the scope, as they are bound to
+	// their project
+	if scope.ProjectID != "" || scope.ProjectName != "" {
+		ao.Scope = &gophercloud.AuthScope{ProjectID: scope.ProjectID}
+		if scope.ProjectID == "" {
+			ao.Scope.ProjectName = scope.ProjectName
+			ao.Scope.DomainID = scope.ProjectDomainID
+			ao.Scope.DomainName = scope.ProjectDomainName
+			if ao.Scope.DomainID == "" && ao.Scope.DomainName == "" && method == designate.AuthMethodPassword {
+				// projects live in their user's domain unless told otherwise
+				ao.Scope.DomainID, ao.Scope.DomainName = ao.DomainID, ao.DomainName
+			}
+		}
+	}
+
+	config.AuthOptions = ao
+	config.Region = optionalSecretData(data, "region", "")
+	config.SecretRef = secretRef
+	config.Transport = c.Transport
+	config.UserAgent = c.userAgent()
+
+	return config, nil
+}
+
+// optionalSecretData returns the value of key, or fallback when it is
+// missing or empty.
+func optionalSecretData(data map[string][]byte, key string, fallback string) string {
+	if v, err := stringFromSecretData(data, key); err == nil && v != "" {
+		return v
+	}
+	return fallback
+}
@@ -0,0 +1,54 @@
+package solver
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// defaultSecretRef finds the default credentials Secret for a namespace,
+// reporting every place it looked when there is none.
+func defaultSecretRef(ctx context.Context, c *Solver, namespace string) (string, error) {
+	var looked []string
+
+	if c.DefaultSecretName != "" {
+		_, err := c.Client.CoreV1().Secrets(namespace).Get(ctx, c.DefaultSecretName, metav1.GetOptions{})
+		if err == nil {
+			return c.DefaultSecretName, nil
+		}
+		if !apierrors.IsNotFound(err) {
+			return "", fmt.Errorf("unable to get default secret `%s/%s`: %w", namespace, c.DefaultSecretName, err)
+		}
+		looked = append(looked, fmt.Sprintf("secret `%s/%s`", namespace, c.DefaultSecretName))
+	}
+
+	if c.DefaultSecretSelector != "" {
+		list, err := c.Client.CoreV1().Secrets(namespace).List(ctx, metav1.ListOptions{LabelSelector: c.DefaultSecretSelector})
+		if err != nil {
+			return "", fmt.Errorf("unable to list secrets in `%s` matching `%s`: %w", namespace, c.DefaultSecretSelector, err)
+		}
+
+		switch len(list.Items) {
+		case 1:
+			return list.Items[0].Name, nil
+		case 0:
+			looked = append(looked, fmt.Sprintf("secrets in `%s` labelled `%s`", namespace, c.DefaultSecretSelector))
+		default:
+			names := make([]string, 0, len(list.Items))
+			for _, sec := range list.Items {
+				names = append(names, sec.Name)
+			}
+			return "", fmt.Errorf("multiple secrets in `%s` are labelled `%s`, set authSecretRef to pick one of: %s",
+				namespace, c.DefaultSecretSelector, strings.Join(names, ", "))
+		}
+	}
+
+	if len(looked) == 0 {
+		return "", fmt.Errorf("authSecretRef is not set and no default credentials are configured")
+	}
+
+	return "", fmt.Errorf("authSecretRef is not set and no default credentials were found, looked for %s", strings.Join(looked, " and "))
+}
@@ -0,0 +1,483 @@
+package solver
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/cert-manager/cert-manager/pkg/acme/webhook/apis/acme/v1alpha1"
+	"github.com/gophercloud/gophercloud/v2"
+	extapi "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	"github.com/rackerlabs/cert-manager-webhook-rackspace/pkg/designatetest"
+)
+
+// TestBothProviders solves challenges for a Rackspace zone and a Designate
+// zone with a single issuer config.
+func TestBothProviders(t *testing.T) {
+	openstack := designatetest.NewServer()
+	defer openstack.Close()
+
+	project := openstack.AddProject("dns")
+	openstack.AddApplicationCredential(project, "app-cred", "app-secret")
+	openstack.AddZone(project, "example.net")
+
+	env := newTestEnv(t, []runtime.Object{credsSecret("openstack", map[string]string{
+		"auth-url":                      openstack.AuthURL(),
+		"application-credential-id":     "app-cred",
+		"application-credential-secret": "app-secret",
+	})}...)
+
+	const config = `{"authSecretRef":"creds","routes":[{"zone":"example.net","provider":"designate","authSecretRef":"openstack"}]}`
+
+	rackspaceCh := challengeWithConfig("_acme-challenge.example.com.", "rackspace-key", config)
+	rackspaceCh.ResolvedZone = "example.com."
+	designateCh := challengeWithConfig("_acme-challenge.example.net.", "designate-key", config)
+	designateCh.ResolvedZone = "example.net."
+	otherCh := challengeWithConfig("_acme-challenge.example.net.", "other-key", config)
+	otherCh.ResolvedZone = "example.net."
+
+	if err := env.solver.Present(rackspaceCh); err != nil {
+		t.Fatal(err)
+	}
+	if err := env.solver.Present(designateCh); err != nil {
+		t.Fatal(err)
+	}
+	if err := env.solver.Present(otherCh); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := env.srv.TXTRecords("_acme-challenge.example.com"), []string{"rackspace-key"}; !slices.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := openstack.TXTRecords("_acme-challenge.example.net"), []string{"designate-key", "other-key"}; !slices.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	if err := env.solver.CleanUp(designateCh); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := openstack.TXTRecords("_acme-challenge.example.net"), []string{"other-key"}; !slices.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	if err := env.solver.CleanUp(otherCh); err != nil {
+		t.Fatal(err)
+	}
+	if err := env.solver.CleanUp(rackspaceCh); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := openstack.TXTRecords("_acme-challenge.example.net"); len(got) != 0 {
+		t.Errorf("expected none, got %v", got)
+	}
+	if got := env.srv.TXTRecords("_acme-challenge.example.com"); len(got) != 0 {
+		t.Errorf("expected none, got %v", got)
+	}
+}
+
+func TestDesignatePassword(t *testing.T) {
+	openstack := designatetest.NewServer()
+	defer openstack.Close()
+
+	project := openstack.AddProject("dns")
+	openstack.AddUser(project, "os-user", "os-password")
+	openstack.AddZone(project, "example.com")
+
+	env := newTestEnv(t, []runtime.Object{credsSecret("openstack", map[string]string{
+		"auth-url":     openstack.AuthURL(),
+		"username":     "os-user",
+		"password":     "os-password",
+		"project-name": "dns",
+		"region":       designatetest.Region,
+	})}...)
+
+	ch := challengeWithConfig("_acme-challenge.example.com.", "key", `{"provider":"designate","authSecretRef":"openstack"}`)
+
+	if err := env.solver.Present(ch); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := openstack.TXTRecords("_acme-challenge.example.com"), []string{"key"}; !slices.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got := env.srv.TXTRecords("_acme-challenge.example.com"); len(got) != 0 {
+		t.Errorf("expected none, got %v", got)
+	}
+
+	if err := env.solver.CleanUp(ch); err != nil {
+		t.Fatal(err)
+	}
+	if got := openstack.TXTRecords("_acme-challenge.example.com"); len(got) != 0 {
+		t.Errorf("expected none, got %v", got)
+	}
+}
+
+func TestDesignateRecordSetOwnership(t *testing.T) {
+	openstack := designatetest.NewServer()
+	defer openstack.Close()
+
+	project := openstack.AddProject("dns")
+	openstack.AddApplicationCredential(project, "app-cred", "app-secret")
+	openstack.AddZone(project, "example.com")
+
+	env := newTestEnv(t, []runtime.Object{credsSecret("openstack", map[string]string{
+		"auth-url":                      openstack.AuthURL(),
+		"application-credential-id":     "app-cred",
+		"application-credential-secret": "app-secret",
+	})}...)
+	env.solver.ClusterID = "blue"
+
+	green := restarted(env)
+	green.IntentNamespace = ""
+	green.ClusterID = "green"
+
+	const config = `{"provider":"designate","authSecretRef":"openstack"}`
+	blueCh := challengeWithConfig("_acme-challenge.example.com.", "blue-key", config)
+	greenCh := challengeWithConfig("_acme-challenge.example.com.", "green-key", config)
+
+	if err := env.solver.Present(blueCh); err != nil {
+		t.Fatal(err)
+	}
+
+	// green's value would pass for one of blue's in blue's recordset
+	err := green.Present(greenCh)
+	if err == nil || !strings.Contains(err.Error(), "of another cluster") {
+		t.Errorf("expected error %q, got %v", "of another cluster", err)
+	}
+	if got, want := openstack.TXTRecords("_acme-challenge.example.com"), []string{"blue-key"}; !slices.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	// another challenge of blue's shares it
+	if err := env.solver.Present(challengeWithConfig("_acme-challenge.example.com.", "other-key", config)); err != nil {
+		t.Fatal(err)
+	}
+
+	// once blue is done green presents again in a recordset of its own
+	for _, key := range []string{"blue-key", "other-key"} {
+		if err := env.solver.CleanUp(challengeWithConfig("_acme-challenge.example.com.", key, config)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := green.Present(greenCh); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := openstack.TXTRecords("_acme-challenge.example.com"), []string{"green-key"}; !slices.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+// TestDesignatePresentListsOnce looks the recordset up once per Present, for
+// both the ownership check and adding the value.
+func TestDesignatePresentListsOnce(t *testing.T) {
+	openstack := designatetest.NewServer()
+	defer openstack.Close()
+
+	project := openstack.AddProject("dns")
+	openstack.AddApplicationCredential(project, "app-cred", "app-secret")
+	openstack.AddZone(project, "example.com")
+
+	env := newTestEnv(t, []runtime.Object{credsSecret("openstack", map[string]string{
+		"auth-url":                      openstack.AuthURL(),
+		"application-credential-id":     "app-cred",
+		"application-credential-secret": "app-secret",
+	})}...)
+
+	var recorder requestRecorder
+	env.solver.Transport = &recorder
+
+	const config = `{"provider":"designate","authSecretRef":"openstack"}`
+	for i, key := range []string{"first-key", "second-key", "second-key"} {
+		before := recorder.count(http.MethodGet, openstack.AuthURL(), "/recordsets")
+		if err := env.solver.Present(challengeWithConfig("_acme-challenge.example.com.", key, config)); err != nil {
+			t.Fatal(err)
+		}
+		if got := recorder.count(http.MethodGet, openstack.AuthURL(), "/recordsets") - before; got != 1 {
+			t.Errorf("present %d listed recordsets %d times, want once", i, got)
+		}
+	}
+	if got, want := openstack.TXTRecords("_acme-challenge.example.com"), []string{"first-key", "second-key"}; !slices.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestDesignateConfig(t *testing.T) {
+	const authURL = "https://keystone.example.com/v3"
+
+	for _, tc := range []struct {
+		name    string
+		data    map[string]string
+		config  string
+		want    gophercloud.AuthOptions
+		wantErr string
+	}{
+		{
+			name: "password scoped by project name",
+			data: map[string]string{"username": "u", "password": "p", "project-name": "dns"},
+			want: gophercloud.AuthOptions{
+				Username: "u", Password: "p", DomainName: "Default",
+				Scope: &gophercloud.AuthScope{ProjectName: "dns", DomainName: "Default"},
+			},
+		},
+		{
+			name: "password with domains by ID",
+			data: map[string]string{
+				"username": "u", "password": "p", "project-name": "dns",
+				"user-domain-id": "users", "project-domain-id": "projects",
+			},
+			want: gophercloud.AuthOptions{
+				Username: "u", Password: "p", DomainID: "users",
+				Scope: &gophercloud.AuthScope{ProjectName: "dns", DomainID: "projects"},
+			},
+		},
+		{
+			name:   "scope from the solver config",
+			data:   map[string]string{"username": "u", "password": "p"},
+			config: `{"scope":{"projectID":"1234","userDomainName":"Customers"}}`,
+			want: gophercloud.AuthOptions{
+				Username: "u", Password: "p", DomainName: "Customers",
+				Scope: &gophercloud.AuthScope{ProjectID: "1234"},
+			},
+		},
+		{
+			name:   "secret scope wins over the solver config",
+			data:   map[string]string{"username": "u", "password": "p", "project-id": "5678"},
+			config: `{"scope":{"projectID":"1234"}}`,
+			want: gophercloud.AuthOptions{
+				Username: "u", Password: "p", DomainName: "Default",
+				Scope: &gophercloud.AuthScope{ProjectID: "5678"},
+			},
+		},
+		{
+			name: "application credential by ID",
+			data: map[string]string{"application-credential-id": "id", "application-credential-secret": "s"},
+			want: gophercloud.AuthOptions{
+				ApplicationCredentialID: "id", ApplicationCredentialSecret: "s", DomainName: "Default",
+			},
+		},
+		{
+			name: "application credential by name",
+			data: map[string]string{
+				"application-credential-name": "cert-manager", "application-credential-secret": "s",
+				"username": "u", "user-domain-name": "Customers",
+			},
+			want: gophercloud.AuthOptions{
+				ApplicationCredentialName: "cert-manager", ApplicationCredentialSecret: "s",
+				Username: "u", DomainName: "Customers",
+			},
+		},
+		{
+			name: "application credential with an expected project",
+			data: map[string]string{
+				"application-credential-id": "id", "application-credential-secret": "s",
+				"project-name": "dns", "project-domain-name": "Customers",
+			},
+			want: gophercloud.AuthOptions{
+				ApplicationCredentialID: "id", ApplicationCredentialSecret: "s", DomainName: "Default",
+				Scope: &gophercloud.AuthScope{ProjectName: "dns", DomainName: "Customers"},
+			},
+		},
+		{
+			name: "auth method selected by the solver config",
+			data: map[string]string{
+				"username": "u", "password": "p", "project-id": "1234",
+				"application-credential-id": "id", "application-credential-secret": "s",
+			},
+			config: `{"authMethod":"password"}`,
+			want: gophercloud.AuthOptions{
+				Username: "u", Password: "p", DomainName: "Default",
+				Scope: &gophercloud.AuthScope{ProjectID: "1234"},
+			},
+		},
+		{
+			name: "auth method selected by the secret",
+			data: map[string]string{
+				"auth-method": "application-credential", "username": "u", "password": "p",
+				"application-credential-id": "id", "application-credential-secret": "s",
+			},
+			config: `{"authMethod":"password"}`,
+			want: gophercloud.AuthOptions{
+				ApplicationCredentialID: "id", ApplicationCredentialSecret: "s", DomainName: "Default",
+			},
+		},
+		{
+			name:    "application credential without a secret",
+			data:    map[string]string{"application-credential-id": "id"},
+			wantErr: "unable to get application-credential-secret from secret `default/openstack`",
+		},
+		{
+			name:    "application credential name without a user",
+			data:    map[string]string{"application-credential-name": "cert-manager", "application-credential-secret": "s"},
+			wantErr: "unable to get username for application-credential-name",
+		},
+		{
+			name:    "application credential method without a credential",
+			data:    map[string]string{"application-credential-secret": "s"},
+			config:  `{"authMethod":"application-credential"}`,
+			wantErr: "unable to get application-credential-id or application-credential-name",
+		},
+		{
+			name:    "unknown auth method",
+			data:    map[string]string{"username": "u", "password": "p"},
+			config:  `{"authMethod":"totp"}`,
+			wantErr: "unknown auth method `totp` for secret `default/openstack`",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.data["auth-url"] = authURL
+			env := newTestEnv(t, credsSecret("openstack", tc.data))
+
+			if tc.config == "" {
+				tc.config = `{}`
+			}
+			cfg, err := loadConfig(&extapi.JSON{Raw: []byte(tc.config)})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			account, err := secretConfig(context.Background(), env.solver, testNamespace, "openstack", ProviderDesignate, cfg)
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Errorf("expected error %q, got %v", tc.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			tc.want.IdentityEndpoint = authURL
+			if !reflect.DeepEqual(account.Designate.AuthOptions, tc.want) {
+				t.Errorf("account.Designate.AuthOptions = %+v, want %+v", account.Designate.AuthOptions, tc.want)
+			}
+		})
+	}
+}
+
+// TestDesignateApplicationCredentialScope logs in with a named application
+// credential and checks it against the project given in the solver config.
+func TestDesignateApplicationCredentialScope(t *testing.T) {
+	openstack := designatetest.NewServer()
+	defer openstack.Close()
+
+	project := openstack.AddProject("dns")
+	openstack.AddUser(project, "os-user", "os-password")
+	openstack.AddUserApplicationCredential("os-user", "cert-manager", "app-secret")
+	openstack.AddZone(project, "example.com")
+
+	env := newTestEnv(t, []runtime.Object{credsSecret("openstack", map[string]string{
+		"auth-url":                      openstack.AuthURL(),
+		"username":                      "os-user",
+		"application-credential-name":   "cert-manager",
+		"application-credential-secret": "app-secret",
+	})}...)
+
+	ch := challengeWithConfig("_acme-challenge.example.com.", "key",
+		`{"provider":"designate","authSecretRef":"openstack","authMethod":"application-credential","scope":{"projectName":"dns"}}`)
+
+	if err := env.solver.Present(ch); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := openstack.TXTRecords("_acme-challenge.example.com"), []string{"key"}; !slices.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if err := env.solver.CleanUp(ch); err != nil {
+		t.Fatal(err)
+	}
+
+	wrongProject := challengeWithConfig("_acme-challenge.example.com.", "key",
+		`{"provider":"designate","authSecretRef":"openstack","scope":{"projectName":"other"}}`)
+
+	err := env.solver.Present(wrongProject)
+	if err == nil || !strings.Contains(err.Error(), "application credential is bound to project `dns`") {
+		t.Errorf("expected error %q, got %v", "application credential is bound to project `dns`", err)
+	}
+	if got := openstack.TXTRecords("_acme-challenge.example.com"); len(got) != 0 {
+		t.Errorf("expected none, got %v", got)
+	}
+}
+
+// requestRecorder records the requests it carries.
+type requestRecorder struct {
+	mu       sync.Mutex
+	requests []*url.URL
+	methods  []string
+}
+
+func (r *requestRecorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	r.mu.Lock()
+	r.requests = append(r.requests, req.URL)
+	r.methods = append(r.methods, req.Method)
+	r.mu.Unlock()
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+// count counts the requests with the method, any when empty, to the host of
+// rawURL whose path contains path.
+func (r *requestRecorder) count(method string, rawURL string, path string) int {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		panic(err)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	n := 0
+	for i, req := range r.requests {
+		if (method == "" || r.methods[i] == method) && req.Host == u.Host && strings.Contains(req.Path, path) {
+			n++
+		}
+	}
+	return n
+}
+
+// TestRackspaceTransport carries the requests to Rackspace only through
+// RackspaceTransport, and those to Designate through Transport.
+func TestRackspaceTransport(t *testing.T) {
+	openstack := designatetest.NewServer()
+	defer openstack.Close()
+
+	project := openstack.AddProject("dns")
+	openstack.AddApplicationCredential(project, "app-cred", "app-secret")
+	openstack.AddZone(project, "example.net")
+
+	env := newTestEnv(t, []runtime.Object{credsSecret("openstack", map[string]string{
+		"auth-url":                      openstack.AuthURL(),
+		"application-credential-id":     "app-cred",
+		"application-credential-secret": "app-secret",
+	})}...)
+
+	var shared, rackspaceOnly requestRecorder
+	env.solver.Transport = &shared
+	env.solver.RackspaceTransport = &rackspaceOnly
+
+	rackspaceCh := challenge("_acme-challenge.example.com.", "rackspace-key")
+	designateCh := challengeWithConfig("_acme-challenge.example.net.", "designate-key", `{"provider":"designate","authSecretRef":"openstack"}`)
+	designateCh.ResolvedZone = "example.net."
+
+	for _, ch := range []*v1alpha1.ChallengeRequest{rackspaceCh, designateCh} {
+		if err := env.solver.Present(ch); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if got := rackspaceOnly.count("", env.srv.IdentityEndpoint(), ""); got == 0 {
+		t.Error("expected Rackspace requests through RackspaceTransport")
+	}
+	if got := rackspaceOnly.count("", openstack.AuthURL(), ""); got != 0 {
+		t.Errorf("expected no Designate requests through RackspaceTransport, got %d", got)
+	}
+	if got := shared.count("", env.srv.IdentityEndpoint(), ""); got != 0 {
+		t.Errorf("expected no Rackspace requests through Transport, got %d", got)
+	}
+	if got := shared.count("", openstack.AuthURL(), ""); got == 0 {
+		t.Error("expected Designate requests through Transport")
+	}
+}
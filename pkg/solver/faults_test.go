@@ -0,0 +1,167 @@
+package solver
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rackerlabs/cert-manager-webhook-rackspace/internal/faultinject"
+	"github.com/rackerlabs/cert-manager-webhook-rackspace/pkg/rackspacetest"
+)
+
+// TestFaults runs Present or CleanUp once per entry of attempts against a
+// misbehaving API, each entry being the error expected from that attempt.
+func TestFaults(t *testing.T) {
+	const name = "_acme-challenge.example.com"
+
+	for _, tc := range []struct {
+		name     string
+		cleanUp  bool
+		rules    []faultinject.Rule
+		timeout  time.Duration
+		attempts []string
+		wantLeft []string
+	}{
+		{
+			name:     "present rate limited",
+			rules:    []faultinject.Rule{{Kind: faultinject.RateLimit, Method: http.MethodPost, Path: "/records", Count: 1, RetryAfter: time.Second}},
+			attempts: []string{"unable to create DNS record", ""},
+			wantLeft: []string{"key"},
+		},
+		{
+			name:     "present rate limited while finding domain",
+			rules:    []faultinject.Rule{{Kind: faultinject.RateLimit, Path: "/domains", Method: http.MethodGet}},
+			attempts: []string{"unable to find domain ID"},
+		},
+		{
+			name:     "present through a burst of server errors",
+			rules:    []faultinject.Rule{{Kind: faultinject.ServerError, Method: http.MethodPost, Path: "/records", Count: 2}},
+			attempts: []string{"503", "503", ""},
+			wantLeft: []string{"key"},
+		},
+		{
+			name:     "present login failing",
+			rules:    []faultinject.Rule{{Kind: faultinject.ServerError, Path: "/tokens", Status: http.StatusBadGateway}},
+			attempts: []string{"unable to authenticate to rackspace"},
+		},
+		{
+			name:     "present slower than the timeout",
+			rules:    []faultinject.Rule{{Kind: faultinject.Slow, Path: "/domains", Latency: time.Second}},
+			timeout:  100 * time.Millisecond,
+			attempts: []string{"context deadline exceeded"},
+		},
+		{
+			name:     "present slow but in time",
+			rules:    []faultinject.Rule{{Kind: faultinject.Slow, Latency: 20 * time.Millisecond}},
+			attempts: []string{""},
+			wantLeft: []string{"key"},
+		},
+		{
+			name:     "present truncated domain list",
+			rules:    []faultinject.Rule{{Kind: faultinject.TruncatedJSON, Path: "/domains", Method: http.MethodGet, Count: 1}},
+			attempts: []string{"unable to find domain ID", ""},
+			wantLeft: []string{"key"},
+		},
+		{
+			// the record was created but the reply was lost, the retry finds
+			// it instead of adding a second copy
+			name:     "present truncated job status",
+			rules:    []faultinject.Rule{{Kind: faultinject.TruncatedJSON, Path: "/status/", Count: 1}},
+			attempts: []string{"unable to create DNS record", ""},
+			wantLeft: []string{"key"},
+		},
+		{
+			name:     "present token expired mid operation",
+			rules:    []faultinject.Rule{{Kind: faultinject.ExpiredToken, Path: "/records", Count: 1}},
+			attempts: []string{""},
+			wantLeft: []string{"key"},
+		},
+		{
+			name:     "present token keeps expiring",
+			rules:    []faultinject.Rule{{Kind: faultinject.ExpiredToken, Path: "/records"}},
+			attempts: []string{"401"},
+		},
+		{
+			name:     "present job ends in error",
+			rules:    []faultinject.Rule{{Kind: faultinject.JobError, Message: "Domain is locked", Count: 1}},
+			attempts: []string{"Domain is locked", ""},
+			wantLeft: []string{"key"},
+		},
+		{
+			name:     "clean up rate limited",
+			cleanUp:  true,
+			rules:    []faultinject.Rule{{Kind: faultinject.RateLimit, Method: http.MethodDelete, Count: 1, RetryAfter: time.Second}},
+			attempts: []string{"unable to delete DNS record", ""},
+		},
+		{
+			name:     "clean up through a burst of server errors",
+			cleanUp:  true,
+			rules:    []faultinject.Rule{{Kind: faultinject.ServerError, Path: "/records", Method: http.MethodGet, Count: 1}},
+			attempts: []string{"unable to find DNS record", ""},
+		},
+		{
+			name:     "clean up slower than the timeout",
+			cleanUp:  true,
+			rules:    []faultinject.Rule{{Kind: faultinject.Slow, Method: http.MethodDelete, Latency: time.Second}},
+			timeout:  100 * time.Millisecond,
+			attempts: []string{"context deadline exceeded"},
+			wantLeft: []string{"key"},
+		},
+		{
+			name:     "clean up truncated record list",
+			cleanUp:  true,
+			rules:    []faultinject.Rule{{Kind: faultinject.TruncatedJSON, Path: "/records", Method: http.MethodGet}},
+			attempts: []string{"unable to find DNS record"},
+			wantLeft: []string{"key"},
+		},
+		{
+			name:     "clean up token expired mid operation",
+			cleanUp:  true,
+			rules:    []faultinject.Rule{{Kind: faultinject.ExpiredToken, Method: http.MethodDelete, Count: 1}},
+			attempts: []string{""},
+		},
+		{
+			name:     "clean up job ends in error",
+			cleanUp:  true,
+			rules:    []faultinject.Rule{{Kind: faultinject.JobError, Message: "Domain is locked"}},
+			attempts: []string{"Domain is locked", "Domain is locked"},
+			wantLeft: []string{"key"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			env := newTestEnv(t)
+			if tc.cleanUp {
+				env.srv.AddRecord(rackspacetest.Record{DomainID: env.domID, Name: name, Type: "TXT", Data: "key"})
+			}
+
+			env.solver.Timeout = tc.timeout
+			env.solver.Transport = faultinject.New(nil, tc.rules...)
+
+			ch := challenge(name+".", "key")
+			for i, wantErr := range tc.attempts {
+				var err error
+				if tc.cleanUp {
+					err = env.solver.CleanUp(ch)
+				} else {
+					err = env.solver.Present(ch)
+				}
+
+				if wantErr != "" {
+					if err == nil || !strings.Contains(err.Error(), wantErr) {
+						t.Errorf("attempt %d: expected error %q, got %v", i+1, wantErr, err)
+					}
+				} else {
+					if err != nil {
+						t.Errorf("attempt %d: %v", i+1, err)
+					}
+				}
+			}
+
+			if got := env.srv.TXTRecords(name); !slices.Equal(got, tc.wantLeft) {
+				t.Errorf("left %v, want %v", got, tc.wantLeft)
+			}
+		})
+	}
+}
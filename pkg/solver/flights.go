@@ -0,0 +1,84 @@
+package solver
+
+import (
+	"context"
+
+	"github.com/cert-manager/cert-manager/pkg/acme/webhook/apis/acme/v1alpha1"
+	"k8s.io/klog/v2"
+
+	"github.com/rackerlabs/cert-manager-webhook-rackspace/pkg/dnsprovider"
+)
+
+// The flights the solver shares between concurrent callers. Logins and zone
+// lookups run detached from the caller that started them, see shared.
+const (
+	flightPresent = "present"
+	flightCleanUp = "cleanup"
+	flightLogin   = "login"
+	flightZone    = "zone"
+)
+
+// coalesce runs fn once for concurrent calls of the same action for the same
+// challenge, such as a Present retried by cert-manager while the first is
+// still running, and hands its result to all of them.
+func (c *Solver) coalesce(action string, ch *v1alpha1.ChallengeRequest, fn func(*v1alpha1.ChallengeRequest) error) error {
+	key := action + "|" + normalizeName(ch.ResolvedFQDN) + "|" + ch.Key
+
+	_, err, shared := c.flights.Do(key, func() (any, error) {
+		return nil, fn(ch)
+	})
+	if shared {
+		klog.V(4).Infof("Shared a single %s of %s between concurrent calls", action, ch.ResolvedFQDN)
+	}
+
+	return err
+}
+
+// provider connects to the DNS provider of an account, joining a login to the
+// same account that is already in progress.
+func (c *Solver) provider(ctx context.Context, account Account) (dnsprovider.Provider, error) {
+	key := flightLogin + "|" + account.Provider + "|" + account.SecretRef
+
+	v, err := c.shared(ctx, key, func(ctx context.Context) (any, error) {
+		return c.connect(ctx, account)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return v.(dnsprovider.Provider), nil
+}
+
+// findZone looks up the ID of a zone in an account, joining a lookup of the
+// same zone that is already in progress.
+func (c *Solver) findZone(ctx context.Context, provider dnsprovider.Provider, account Account, domainName string) (string, error) {
+	key := flightZone + "|" + account.Provider + "|" + account.SecretRef + "|" + domainName
+
+	v, err := c.shared(ctx, key, func(ctx context.Context) (any, error) {
+		return provider.FindZone(ctx, domainName)
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return v.(string), nil
+}
+
+// shared runs fn once for concurrent calls with the same key. fn gets a
+// context of its own bounded by the solver's timeout, so that the caller who
+// started it giving up does not fail the others waiting on it. Each caller
+// still stops waiting when its own context ends.
+func (c *Solver) shared(ctx context.Context, key string, fn func(context.Context) (any, error)) (any, error) {
+	results := c.flights.DoChan(key, func() (any, error) {
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), c.timeout())
+		defer cancel()
+		return fn(ctx)
+	})
+
+	select {
+	case r := <-results:
+		return r.Val, r.Err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
@@ -0,0 +1,197 @@
+package solver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/rackerlabs/cert-manager-webhook-rackspace/pkg/rackspacetest"
+)
+
+// gate holds the first request matching method and path until the returned
+// function is called, so that concurrent callers pile up behind it.
+func gate(t *testing.T, srv *rackspacetest.Server, method string, match func(path string) bool) func() {
+	t.Helper()
+
+	open := make(chan struct{})
+	release := sync.OnceFunc(func() { close(open) })
+	t.Cleanup(release)
+
+	var first atomic.Bool
+	srv.AddHook(func(w http.ResponseWriter, r *http.Request) bool {
+		if r.Method == method && match(r.URL.Path) && first.CompareAndSwap(false, true) {
+			<-open
+		}
+		return false
+	})
+
+	return release
+}
+
+// together calls fn from n goroutines and returns their errors once all have
+// returned. The gates are released after the callers had time to join.
+func together(n int, fn func(i int) error, gates ...func()) []error {
+	errs := make([]error, n)
+	var wg sync.WaitGroup
+	for i := range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = fn(i)
+		}()
+	}
+
+	for _, release := range gates {
+		time.Sleep(50 * time.Millisecond)
+		release()
+	}
+	wg.Wait()
+
+	return errs
+}
+
+func TestCoalescePresent(t *testing.T) {
+	env := newTestEnv(t)
+	create := gate(t, env.srv, http.MethodPost, func(path string) bool { return strings.HasSuffix(path, "/records") })
+
+	errs := together(5, func(int) error {
+		return env.solver.Present(challenge("_acme-challenge.example.com.", "key"))
+	}, create)
+
+	for _, err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := env.srv.Requests()["POST /v1.0/{tenant}/domains/{domain}/records"]; got != 1 {
+		t.Errorf("got %v, want %v", got, 1)
+	}
+	if got, want := env.srv.TXTRecords("_acme-challenge.example.com"), []string{"key"}; !slices.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestCoalesceCleanUp(t *testing.T) {
+	env := newTestEnv(t)
+	ch := challenge("_acme-challenge.example.com.", "key")
+	if err := env.solver.Present(ch); err != nil {
+		t.Fatal(err)
+	}
+
+	remove := gate(t, env.srv, http.MethodDelete, func(path string) bool { return strings.Contains(path, "/records/") })
+
+	errs := together(3, func(int) error {
+		return env.solver.CleanUp(ch)
+	}, remove)
+
+	// without sharing, the calls after the first would not find the record
+	for _, err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := env.srv.Requests()["DELETE /v1.0/{tenant}/domains/{domain}/records/{record}"]; got != 1 {
+		t.Errorf("got %v, want %v", got, 1)
+	}
+	if got := env.srv.TXTRecords("_acme-challenge.example.com"); len(got) != 0 {
+		t.Errorf("expected none, got %v", got)
+	}
+}
+
+// TestCoalesceLoginsAndZones presents challenges for different names, which
+// run in parallel but share the login and the lookup of their zone.
+func TestCoalesceLoginsAndZones(t *testing.T) {
+	env := newTestEnv(t)
+	login := gate(t, env.srv, http.MethodPost, func(path string) bool { return strings.HasSuffix(path, "/tokens") })
+	zones := gate(t, env.srv, http.MethodGet, func(path string) bool { return strings.HasSuffix(path, "/domains") })
+
+	const n = 6
+	errs := together(n, func(i int) error {
+		return env.solver.Present(challenge(fmt.Sprintf("_acme-challenge.host%d.example.com.", i), "key"))
+	}, login, zones)
+
+	for _, err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	requests := env.srv.Requests()
+	if got := requests["POST /v2.0/tokens"]; got != 1 {
+		t.Errorf("got %v, want %v", got, 1)
+	}
+	if got := requests["GET /v1.0/{tenant}/domains"]; got != 1 {
+		t.Errorf("got %v, want %v", got, 1)
+	}
+	if got := requests["POST /v1.0/{tenant}/domains/{domain}/records"]; got != n {
+		t.Errorf("got %v, want %v", got, n)
+	}
+}
+
+func TestCoalesceSharesErrors(t *testing.T) {
+	env := newTestEnv(t)
+	login := gate(t, env.srv, http.MethodPost, func(path string) bool { return strings.HasSuffix(path, "/tokens") })
+	env.srv.AddFault(rackspacetest.Fault{Method: http.MethodPost, Path: "/v2.0/tokens", Status: http.StatusUnauthorized, Body: `{}`, Times: 1})
+
+	errs := together(3, func(int) error {
+		return env.solver.Present(challenge("_acme-challenge.example.com.", "key"))
+	}, login)
+
+	for _, err := range errs {
+		if err == nil || !strings.Contains(err.Error(), "unable to find domain ID") {
+			t.Errorf("expected error %q, got %v", "unable to find domain ID", err)
+		}
+	}
+	if got := env.srv.Requests()["POST /v2.0/tokens"]; got != 1 {
+		t.Errorf("got %v, want %v", got, 1)
+	}
+
+	// the failure is not remembered
+	if err := env.solver.Present(challenge("_acme-challenge.example.com.", "key")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCoalesceOutlivesFirstCaller(t *testing.T) {
+	env := newTestEnv(t)
+	login := gate(t, env.srv, http.MethodPost, func(path string) bool { return strings.HasSuffix(path, "/tokens") })
+
+	accounts, err := clientConfig(context.Background(), env.solver, challenge("_acme-challenge.example.com.", "key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the caller starting the login gives up while it is held
+	ctx, cancel := context.WithCancel(context.Background())
+	first := async(func() error {
+		_, err := env.solver.provider(ctx, accounts[0])
+		return err
+	})
+	time.Sleep(50 * time.Millisecond)
+
+	second := async(func() error {
+		_, err := env.solver.provider(context.Background(), accounts[0])
+		return err
+	})
+	time.Sleep(50 * time.Millisecond)
+
+	cancel()
+	if err := <-first; !errors.Is(err, context.Canceled) {
+		t.Errorf("expected %v, got %v", context.Canceled, err)
+	}
+
+	login()
+	if err := <-second; err != nil {
+		t.Fatal(err)
+	}
+	if got := env.srv.Requests()["POST /v2.0/tokens"]; got != 1 {
+		t.Errorf("logged in %d times, want once", got)
+	}
+}
@@ -0,0 +1,366 @@
+package solver
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cert-manager/cert-manager/pkg/acme/webhook/apis/acme/v1alpha1"
+	"github.com/gophercloud/gophercloud/v2"
+	corev1 "k8s.io/api/core/v1"
+	extapi "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/util/retry"
+	"k8s.io/klog/v2"
+)
+
+// DefaultIntentConfigMap holds the cleanup intents when
+// Solver.IntentConfigMap is empty.
+const DefaultIntentConfigMap = DefaultUserAgent + "-intents"
+
+// challengesResource is the cert-manager Challenge resource, looked up to
+// tell whether an intent is still needed.
+var challengesResource = schema.GroupVersionResource{Group: "acme.cert-manager.io", Version: "v1", Resource: "challenges"}
+
+// intent is a presented record that has not been cleaned up yet. It holds
+// everything needed to delete the record without the challenge.
+type intent struct {
+	// Namespace is the challenge's namespace.
+	Namespace string `json:"namespace"`
+	Provider  string `json:"provider"`
+	// SecretRef is the `namespace/name` of the account's credentials.
+	SecretRef string `json:"secretRef"`
+	Zone      string `json:"zone"`
+	DomainID  string `json:"domainID"`
+	RecordID  string `json:"recordID"`
+	FQDN      string `json:"fqdn"`
+	Key       string `json:"key"`
+	// Config is the solver config of the challenge, which Designate accounts
+	// need to log in again.
+	Config  json.RawMessage `json:"config,omitempty"`
+	Created time.Time       `json:"created"`
+}
+
+// intentKey names the ConfigMap entry of a challenge's record.
+func intentKey(fqdn string, key string) string {
+	sum := sha256.Sum256([]byte(normalizeName(fqdn) + "|" + key))
+	return hex.EncodeToString(sum[:20])
+}
+
+// intentStore keeps the intents as entries of a single ConfigMap. Replicas
+// sharing it are kept apart by its resource version, mu only spares this one
+// the conflicts.
+type intentStore struct {
+	mu sync.Mutex
+}
+
+func (c *Solver) intentConfigMap() string {
+	if c.IntentConfigMap != "" {
+		return c.IntentConfigMap
+	}
+	return DefaultIntentConfigMap
+}
+
+// updateIntents applies fn to the entries of the ConfigMap, creating it when
+// missing, and retries when another replica updated it meanwhile.
+func (c *Solver) updateIntents(ctx context.Context, fn func(data map[string]string)) error {
+	c.intents.mu.Lock()
+	defer c.intents.mu.Unlock()
+
+	configMaps := c.Client.CoreV1().ConfigMaps(c.IntentNamespace)
+
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		cm, err := configMaps.Get(ctx, c.intentConfigMap(), metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			cm = &corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:   c.intentConfigMap(),
+					Labels: map[string]string{"app.kubernetes.io/managed-by": DefaultUserAgent},
+				},
+				Data: make(map[string]string),
+			}
+			fn(cm.Data)
+			_, err = configMaps.Create(ctx, cm, metav1.CreateOptions{})
+			if apierrors.IsAlreadyExists(err) {
+				// another replica created it first, retry as an update
+				return apierrors.NewConflict(corev1.Resource("configmaps"), cm.Name, err)
+			}
+			return err
+		}
+		if err != nil {
+			return err
+		}
+
+		if cm.Data == nil {
+			cm.Data = make(map[string]string)
+		}
+		fn(cm.Data)
+		_, err = configMaps.Update(ctx, cm, metav1.UpdateOptions{})
+		return err
+	})
+}
+
+// recordIntent stores the intent to clean up a presented record. It does
+// nothing unless Solver.IntentNamespace is set.
+func (c *Solver) recordIntent(ctx context.Context, ch *v1alpha1.ChallengeRequest, account Account, domainID string, recordID string) {
+	if c.IntentNamespace == "" {
+		return
+	}
+
+	in := intent{
+		Namespace: ch.ResourceNamespace,
+		Provider:  account.Provider,
+		SecretRef: account.SecretRef,
+		Zone:      normalizeName(ch.ResolvedZone),
+		DomainID:  domainID,
+		RecordID:  recordID,
+		FQDN:      normalizeName(ch.ResolvedFQDN),
+		Key:       ch.Key,
+		Created:   time.Now().UTC().Truncate(time.Second),
+	}
+	if ch.Config != nil {
+		in.Config = ch.Config.Raw
+	}
+
+	value, err := json.Marshal(in)
+	if err == nil {
+		err = c.updateIntents(ctx, func(data map[string]string) {
+			data[intentKey(in.FQDN, in.Key)] = string(value)
+		})
+	}
+	if err != nil {
+		// the record is there, failing would only make cert-manager present it again
+		klog.Warningf("Unable to record cleanup intent for %s: %v", ch.ResolvedFQDN, err)
+	}
+}
+
+// forgetIntent drops the intent of a record that was cleaned up.
+func (c *Solver) forgetIntent(ctx context.Context, ch *v1alpha1.ChallengeRequest) {
+	if c.IntentNamespace == "" {
+		return
+	}
+
+	key := intentKey(ch.ResolvedFQDN, ch.Key)
+	err := c.updateIntents(ctx, func(data map[string]string) {
+		delete(data, key)
+	})
+	if err != nil {
+		// reconciling drops it once the challenge is gone
+		klog.Warningf("Unable to drop cleanup intent for %s: %v", ch.ResolvedFQDN, err)
+	}
+}
+
+// listIntents returns the stored intents by their ConfigMap key.
+func (c *Solver) listIntents(ctx context.Context) (map[string]intent, error) {
+	cm, err := c.Client.CoreV1().ConfigMaps(c.IntentNamespace).Get(ctx, c.intentConfigMap(), metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to get configmap `%s/%s`: %w", c.IntentNamespace, c.intentConfigMap(), err)
+	}
+
+	intents := make(map[string]intent, len(cm.Data))
+	for key, value := range cm.Data {
+		var in intent
+		if err := json.Unmarshal([]byte(value), &in); err != nil {
+			klog.Warningf("Ignoring invalid cleanup intent %s: %v", key, err)
+			continue
+		}
+		intents[key] = in
+	}
+	return intents, nil
+}
+
+// storedIntent looks up the intent of a challenge's record.
+func (c *Solver) storedIntent(ctx context.Context, ch *v1alpha1.ChallengeRequest) (intent, bool) {
+	if c.IntentNamespace == "" {
+		return intent{}, false
+	}
+
+	cm, err := c.Client.CoreV1().ConfigMaps(c.IntentNamespace).Get(ctx, c.intentConfigMap(), metav1.GetOptions{})
+	if err != nil {
+		if !apierrors.IsNotFound(err) {
+			// the record is searched for instead
+			klog.Warningf("Unable to look up cleanup intent for %s: %v", ch.ResolvedFQDN, err)
+		}
+		return intent{}, false
+	}
+
+	value, ok := cm.Data[intentKey(ch.ResolvedFQDN, ch.Key)]
+	if !ok {
+		return intent{}, false
+	}
+
+	var in intent
+	if err := json.Unmarshal([]byte(value), &in); err != nil {
+		klog.Warningf("Ignoring invalid cleanup intent for %s: %v", ch.ResolvedFQDN, err)
+		return intent{}, false
+	}
+	return in, true
+}
+
+// intentsLeaseName names the Lease held by the replica that reconciles the
+// cleanup intents.
+const intentsLeaseName = DefaultUserAgent + "-intents"
+
+// reconcileIntentsElected runs ReconcileIntents unless another replica is
+// already at it, as told by a Lease in IntentNamespace, so that replicas
+// starting together do not clean up the same records. Initialize runs it on
+// startup.
+func (c *Solver) reconcileIntentsElected(ctx context.Context) {
+	if c.IntentNamespace == "" || c.DynamicClient == nil {
+		return
+	}
+
+	l := &leaseLock{
+		client:   c.Client.CoordinationV1().Leases(c.IntentNamespace),
+		name:     intentsLeaseName,
+		holder:   c.leaseHolder(),
+		duration: c.leaseDuration(),
+	}
+	lease, holder, err := l.tryAcquire(ctx)
+	if err != nil {
+		klog.Warningf("Unable to acquire lease %s: %v", l.name, err)
+		return
+	}
+	if lease == nil {
+		klog.Infof("Leaving the reconcile of cleanup intents to %s", holder)
+		return
+	}
+	release := l.keep(lease)
+	defer release()
+
+	if err := c.ReconcileIntents(ctx); err != nil {
+		klog.Warningf("Unable to reconcile cleanup intents: %v", err)
+	}
+}
+
+// ReconcileIntents deletes the records of stored intents whose Challenge no
+// longer exists, as left behind by a replica that died between Present and
+// CleanUp or by CleanUps that kept failing. Initialize runs it on startup
+// through reconcileIntentsElected.
+func (c *Solver) ReconcileIntents(ctx context.Context) error {
+	if c.IntentNamespace == "" || c.DynamicClient == nil {
+		return nil
+	}
+
+	intents, err := c.listIntents(ctx)
+	if err != nil {
+		return err
+	}
+
+	pending := make(map[string]map[string]bool) // keys of existing challenges by namespace
+	var errs []error
+	var cleaned []string
+	for _, key := range sortedKeys(intents) {
+		in := intents[key]
+
+		keys, ok := pending[in.Namespace]
+		if !ok {
+			keys, err = c.challengeKeys(ctx, in.Namespace)
+			if err != nil {
+				// nothing is deleted while unsure whether it is still needed
+				errs = append(errs, err)
+				keys = nil
+			}
+			pending[in.Namespace] = keys
+		}
+		if keys == nil || keys[in.Key] {
+			continue
+		}
+
+		if err := c.deleteIntentRecord(ctx, in); err != nil {
+			errs = append(errs, fmt.Errorf("unable to clean up orphaned record for `%s`: %w", in.FQDN, err))
+			continue
+		}
+
+		err := c.updateIntents(ctx, func(data map[string]string) {
+			delete(data, key)
+		})
+		if err != nil {
+			errs = append(errs, fmt.Errorf("unable to drop cleanup intent for `%s`: %w", in.FQDN, err))
+			continue
+		}
+		c.forgetRecord(&v1alpha1.ChallengeRequest{ResolvedFQDN: in.FQDN, Key: in.Key})
+		cleaned = append(cleaned, in.FQDN)
+	}
+
+	if len(cleaned) > 0 {
+		klog.Infof("Cleaned up %d orphaned records: %s", len(cleaned), strings.Join(cleaned, ", "))
+	}
+
+	return errors.Join(errs...)
+}
+
+// challengeKeys returns the keys of the Challenges in a namespace.
+func (c *Solver) challengeKeys(ctx context.Context, namespace string) (map[string]bool, error) {
+	list, err := c.DynamicClient.Resource(challengesResource).Namespace(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("unable to list challenges in `%s`: %w", namespace, err)
+	}
+
+	keys := make(map[string]bool, len(list.Items))
+	for _, item := range list.Items {
+		spec, _ := item.Object["spec"].(map[string]any)
+		if key, ok := spec["key"].(string); ok {
+			keys[key] = true
+		}
+	}
+	return keys, nil
+}
+
+// deleteIntentRecord logs in to the intent's account again and deletes its
+// record by the stored IDs. A record that is already gone counts as deleted.
+func (c *Solver) deleteIntentRecord(ctx context.Context, in intent) error {
+	var raw *extapi.JSON
+	if in.Config != nil {
+		raw = &extapi.JSON{Raw: in.Config}
+	}
+	cfg, err := loadConfig(raw)
+	if err != nil {
+		return err
+	}
+
+	namespace, secretName, ok := strings.Cut(in.SecretRef, "/")
+	if !ok {
+		return fmt.Errorf("invalid secret reference `%s`", in.SecretRef)
+	}
+
+	account, err := secretConfig(ctx, c, namespace, secretName, in.Provider, cfg)
+	if err != nil {
+		return err
+	}
+	account.Rackspace.DomainName = cfg.DomainName
+
+	provider, err := c.provider(ctx, account)
+	if err != nil {
+		return fmt.Errorf("unable to authenticate to %s: %w", account.Provider, err)
+	}
+
+	err = provider.DeleteRecord(ctx, in.DomainID, in.RecordID)
+	if gophercloud.ResponseCodeIs(err, http.StatusNotFound) {
+		klog.Infof("Orphaned txt record %v is already gone", in.FQDN)
+		return nil
+	}
+	return err
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
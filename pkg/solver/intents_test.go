@@ -0,0 +1,327 @@
+package solver
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	k8stesting "k8s.io/client-go/testing"
+
+	"github.com/rackerlabs/cert-manager-webhook-rackspace/pkg/designatetest"
+)
+
+const intentNamespace = "cert-manager"
+
+// challengeObject is a cert-manager Challenge for key.
+func challengeObject(name string, key string) *unstructured.Unstructured {
+	return &unstructured.Unstructured{Object: map[string]any{
+		"apiVersion": "acme.cert-manager.io/v1",
+		"kind":       "Challenge",
+		"metadata":   map[string]any{"name": name, "namespace": testNamespace},
+		"spec":       map[string]any{"key": key},
+	}}
+}
+
+func challengeClient(challenges ...runtime.Object) *dynamicfake.FakeDynamicClient {
+	return dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{challengesResource: "ChallengeList"}, challenges...)
+}
+
+// restarted returns a solver sharing the Kubernetes API and DNS backend of
+// env's without any of its memory, as the pod replacing a crashed one.
+func restarted(env *testEnv, challenges ...runtime.Object) *Solver {
+	return &Solver{
+		UserAgent:        env.solver.UserAgent,
+		IdentityEndpoint: env.solver.IdentityEndpoint,
+		Client:           env.solver.Client,
+		IntentNamespace:  intentNamespace,
+		DynamicClient:    challengeClient(challenges...),
+	}
+}
+
+func intents(t *testing.T, s *Solver) map[string]intent {
+	t.Helper()
+
+	stored, err := s.listIntents(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return stored
+}
+
+func TestIntents(t *testing.T) {
+	env := newTestEnv(t)
+	env.solver.IntentNamespace = intentNamespace
+
+	ch := challenge("_acme-challenge.example.com.", "key")
+	if err := env.solver.Present(ch); err != nil {
+		t.Fatal(err)
+	}
+
+	records := env.srv.Records(env.domID)
+	if len(records) != 1 {
+		t.Fatalf("expected 1, got %v", records)
+	}
+
+	stored := intents(t, env.solver)
+	if len(stored) != 1 {
+		t.Fatalf("expected 1, got %v", stored)
+	}
+	in := stored[intentKey(ch.ResolvedFQDN, ch.Key)]
+	if in.Namespace != testNamespace {
+		t.Errorf("in.Namespace = %v, want %v", in.Namespace, testNamespace)
+	}
+	if in.Provider != ProviderRackspace {
+		t.Errorf("in.Provider = %v, want %v", in.Provider, ProviderRackspace)
+	}
+	if in.SecretRef != testNamespace+"/creds" {
+		t.Errorf("in.SecretRef = %v, want %v", in.SecretRef, testNamespace+"/creds")
+	}
+	if in.Zone != "example.com" {
+		t.Errorf("in.Zone = %v, want %v", in.Zone, "example.com")
+	}
+	if in.DomainID != env.domID {
+		t.Errorf("in.DomainID = %v, want %v", in.DomainID, env.domID)
+	}
+	if in.RecordID != records[0].ID {
+		t.Errorf("in.RecordID = %v, want %v", in.RecordID, records[0].ID)
+	}
+	if in.FQDN != "_acme-challenge.example.com" {
+		t.Errorf("in.FQDN = %v, want %v", in.FQDN, "_acme-challenge.example.com")
+	}
+	if in.Key != "key" {
+		t.Errorf("in.Key = %v, want %v", in.Key, "key")
+	}
+	if d := time.Since(in.Created); d < 0 || d > time.Minute {
+		t.Errorf("created at %s", in.Created)
+	}
+
+	if err := env.solver.CleanUp(ch); err != nil {
+		t.Fatal(err)
+	}
+	if got := intents(t, env.solver); len(got) != 0 {
+		t.Errorf("expected none, got %v", got)
+	}
+}
+
+// TestReconcileIntents restarts the webhook after a crash left two records
+// behind. The challenge of one of them is still in progress.
+func TestReconcileIntents(t *testing.T) {
+	env := newTestEnv(t)
+	env.solver.IntentNamespace = intentNamespace
+
+	orphan := challenge("_acme-challenge.example.com.", "orphan")
+	live := challenge("_acme-challenge.www.example.com.", "live")
+	if err := env.solver.Present(orphan); err != nil {
+		t.Fatal(err)
+	}
+	if err := env.solver.Present(live); err != nil {
+		t.Fatal(err)
+	}
+
+	s := restarted(env, challengeObject("www", "live"))
+	if err := s.ReconcileIntents(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := env.srv.TXTRecords("_acme-challenge.example.com"); len(got) != 0 {
+		t.Errorf("expected none, got %v", got)
+	}
+	if got, want := env.srv.TXTRecords("_acme-challenge.www.example.com"), []string{"live"}; !slices.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	stored := intents(t, s)
+	if len(stored) != 1 {
+		t.Errorf("expected 1, got %v", stored)
+	}
+	if _, ok := stored[intentKey(live.ResolvedFQDN, live.Key)]; !ok {
+		t.Errorf("expected the live intent, got %v", stored)
+	}
+
+	// the live challenge is still cleaned up as usual
+	if err := s.CleanUp(live); err != nil {
+		t.Fatal(err)
+	}
+	if got := intents(t, s); len(got) != 0 {
+		t.Errorf("expected none, got %v", got)
+	}
+}
+
+// TestReconcileIntentsElected leaves the reconcile to the replica holding
+// the Lease, and takes it over once that one is done.
+func TestReconcileIntentsElected(t *testing.T) {
+	env := newTestEnv(t)
+	env.solver.IntentNamespace = intentNamespace
+
+	if err := env.solver.Present(challenge("_acme-challenge.example.com.", "orphan")); err != nil {
+		t.Fatal(err)
+	}
+
+	heldLease(t, env, intentsLeaseName, "pod-b", time.Now())
+
+	s := restarted(env)
+	s.LeaseHolder = "pod-a"
+	s.reconcileIntentsElected(context.Background())
+	if got, want := env.srv.TXTRecords("_acme-challenge.example.com"), []string{"orphan"}; !slices.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	err := env.solver.Client.CoordinationV1().Leases(intentNamespace).Delete(context.Background(), intentsLeaseName, metav1.DeleteOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s.reconcileIntentsElected(context.Background())
+	if got := env.srv.TXTRecords("_acme-challenge.example.com"); len(got) != 0 {
+		t.Errorf("expected none, got %v", got)
+	}
+	if got := intents(t, s); len(got) != 0 {
+		t.Errorf("expected none, got %v", got)
+	}
+	if got := leases(t, env); len(got) != 0 {
+		t.Errorf("the lease is released: expected none, got %v", got)
+	}
+}
+
+func TestReconcileIntentsForgetsRecords(t *testing.T) {
+	env := newTestEnv(t)
+	env.solver.IntentNamespace = intentNamespace
+	env.solver.DynamicClient = challengeClient()
+
+	if err := env.solver.Present(challenge("_acme-challenge.example.com.", "orphan")); err != nil {
+		t.Fatal(err)
+	}
+
+	// the challenge was deleted without cleaning it up
+	if err := env.solver.ReconcileIntents(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got := presentedRecords(env.solver); len(got) != 0 {
+		t.Errorf("remembers %v after reconciling", got)
+	}
+}
+
+func TestReconcileIntentsRecordGone(t *testing.T) {
+	env := newTestEnv(t)
+	env.solver.IntentNamespace = intentNamespace
+	if err := env.solver.Present(challenge("_acme-challenge.example.com.", "orphan")); err != nil {
+		t.Fatal(err)
+	}
+
+	// someone deleted the orphaned record already
+	records := env.srv.Records(env.domID)
+	if len(records) != 1 {
+		t.Fatalf("expected 1, got %v", records)
+	}
+	if err := env.provider(t).DeleteRecord(context.Background(), env.domID, records[0].ID); err != nil {
+		t.Fatal(err)
+	}
+
+	s := restarted(env)
+	if err := s.ReconcileIntents(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got := intents(t, s); len(got) != 0 {
+		t.Errorf("intents of records that are gone are dropped: expected none, got %v", got)
+	}
+}
+
+func TestReconcileIntentsUnsure(t *testing.T) {
+	env := newTestEnv(t)
+	env.solver.IntentNamespace = intentNamespace
+	if err := env.solver.Present(challenge("_acme-challenge.example.com.", "key")); err != nil {
+		t.Fatal(err)
+	}
+
+	s := restarted(env)
+	s.DynamicClient.(*dynamicfake.FakeDynamicClient).PrependReactor("list", "challenges",
+		func(k8stesting.Action) (bool, runtime.Object, error) {
+			return true, nil, apierrors.NewForbidden(challengesResource.GroupResource(), "", errors.New("no access"))
+		})
+
+	err := s.ReconcileIntents(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "unable to list challenges in `default`") {
+		t.Errorf("expected error %q, got %v", "unable to list challenges in `default`", err)
+	}
+
+	if got, want := env.srv.TXTRecords("_acme-challenge.example.com"), []string{"key"}; !slices.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got := intents(t, s); len(got) != 1 {
+		t.Errorf("expected 1, got %v", got)
+	}
+}
+
+func TestReconcileDesignateIntents(t *testing.T) {
+	openstack := designatetest.NewServer()
+	defer openstack.Close()
+
+	project := openstack.AddProject("dns")
+	openstack.AddUser(project, "os-user", "os-password")
+	openstack.AddZone(project, "example.com")
+
+	env := newTestEnv(t, credsSecret("openstack", map[string]string{
+		"auth-url": openstack.AuthURL(),
+		"username": "os-user",
+		"password": "os-password",
+	}))
+	env.solver.IntentNamespace = intentNamespace
+
+	// the project comes from the solver config, which is stored with the intent
+	ch := challengeWithConfig("_acme-challenge.example.com.", "key",
+		`{"provider":"designate","authSecretRef":"openstack","scope":{"projectName":"dns"}}`)
+	if err := env.solver.Present(ch); err != nil {
+		t.Fatal(err)
+	}
+	if err := env.solver.Present(challengeWithConfig("_acme-challenge.example.com.", "other", string(ch.Config.Raw))); err != nil {
+		t.Fatal(err)
+	}
+
+	s := restarted(env, challengeObject("other", "other"))
+	if err := s.ReconcileIntents(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := openstack.TXTRecords("_acme-challenge.example.com"), []string{"other"}; !slices.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestIntentConfigMap(t *testing.T) {
+	env := newTestEnv(t)
+	env.solver.IntentNamespace = intentNamespace
+	env.solver.IntentConfigMap = "my-intents"
+
+	if err := env.solver.Present(challenge("_acme-challenge.example.com.", "key")); err != nil {
+		t.Fatal(err)
+	}
+
+	cm, err := env.solver.Client.CoreV1().ConfigMaps(intentNamespace).Get(context.Background(), "my-intents", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cm.Data) != 1 {
+		t.Fatalf("expected 1, got %v", cm.Data)
+	}
+
+	for _, value := range cm.Data {
+		var in intent
+		if err := json.Unmarshal([]byte(value), &in); err != nil {
+			t.Fatal(err)
+		}
+		if in.Key != "key" {
+			t.Errorf("in.Key = %v, want %v", in.Key, "key")
+		}
+	}
+}
@@ -0,0 +1,271 @@
+package solver
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"time"
+
+	coordinationv1 "k8s.io/api/coordination/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	coordinationclient "k8s.io/client-go/kubernetes/typed/coordination/v1"
+	"k8s.io/klog/v2"
+)
+
+// DefaultLeaseDuration is how long a Lease outlives a replica that stopped
+// renewing it when Solver.LeaseDuration is zero.
+const DefaultLeaseDuration = 15 * time.Second
+
+// leaseRetryInterval is how often a replica checks whether a Lease held by
+// another one has been released or has expired.
+const leaseRetryInterval = 250 * time.Millisecond
+
+// leaseReleaseTimeout bounds deleting a Lease, which happens after the
+// operation's own timeout may have passed.
+const leaseReleaseTimeout = 5 * time.Second
+
+// leaseFQDNAnnotation records the name a Lease guards, for humans only.
+const leaseFQDNAnnotation = "cert-manager-webhook-rackspace/fqdn"
+
+// leaseName names the Lease guarding changes to fqdn in the account. Both are
+// hashed, as Lease names are limited in length and account identities are
+// not for everyone who can list Leases.
+func leaseName(account Account, fqdn string) string {
+	sum := sha256.Sum256([]byte(accountIdentity(account) + "|" + normalizeName(fqdn)))
+	return "acme-" + hex.EncodeToString(sum[:20])
+}
+
+// accountIdentity names the account behind an Account, which is the same for
+// every Secret holding its credentials.
+func accountIdentity(account Account) string {
+	switch account.Provider {
+	case ProviderDesignate:
+		// a name lives in a single zone of the cloud, whichever user changes it
+		return account.Provider + "|" + account.Designate.AuthOptions.IdentityEndpoint
+	default:
+		return account.Provider + "|" + account.Rackspace.AuthOptions.Username
+	}
+}
+
+// lockLease waits until this replica holds the Lease guarding fqdn in the
+// account or ctx ends, renewing it until the returned function releases it.
+// It does nothing unless Solver.LeaseNamespace is set.
+func (c *Solver) lockLease(ctx context.Context, account Account, fqdn string) (func(), error) {
+	if c.LeaseNamespace == "" {
+		return func() {}, nil
+	}
+
+	l := &leaseLock{
+		client:   c.Client.CoordinationV1().Leases(c.LeaseNamespace),
+		name:     leaseName(account, fqdn),
+		fqdn:     normalizeName(fqdn),
+		holder:   c.leaseHolder(),
+		duration: c.leaseDuration(),
+	}
+
+	lease, err := l.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return l.keep(lease), nil
+}
+
+func (c *Solver) leaseHolder() string {
+	if c.LeaseHolder != "" {
+		return c.LeaseHolder
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		return DefaultUserAgent
+	}
+	return hostname
+}
+
+func (c *Solver) leaseDuration() time.Duration {
+	if c.LeaseDuration > 0 {
+		return c.LeaseDuration
+	}
+	return DefaultLeaseDuration
+}
+
+// leaseLock is a single Lease held around changes to one name, or to elect
+// the replica that sweeps.
+type leaseLock struct {
+	client   coordinationclient.LeaseInterface
+	name     string
+	fqdn     string // empty unless guarding a name
+	holder   string
+	duration time.Duration
+}
+
+// acquire polls the Lease until it can be taken.
+func (l *leaseLock) acquire(ctx context.Context) (*coordinationv1.Lease, error) {
+	for {
+		lease, holder, err := l.tryAcquire(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("unable to acquire lease `%s` for `%s`: %w", l.name, l.fqdn, err)
+		}
+		if lease != nil {
+			klog.V(4).Infof("Acquired lease %s for %s", l.name, l.fqdn)
+			return lease, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("lease `%s` for `%s` is held by `%s`: %w", l.name, l.fqdn, holder, ctx.Err())
+		case <-time.After(leaseRetryInterval):
+		}
+	}
+}
+
+// tryAcquire creates the Lease or takes it over once its holder released it
+// or stopped renewing it. It returns the current holder when it cannot.
+func (l *leaseLock) tryAcquire(ctx context.Context) (*coordinationv1.Lease, string, error) {
+	now := metav1.NowMicro()
+
+	lease, err := l.client.Get(ctx, l.name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		lease = &coordinationv1.Lease{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:   l.name,
+				Labels: map[string]string{"app.kubernetes.io/managed-by": DefaultUserAgent},
+			},
+		}
+		if l.fqdn != "" {
+			lease.Annotations = map[string]string{leaseFQDNAnnotation: l.fqdn}
+		}
+		l.hold(lease, now)
+
+		created, err := l.client.Create(ctx, lease, metav1.CreateOptions{})
+		if apierrors.IsAlreadyExists(err) {
+			return nil, "another replica", nil
+		}
+		return created, "", err
+	}
+	if err != nil {
+		return nil, "", err
+	}
+
+	if holder := lease.Spec.HolderIdentity; holder != nil && *holder != "" && !expired(lease, now.Time) {
+		return nil, *holder, nil
+	}
+
+	// the resource version makes this fail when another replica was faster
+	l.hold(lease, now)
+	updated, err := l.client.Update(ctx, lease, metav1.UpdateOptions{})
+	if apierrors.IsConflict(err) {
+		return nil, "another replica", nil
+	}
+	return updated, "", err
+}
+
+func (l *leaseLock) hold(lease *coordinationv1.Lease, now metav1.MicroTime) {
+	holder := l.holder
+	seconds := int32((l.duration + time.Second - 1) / time.Second)
+
+	lease.Spec.HolderIdentity = &holder
+	lease.Spec.LeaseDurationSeconds = &seconds
+	lease.Spec.AcquireTime = &now
+	lease.Spec.RenewTime = &now
+}
+
+// expired reports whether the holder of the Lease stopped renewing it.
+func expired(lease *coordinationv1.Lease, now time.Time) bool {
+	renewed := lease.Spec.RenewTime
+	if renewed == nil {
+		renewed = lease.Spec.AcquireTime
+	}
+	if renewed == nil || lease.Spec.LeaseDurationSeconds == nil {
+		return true
+	}
+	return now.After(renewed.Add(time.Duration(*lease.Spec.LeaseDurationSeconds) * time.Second))
+}
+
+// keep renews the Lease until the returned function releases it.
+func (l *leaseLock) keep(lease *coordinationv1.Lease) func() {
+	stop := make(chan struct{})
+	renewed := make(chan *coordinationv1.Lease)
+	go l.renew(lease, stop, renewed)
+
+	return func() {
+		close(stop)
+		l.release(<-renewed)
+	}
+}
+
+// renew keeps the Lease from expiring until stop is closed, then hands back
+// its latest version.
+func (l *leaseLock) renew(lease *coordinationv1.Lease, stop <-chan struct{}, renewed chan<- *coordinationv1.Lease) {
+	ticker := time.NewTicker(l.duration / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			renewed <- lease
+			return
+		case <-ticker.C:
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), l.duration/3)
+		next := lease.DeepCopy()
+		now := metav1.NowMicro()
+		next.Spec.RenewTime = &now
+		updated, err := l.client.Update(ctx, next, metav1.UpdateOptions{})
+		if apierrors.IsConflict(err) {
+			// the Lease was written meanwhile, carry on from its latest version
+			// while it is still this replica's
+			if current, getErr := l.client.Get(ctx, l.name, metav1.GetOptions{}); getErr == nil && l.holds(current) {
+				lease = current
+			}
+		}
+		cancel()
+		if err != nil {
+			// the operation carries on, another replica may take the name over
+			// once the Lease expired
+			klog.Warningf("Unable to renew lease %s for %s: %v", l.name, l.fqdn, err)
+			continue
+		}
+		lease = updated
+	}
+}
+
+// holds reports whether this replica is the holder of the Lease.
+func (l *leaseLock) holds(lease *coordinationv1.Lease) bool {
+	holder := lease.Spec.HolderIdentity
+	return holder != nil && *holder == l.holder
+}
+
+// release deletes the Lease unless another replica took it over meanwhile.
+func (l *leaseLock) release(lease *coordinationv1.Lease) {
+	ctx, cancel := context.WithTimeout(context.Background(), leaseReleaseTimeout)
+	defer cancel()
+
+	err := l.delete(ctx, lease)
+	if apierrors.IsConflict(err) {
+		// the version held is stale, the Lease is only deleted while the
+		// latest one is still this replica's
+		lease, err = l.client.Get(ctx, l.name, metav1.GetOptions{})
+		if err == nil {
+			if !l.holds(lease) {
+				klog.V(4).Infof("Lease %s for %s was taken over by %s", l.name, l.fqdn, *lease.Spec.HolderIdentity)
+				return
+			}
+			err = l.delete(ctx, lease)
+		}
+	}
+	if err != nil && !apierrors.IsNotFound(err) {
+		// it expires on its own
+		klog.Warningf("Unable to release lease %s for %s: %v", l.name, l.fqdn, err)
+	}
+}
+
+func (l *leaseLock) delete(ctx context.Context, lease *coordinationv1.Lease) error {
+	return l.client.Delete(ctx, l.name, metav1.DeleteOptions{
+		Preconditions: &metav1.Preconditions{UID: &lease.UID, ResourceVersion: &lease.ResourceVersion},
+	})
+}
@@ -0,0 +1,318 @@
+package solver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	coordinationv1 "k8s.io/api/coordination/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+
+	"github.com/rackerlabs/cert-manager-webhook-rackspace/pkg/designatetest"
+)
+
+const leaseNamespace = "cert-manager"
+
+// replica returns a solver sharing the Kubernetes API and DNS backend of
+// env's, as another pod of the same deployment would.
+func replica(env *testEnv, holder string) *Solver {
+	return &Solver{
+		UserAgent:        env.solver.UserAgent,
+		IdentityEndpoint: env.solver.IdentityEndpoint,
+		Client:           env.solver.Client,
+		LeaseNamespace:   leaseNamespace,
+		LeaseHolder:      holder,
+	}
+}
+
+func leases(t *testing.T, env *testEnv) []coordinationv1.Lease {
+	t.Helper()
+
+	list, err := env.solver.Client.CoordinationV1().Leases(leaseNamespace).List(context.Background(), metav1.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return list.Items
+}
+
+// heldLease stores a Lease as a replica that last renewed it at renewed.
+func heldLease(t *testing.T, env *testEnv, name string, holder string, renewed time.Time) {
+	t.Helper()
+
+	seconds := int32(DefaultLeaseDuration / time.Second)
+	at := metav1.NewMicroTime(renewed)
+	_, err := env.solver.Client.CoordinationV1().Leases(leaseNamespace).Create(context.Background(), &coordinationv1.Lease{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: leaseNamespace},
+		Spec: coordinationv1.LeaseSpec{
+			HolderIdentity:       &holder,
+			LeaseDurationSeconds: &seconds,
+			AcquireTime:          &at,
+			RenewTime:            &at,
+		},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestLeaseReplicas presents challenges for one Designate name through two
+// replicas at once. Their in-process locks do not see each other, so only the
+// Lease keeps them from overwriting each other's recordset.
+func TestLeaseReplicas(t *testing.T) {
+	openstack := designatetest.NewServer()
+	defer openstack.Close()
+
+	project := openstack.AddProject("dns")
+	openstack.AddApplicationCredential(project, "app-cred", "app-secret")
+	openstack.AddZone(project, "example.com")
+	openstack.SetLatency(2 * time.Millisecond)
+
+	env := newTestEnv(t, []runtime.Object{credsSecret("openstack", map[string]string{
+		"auth-url":                      openstack.AuthURL(),
+		"application-credential-id":     "app-cred",
+		"application-credential-secret": "app-secret",
+	})}...)
+
+	const (
+		fqdn   = "_acme-challenge.example.com."
+		config = `{"provider":"designate","authSecretRef":"openstack"}`
+	)
+
+	replicas := []*Solver{replica(env, "pod-a"), replica(env, "pod-b")}
+
+	var want []string
+	errs := make(chan error, 8)
+	var wg sync.WaitGroup
+	for i := range 8 {
+		key := fmt.Sprintf("key-%d", i)
+		want = append(want, key)
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- replicas[i%2].Present(challengeWithConfig(fqdn, key, config))
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := openstack.TXTRecords(fqdn); !sameElements(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got := leases(t, env); len(got) != 0 {
+		t.Errorf("leases are deleted when released: expected none, got %v", got)
+	}
+
+	for i, key := range want {
+		if err := replicas[(i+1)%2].CleanUp(challengeWithConfig(fqdn, key, config)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := openstack.TXTRecords(fqdn); len(got) != 0 {
+		t.Errorf("expected none, got %v", got)
+	}
+}
+
+func TestLeaseHeldByAnotherReplica(t *testing.T) {
+	env := newTestEnv(t)
+	s := replica(env, "pod-a")
+	s.Timeout = 500 * time.Millisecond
+
+	ch := challenge("_acme-challenge.example.com.", "key")
+	accounts, err := clientConfig(context.Background(), s, ch)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	heldLease(t, env, leaseName(accounts[0], ch.ResolvedFQDN), "pod-b", time.Now())
+
+	err = s.Present(ch)
+	if err == nil || !strings.Contains(err.Error(), "is held by `pod-b`") {
+		t.Errorf("expected error %q, got %v", "is held by `pod-b`", err)
+	}
+	if got := env.srv.TXTRecords("_acme-challenge.example.com"); len(got) != 0 {
+		t.Errorf("expected none, got %v", got)
+	}
+}
+
+func TestLeaseOfCrashedReplica(t *testing.T) {
+	env := newTestEnv(t)
+	s := replica(env, "pod-a")
+
+	ch := challenge("_acme-challenge.example.com.", "key")
+	accounts, err := clientConfig(context.Background(), s, ch)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// pod-b stopped renewing it well over a lease duration ago
+	heldLease(t, env, leaseName(accounts[0], ch.ResolvedFQDN), "pod-b", time.Now().Add(-time.Minute))
+
+	if err := s.Present(ch); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := env.srv.TXTRecords("_acme-challenge.example.com"), []string{"key"}; !slices.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got := leases(t, env); len(got) != 0 {
+		t.Errorf("expected none, got %v", got)
+	}
+}
+
+func TestLeaseRenewal(t *testing.T) {
+	env := newTestEnv(t)
+	s := replica(env, "pod-a")
+	s.LeaseDuration = time.Second
+
+	account := Account{Provider: ProviderRackspace}
+	account.Rackspace.AuthOptions.Username = "user"
+
+	unlock, err := s.lockLease(context.Background(), account, "_acme-challenge.example.com.")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// held for longer than its duration, the lease is still pod-a's
+	time.Sleep(1500 * time.Millisecond)
+
+	other := &leaseLock{
+		client:   env.solver.Client.CoordinationV1().Leases(leaseNamespace),
+		name:     leaseName(account, "_acme-challenge.example.com."),
+		holder:   "pod-b",
+		duration: time.Second,
+	}
+	lease, holder, err := other.tryAcquire(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lease != nil {
+		t.Errorf("expected nil, got %v", lease)
+	}
+	if holder != "pod-a" {
+		t.Errorf("holder = %v, want %v", holder, "pod-a")
+	}
+
+	unlock()
+	if got := leases(t, env); len(got) != 0 {
+		t.Errorf("expected none, got %v", got)
+	}
+}
+
+// enforceLeaseVersions makes the fake clientset bump the resource version of
+// Leases on every update and refuse updates and deletions made with a stale
+// one, as the API server does.
+func enforceLeaseVersions(t *testing.T, env *testEnv) {
+	t.Helper()
+
+	client := env.solver.Client.(*fake.Clientset)
+	gvr := coordinationv1.SchemeGroupVersion.WithResource("leases")
+
+	stored := func(ns string, name string) (*coordinationv1.Lease, error) {
+		obj, err := client.Tracker().Get(gvr, ns, name)
+		if err != nil {
+			return nil, err
+		}
+		return obj.(*coordinationv1.Lease), nil
+	}
+
+	client.PrependReactor("update", "leases", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		lease := action.(k8stesting.UpdateAction).GetObject().(*coordinationv1.Lease).DeepCopy()
+		current, err := stored(action.GetNamespace(), lease.Name)
+		if err != nil {
+			return true, nil, err
+		}
+		if current.ResourceVersion != lease.ResourceVersion {
+			return true, nil, apierrors.NewConflict(gvr.GroupResource(), lease.Name, errors.New("stale resource version"))
+		}
+		lease.ResourceVersion = nextVersion(current.ResourceVersion)
+		return true, lease, client.Tracker().Update(gvr, lease, action.GetNamespace())
+	})
+
+	client.PrependReactor("delete", "leases", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		del := action.(k8stesting.DeleteActionImpl)
+		current, err := stored(del.GetNamespace(), del.GetName())
+		if err != nil {
+			return true, nil, err
+		}
+		if pre := del.DeleteOptions.Preconditions; pre != nil && pre.ResourceVersion != nil && *pre.ResourceVersion != current.ResourceVersion {
+			return true, nil, apierrors.NewConflict(gvr.GroupResource(), del.GetName(), errors.New("stale resource version"))
+		}
+		return true, nil, client.Tracker().Delete(gvr, del.GetNamespace(), del.GetName())
+	})
+}
+
+func nextVersion(version string) string {
+	n, _ := strconv.Atoi(version)
+	return strconv.Itoa(n + 1)
+}
+
+// TestLeaseRenewalConflict writes the Lease behind the holder's back, which
+// then renews and releases it from its latest version.
+func TestLeaseRenewalConflict(t *testing.T) {
+	env := newTestEnv(t)
+	enforceLeaseVersions(t, env)
+	s := replica(env, "pod-a")
+	s.LeaseDuration = 300 * time.Millisecond
+
+	account := Account{Provider: ProviderRackspace}
+	account.Rackspace.AuthOptions.Username = "user"
+
+	unlock, err := s.lockLease(context.Background(), account, "_acme-challenge.example.com.")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := leases(t, env)
+	if len(got) != 1 {
+		t.Fatalf("expected 1, got %v", got)
+	}
+	annotated := got[0].DeepCopy()
+	annotated.Annotations["example.com/touched"] = "true"
+	if _, err := env.solver.Client.CoordinationV1().Leases(leaseNamespace).Update(context.Background(), annotated, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	// long enough for a conflicting renewal and a later one
+	time.Sleep(400 * time.Millisecond)
+
+	unlock()
+	if got := leases(t, env); len(got) != 0 {
+		t.Errorf("expected none, got %v", got)
+	}
+}
+
+func TestLeaseName(t *testing.T) {
+	a := Account{Provider: ProviderRackspace}
+	a.Rackspace.AuthOptions.Username = "user-a"
+	b := Account{Provider: ProviderRackspace}
+	b.Rackspace.AuthOptions.Username = "user-b"
+
+	name := leaseName(a, "_acme-challenge.example.com.")
+	if got := leaseName(a, "_ACME-challenge.example.com"); got != name {
+		t.Errorf("names are normalized: got %v, want %v", got, name)
+	}
+	if leaseName(b, "_acme-challenge.example.com.") == name {
+		t.Errorf("got %v", leaseName(b, "_acme-challenge.example.com."))
+	}
+	if strings.Contains(name, "user-a") {
+		t.Errorf("unexpected %q in %q", "user-a", name)
+	}
+	if len(name) > 63 {
+		t.Errorf("%q is longer than 63 characters", name)
+	}
+}
@@ -0,0 +1,91 @@
+package solver
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/cert-manager/cert-manager/pkg/acme/webhook/apis/acme/v1alpha1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/klog/v2"
+
+	"github.com/rackerlabs/cert-manager-webhook-rackspace/internal/loadtest"
+	"github.com/rackerlabs/cert-manager-webhook-rackspace/pkg/rackspacetest"
+)
+
+// newLoadEnv is a solver using the fake API seeded according to opts.
+func newLoadEnv(tb testing.TB, opts loadtest.Options) (*rackspacetest.Server, *Solver) {
+	tb.Helper()
+
+	srv := rackspacetest.NewServer()
+	tb.Cleanup(srv.Close)
+
+	tenant := srv.AddAccount("user", "api-key")
+	loadtest.Seed(srv, tenant, opts)
+
+	return srv, &Solver{
+		IdentityEndpoint: srv.IdentityEndpoint(),
+		Client: fake.NewSimpleClientset(credsSecret("creds", map[string]string{
+			"username": "user",
+			"api-key":  "api-key",
+		})),
+	}
+}
+
+// quietLogs drops the per request log lines for the rest of the test so that
+// logging doesn't dominate the measurements.
+func quietLogs(tb testing.TB) {
+	tb.Helper()
+
+	log.SetOutput(io.Discard)
+	klog.LogToStderr(false)
+	klog.SetOutput(io.Discard)
+
+	tb.Cleanup(func() {
+		log.SetOutput(os.Stderr)
+		klog.SetOutput(os.Stderr)
+		klog.LogToStderr(true)
+	})
+}
+
+func loadChallenge(i int) *v1alpha1.ChallengeRequest {
+	ch := challenge(fmt.Sprintf("_acme-challenge.host%d.%s.", i, loadtest.Zone), fmt.Sprintf("key-%d", i))
+	ch.ResolvedZone = loadtest.Zone + "."
+	return ch
+}
+
+func BenchmarkPresentCleanUp(b *testing.B) {
+	for _, opts := range []loadtest.Options{
+		{Domains: 10, Concurrency: 1},
+		{Domains: 1000, Concurrency: 1},
+		{Domains: 1000, Concurrency: 32},
+		{Domains: 5000, Concurrency: 32},
+		{Domains: 1000, Concurrency: 32, Latency: time.Millisecond},
+	} {
+		opts.PageSize = rackspacetest.DefaultPageSize
+
+		name := fmt.Sprintf("domains=%d/concurrency=%d/latency=%s", opts.Domains, opts.Concurrency, opts.Latency)
+		b.Run(name, func(b *testing.B) {
+			srv, solver := newLoadEnv(b, opts)
+			quietLogs(b)
+
+			b.ReportAllocs()
+			b.ResetTimer()
+
+			opts.Challenges = b.N
+			report := loadtest.Run(srv, solver, loadChallenge, opts)
+
+			b.StopTimer()
+			if len(report.Failures) > 0 {
+				b.Fatalf("%d challenges failed, first: %v", len(report.Failures), report.Failures[0])
+			}
+
+			b.ReportMetric(report.CallsPerChallenge, "calls/op")
+			b.ReportMetric(float64(report.P50.Nanoseconds()), "p50-ns")
+			b.ReportMetric(float64(report.P99.Nanoseconds()), "p99-ns")
+		})
+	}
+}
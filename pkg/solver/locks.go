@@ -0,0 +1,66 @@
+package solver
+
+import (
+	"context"
+	"sync"
+
+	"github.com/cert-manager/cert-manager/pkg/acme/webhook/apis/acme/v1alpha1"
+)
+
+// nameLocks serializes the operations on a single zone and FQDN, operations
+// on other names run in parallel. The zero value is ready to use.
+//
+// A wildcard certificate and its apex are validated through two challenges
+// for the same name, and the list-then-create and list-then-delete sequences
+// of Present and CleanUp must not interleave for them.
+type nameLocks struct {
+	mu    sync.Mutex
+	locks map[string]*nameLock
+}
+
+type nameLock struct {
+	held chan struct{}
+	refs int // holders and waiters, the entry is dropped at zero
+}
+
+// lockKey names the lock of a challenge.
+func lockKey(ch *v1alpha1.ChallengeRequest) string {
+	return normalizeName(ch.ResolvedZone) + "|" + normalizeName(ch.ResolvedFQDN)
+}
+
+// lock waits until the key is free or ctx ends, the returned function
+// releases it.
+func (l *nameLocks) lock(ctx context.Context, key string) (func(), error) {
+	l.mu.Lock()
+	if l.locks == nil {
+		l.locks = make(map[string]*nameLock)
+	}
+	nl, ok := l.locks[key]
+	if !ok {
+		nl = &nameLock{held: make(chan struct{}, 1)}
+		l.locks[key] = nl
+	}
+	nl.refs++
+	l.mu.Unlock()
+
+	select {
+	case nl.held <- struct{}{}:
+		return func() {
+			<-nl.held
+			l.release(key, nl)
+		}, nil
+	case <-ctx.Done():
+		l.release(key, nl)
+		return nil, ctx.Err()
+	}
+}
+
+func (l *nameLocks) release(key string, nl *nameLock) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	nl.refs--
+	if nl.refs == 0 {
+		delete(l.locks, key)
+	}
+}
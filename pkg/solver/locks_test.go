@@ -0,0 +1,236 @@
+package solver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/runtime"
+
+	"github.com/rackerlabs/cert-manager-webhook-rackspace/pkg/designatetest"
+)
+
+func TestNameLocks(t *testing.T) {
+	var locks nameLocks
+	ctx := context.Background()
+
+	t.Run("same key is serialized", func(t *testing.T) {
+		var holders, maxHolders atomic.Int32
+		var wg sync.WaitGroup
+		for range 20 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+
+				unlock, err := locks.lock(ctx, "example.com|_acme-challenge.example.com")
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				defer unlock()
+
+				n := holders.Add(1)
+				for {
+					m := maxHolders.Load()
+					if n <= m || maxHolders.CompareAndSwap(m, n) {
+						break
+					}
+				}
+				time.Sleep(time.Millisecond)
+				holders.Add(-1)
+			}()
+		}
+		wg.Wait()
+
+		if got := maxHolders.Load(); got != 1 {
+			t.Errorf("%d held the lock at once", got)
+		}
+	})
+
+	t.Run("other keys run in parallel", func(t *testing.T) {
+		unlock, err := locks.lock(ctx, "example.com|_acme-challenge.example.com")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer unlock()
+
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			unlockOther, err := locks.lock(ctx, "example.com|_acme-challenge.www.example.com")
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			unlockOther()
+		}()
+
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatal("lock on another name waited for the held one")
+		}
+	})
+
+	t.Run("waiting ends with the context", func(t *testing.T) {
+		unlock, err := locks.lock(ctx, "example.com|busy")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		waitCtx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+		defer cancel()
+
+		_, err = locks.lock(waitCtx, "example.com|busy")
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("expected %v, got %v", context.DeadlineExceeded, err)
+		}
+
+		unlock()
+	})
+
+	locks.mu.Lock()
+	defer locks.mu.Unlock()
+	if len(locks.locks) != 0 {
+		t.Errorf("released keys are forgotten: expected none, got %v", locks.locks)
+	}
+}
+
+// TestConcurrentSameName presents and cleans up many challenges for one name
+// at once. Designate keeps them in a single recordset that every call reads,
+// changes and writes back, so unserialized calls lose each other's values.
+// Run with -race.
+func TestConcurrentSameName(t *testing.T) {
+	openstack := designatetest.NewServer()
+	defer openstack.Close()
+
+	project := openstack.AddProject("dns")
+	openstack.AddApplicationCredential(project, "app-cred", "app-secret")
+	openstack.AddZone(project, "example.com")
+	openstack.SetLatency(2 * time.Millisecond)
+
+	env := newTestEnv(t, []runtime.Object{credsSecret("openstack", map[string]string{
+		"auth-url":                      openstack.AuthURL(),
+		"application-credential-id":     "app-cred",
+		"application-credential-secret": "app-secret",
+	})}...)
+
+	const (
+		fqdn       = "_acme-challenge.example.com."
+		challenges = 8
+	)
+
+	config := `{"provider":"designate","authSecretRef":"openstack"}`
+
+	run := func(action func(int) error) {
+		t.Helper()
+
+		errs := make(chan error, challenges)
+		var wg sync.WaitGroup
+		for i := range challenges {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				errs <- action(i)
+			}()
+		}
+		wg.Wait()
+		close(errs)
+
+		for err := range errs {
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	var want []string
+	for i := range challenges {
+		want = append(want, fmt.Sprintf("key-%d", i))
+	}
+
+	run(func(i int) error {
+		return env.solver.Present(challengeWithConfig(fqdn, want[i], config))
+	})
+	if got := openstack.TXTRecords(fqdn); !sameElements(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	// cleaning up half while presenting them again for a new order
+	run(func(i int) error {
+		ch := challengeWithConfig(fqdn, want[i], config)
+		if i%2 == 0 {
+			return env.solver.CleanUp(ch)
+		}
+		return env.solver.Present(challengeWithConfig(fqdn, want[i]+"-renewed", config))
+	})
+
+	var remaining []string
+	for i := range challenges {
+		if i%2 != 0 {
+			remaining = append(remaining, want[i], want[i]+"-renewed")
+		}
+	}
+	if got := openstack.TXTRecords(fqdn); !sameElements(got, remaining) {
+		t.Errorf("got %v, want %v", got, remaining)
+	}
+
+	env.solver.names.mu.Lock()
+	defer env.solver.names.mu.Unlock()
+	if len(env.solver.names.locks) != 0 {
+		t.Errorf("expected none, got %v", env.solver.names.locks)
+	}
+}
+
+// TestConcurrentWildcardAndApex cleans up the challenge of a wildcard
+// certificate while the apex challenge for the same name is presented.
+func TestConcurrentWildcardAndApex(t *testing.T) {
+	env := newTestEnv(t)
+	env.srv.SetLatency(2 * time.Millisecond)
+
+	const fqdn = "_acme-challenge.example.com."
+
+	for round := range 5 {
+		wildcard := challenge(fqdn, fmt.Sprintf("wildcard-%d", round))
+		apex := challenge(fqdn, fmt.Sprintf("apex-%d", round))
+
+		if err := env.solver.Present(wildcard); err != nil {
+			t.Fatal(err)
+		}
+
+		var wg sync.WaitGroup
+		var presentErr, cleanUpErr error
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			presentErr = env.solver.Present(apex)
+		}()
+		go func() {
+			defer wg.Done()
+			cleanUpErr = env.solver.CleanUp(wildcard)
+		}()
+		wg.Wait()
+
+		if err := presentErr; err != nil {
+			t.Fatal(err)
+		}
+		if err := cleanUpErr; err != nil {
+			t.Fatal(err)
+		}
+		if got, want := env.srv.TXTRecords(fqdn), []string{apex.Key}; !slices.Equal(got, want) {
+			t.Errorf("env.srv.TXTRecords(fqdn) = %v, want %v", got, want)
+		}
+
+		if err := env.solver.CleanUp(apex); err != nil {
+			t.Fatal(err)
+		}
+		if got := env.srv.TXTRecords(fqdn); len(got) != 0 {
+			t.Errorf("expected none, got %v", got)
+		}
+	}
+}
@@ -0,0 +1,193 @@
+package solver
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"math/rand"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"testing/quick"
+
+	extapi "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+)
+
+func FuzzLoadConfig(f *testing.F) {
+	for _, seed := range []string{
+		``,
+		`{}`,
+		`null`,
+		`{"authSecretRef":"creds","domainName":"example.com"}`,
+		`{"authSecretRefs":["a","b"],"routes":[{"zone":"*.example.net","authSecretRef":"c"}]}`,
+		`{"routes":[{"zone":"[","authSecretRefs":[]}]}`,
+		`{"provider":"designate","routes":[{"zone":"example.org","provider":"rackspace","authSecretRef":"d"}]}`,
+		`{"provider":"designate","authMethod":"application-credential","scope":{"projectName":"dns","userDomainID":"default"}}`,
+		`{"authSecretRef":1}`,
+	} {
+		f.Add([]byte(seed))
+	}
+
+	f.Fuzz(func(t *testing.T, raw []byte) {
+		cfg, err := loadConfig(&extapi.JSON{Raw: raw})
+		if err != nil {
+			return
+		}
+
+		// whatever decoded must survive a round trip unchanged, empty lists
+		// are omitted so compare the encodings
+		encoded, err := json.Marshal(cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		again, err := loadConfig(&extapi.JSON{Raw: encoded})
+		if err != nil {
+			t.Fatal(err)
+		}
+		reencoded, err := json.Marshal(again)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(encoded, reencoded) {
+			t.Errorf("reloaded as %s, want %s", reencoded, encoded)
+		}
+
+		// and picking credentials for any zone must not panic
+		_, _, _ = authSecretRefsFor(cfg, "_acme-challenge.example.com.")
+	})
+}
+
+func FuzzNormalizeName(f *testing.F) {
+	for _, seed := range []string{
+		"",
+		".",
+		"example.com",
+		"_acme-challenge.Example.COM.",
+		"*.example.com..",
+		"ÉXAMPLE.com",
+	} {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, name string) {
+		normalized := normalizeName(name)
+
+		if got := normalizeName(normalized); got != normalized {
+			t.Errorf("not idempotent: normalizeName(normalized) = %v, want %v", got, normalized)
+		}
+		if strings.HasSuffix(normalized, ".") {
+			t.Error("trailing dot kept")
+		}
+		if got := normalizeName(name + "."); got != normalized {
+			t.Errorf("trailing dot changes the name: got %v, want %v", got, normalized)
+		}
+		if got := normalizeName(strings.ToUpper(normalized)); got != normalized {
+			t.Errorf("case changes the name: normalizeName(strings.ToUpper(normalized)) = %v, want %v", got, normalized)
+		}
+	})
+}
+
+// randomFQDN builds names with mixed case, wildcards and trailing dots under
+// example.com.
+func randomFQDN(r *rand.Rand) string {
+	const chars = "abcXYZ019-_*"
+
+	labels := []string{"_acme-challenge"}
+	for range r.Intn(3) {
+		label := make([]byte, 1+r.Intn(8))
+		for i := range label {
+			label[i] = chars[r.Intn(len(chars))]
+		}
+		labels = append(labels, string(label))
+	}
+	if r.Intn(2) == 0 {
+		labels = append(labels, "*")
+	}
+	labels = append(labels, "ExAmPle", "COM")
+
+	return strings.Join(labels, ".") + strings.Repeat(".", r.Intn(3))
+}
+
+// TestPresentCleanUpSameName checks that, for any FQDN, the name CleanUp
+// searches for when it does not know the record's ID is exactly the name
+// Present created.
+func TestPresentCleanUpSameName(t *testing.T) {
+	env := newTestEnv(t)
+	// a replica that did not present the record
+	other := &Solver{
+		UserAgent:        env.solver.UserAgent,
+		IdentityEndpoint: env.solver.IdentityEndpoint,
+		Client:           env.solver.Client,
+	}
+
+	var mu sync.Mutex
+	var created, searched []string
+	env.srv.AddHook(func(w http.ResponseWriter, r *http.Request) bool {
+		if !strings.HasSuffix(r.URL.Path, "/records") {
+			return false
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		switch r.Method {
+		case http.MethodPost:
+			var body struct {
+				Records []struct {
+					Name string `json:"name"`
+				} `json:"records"`
+			}
+			// the hook must leave the body for the handler
+			raw, err := readAndRestoreBody(r)
+			if err == nil && json.Unmarshal(raw, &body) == nil && len(body.Records) > 0 {
+				created = append(created, body.Records[0].Name)
+			}
+		case http.MethodGet:
+			searched = append(searched, r.URL.Query().Get("name"))
+		}
+		return false
+	})
+
+	property := func(seed int64) bool {
+		fqdn := randomFQDN(rand.New(rand.NewSource(seed)))
+
+		mu.Lock()
+		created, searched = nil, nil
+		mu.Unlock()
+
+		ch := challenge(fqdn, "key")
+		if err := env.solver.Present(ch); err != nil {
+			t.Logf("Present(%q): %v", fqdn, err)
+			return false
+		}
+
+		// a later request may differ in case and trailing dots only
+		ch = challenge(strings.ToUpper(strings.TrimRight(fqdn, "."))+".", "key")
+		if err := other.CleanUp(ch); err != nil {
+			t.Logf("CleanUp(%q): %v", fqdn, err)
+			return false
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		// Present searches before creating and CleanUp by another replica
+		// searches again, all for the same name
+		return len(created) == 1 && created[0] == normalizeName(fqdn) &&
+			slices.Equal(searched, []string{created[0], created[0]}) &&
+			len(env.srv.Records(env.domID)) == 0
+	}
+
+	if err := quick.Check(property, &quick.Config{MaxCount: 50}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// readAndRestoreBody reads a request body while leaving it in place for the
+// next handler.
+func readAndRestoreBody(r *http.Request) ([]byte, error) {
+	raw, err := io.ReadAll(r.Body)
+	r.Body = io.NopCloser(bytes.NewReader(raw))
+	return raw, err
+}
@@ -0,0 +1,91 @@
+package solver
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/rackerlabs/cert-manager-webhook-rackspace/pkg/dnsprovider"
+)
+
+// commentCluster separates the cluster from the rest of a record comment.
+const commentCluster = " in cluster "
+
+// recordComment marks the records the solver creates, naming the cluster
+// when Solver.ClusterID is set.
+func (c *Solver) recordComment() string {
+	comment := "created by " + c.userAgent()
+	if c.ClusterID != "" {
+		comment += commentCluster + c.ClusterID
+	}
+	return comment
+}
+
+// commentOwner reports whether a record comment marks the record as created
+// by the solver, of any version, and the cluster it names if any.
+func (c *Solver) commentOwner(comment string) (string, bool) {
+	product, _, _ := strings.Cut(c.userAgent(), "/")
+
+	rest, ok := strings.CutPrefix(comment, "created by "+product)
+	if !ok {
+		return "", false
+	}
+	version, cluster, _ := strings.Cut(rest, commentCluster)
+	if version != "" && !strings.HasPrefix(version, "/") {
+		// another product whose name starts the same
+		return "", false
+	}
+	return cluster, true
+}
+
+// owns reports whether a record belongs to this cluster, so that it may be
+// deleted. A record naming a cluster belongs to that cluster only. Legacy
+// records, which name none, belong to a solver without a Solver.ClusterID
+// and to one adopting them with Solver.AdoptLegacyRecords.
+func (c *Solver) owns(record dnsprovider.Record) bool {
+	return c.ownsComment(record.Comment)
+}
+
+// ownsComment is owns for a record with the comment.
+func (c *Solver) ownsComment(comment string) bool {
+	if cluster, _ := c.commentOwner(comment); cluster != "" {
+		return cluster == c.ClusterID
+	}
+	return c.ClusterID == "" || c.AdoptLegacyRecords
+}
+
+// findTXTRecords returns the name's records with the data, and a function
+// adding a record to the name. Providers keeping every value of a name in one
+// record set are asked for it once, and adding to a set of another cluster is
+// refused, as the value would pass for one of that cluster's and could be
+// deleted by it. Once that cluster cleaned its values up the set is gone and
+// cert-manager presenting again succeeds.
+func (c *Solver) findTXTRecords(ctx context.Context, provider dnsprovider.Provider, domId string, fqdn string, data string) ([]dnsprovider.Record, func(dnsprovider.Record) (dnsprovider.Record, error), error) {
+	sets, ok := provider.(dnsprovider.RecordSets)
+	if !ok {
+		found, err := provider.FindTXTRecords(ctx, domId, fqdn, data)
+		add := func(record dnsprovider.Record) (dnsprovider.Record, error) {
+			return provider.CreateTXTRecord(ctx, domId, record)
+		}
+		return found, add, err
+	}
+
+	set, err := sets.TXTRecordSet(ctx, domId, fqdn)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var found []dnsprovider.Record
+	for _, record := range set.Records {
+		if record.Data == data {
+			found = append(found, record)
+		}
+	}
+	add := func(record dnsprovider.Record) (dnsprovider.Record, error) {
+		if set.ID != "" && !c.ownsComment(set.Comment) {
+			return dnsprovider.Record{}, fmt.Errorf("refusing to add to the txt record set `%s` of another cluster, marked %q", fqdn, set.Comment)
+		}
+		return sets.AddTXTRecord(ctx, domId, set, record)
+	}
+	return found, add, nil
+}
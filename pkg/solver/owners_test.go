@@ -0,0 +1,151 @@
+package solver
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/rackerlabs/cert-manager-webhook-rackspace/pkg/dnsprovider"
+	"github.com/rackerlabs/cert-manager-webhook-rackspace/pkg/rackspacetest"
+)
+
+func TestRecordComment(t *testing.T) {
+	env := newTestEnv(t)
+	env.solver.ClusterID = "blue"
+
+	if err := env.solver.Present(challenge("_acme-challenge.example.com.", "key")); err != nil {
+		t.Fatal(err)
+	}
+
+	records := env.srv.Records(env.domID)
+	if len(records) != 1 {
+		t.Fatalf("expected 1, got %v", records)
+	}
+	if got := records[0].Comment; got != "created by "+DefaultUserAgent+"/test in cluster blue" {
+		t.Errorf("records[0].Comment = %v, want %v", got, "created by "+DefaultUserAgent+"/test in cluster blue")
+	}
+
+	for _, tc := range []struct {
+		comment string
+		cluster string
+		marked  bool
+	}{
+		{comment: records[0].Comment, cluster: "blue", marked: true},
+		{comment: "created by " + DefaultUserAgent + "/0.1.0", marked: true},
+		{comment: "created by " + DefaultUserAgent, marked: true},
+		{comment: "created by " + DefaultUserAgent + "-fork/0.1.0"},
+		{comment: "created by hand"},
+		{comment: ""},
+	} {
+		cluster, marked := env.solver.commentOwner(tc.comment)
+		if marked != tc.marked {
+			t.Errorf("%v: marked = %v, want %v", tc.comment, marked, tc.marked)
+		}
+		if cluster != tc.cluster {
+			t.Errorf("%v: cluster = %v, want %v", tc.comment, cluster, tc.cluster)
+		}
+	}
+}
+
+func TestOwns(t *testing.T) {
+	const marker = "created by " + DefaultUserAgent + "/0.1.0"
+
+	for _, tc := range []struct {
+		name      string
+		clusterID string
+		adopt     bool
+		comment   string
+		want      bool
+	}{
+		{name: "no cluster, legacy", comment: marker, want: true},
+		{name: "no cluster, unmarked", comment: "", want: true},
+		{name: "no cluster, other cluster", comment: marker + " in cluster green"},
+		{name: "same cluster", clusterID: "blue", comment: marker + " in cluster blue", want: true},
+		{name: "other cluster", clusterID: "blue", comment: marker + " in cluster green"},
+		{name: "other cluster adopting", clusterID: "blue", adopt: true, comment: marker + " in cluster green"},
+		{name: "legacy", clusterID: "blue", comment: marker},
+		{name: "legacy adopted", clusterID: "blue", adopt: true, comment: marker, want: true},
+		{name: "unmarked adopted", clusterID: "blue", adopt: true, comment: "", want: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			s := &Solver{ClusterID: tc.clusterID, AdoptLegacyRecords: tc.adopt}
+			if got := s.owns(dnsprovider.Record{Comment: tc.comment}); got != tc.want {
+				t.Errorf("owns(%q) = %v, want %v", tc.comment, got, tc.want)
+			}
+		})
+	}
+}
+
+// TestCleanUpOwnership has two clusters share an account and solve the same
+// name with the same key, as when both request a certificate for it from the
+// same ACME account.
+func TestCleanUpOwnership(t *testing.T) {
+	env := newTestEnv(t)
+	env.solver.ClusterID = "blue"
+
+	green := restarted(env)
+	green.IntentNamespace = ""
+	green.ClusterID = "green"
+
+	ch := challenge("_acme-challenge.example.com.", "key")
+	if err := env.solver.Present(ch); err != nil {
+		t.Fatal(err)
+	}
+	if err := green.Present(ch); err != nil {
+		t.Fatal(err)
+	}
+
+	// without the stored IDs, blue must pick its own record among both
+	blue := restarted(env)
+	blue.IntentNamespace = ""
+	blue.ClusterID = "blue"
+	if err := blue.CleanUp(ch); err != nil {
+		t.Fatal(err)
+	}
+
+	records := env.srv.Records(env.domID)
+	if len(records) != 1 {
+		t.Fatalf("expected 1, got %v", records)
+	}
+	if got := records[0].Comment; !strings.Contains(got, "in cluster green") {
+		t.Errorf("expected %q in %q", "in cluster green", got)
+	}
+
+	// nor does blue delete green's record once its own is gone
+	err := blue.CleanUp(ch)
+	if err == nil || !strings.Contains(err.Error(), "owned by this cluster, leaving 1 of other clusters alone") {
+		t.Errorf("expected error %q, got %v", "owned by this cluster, leaving 1 of other clusters alone", err)
+	}
+	if got := env.srv.Records(env.domID); len(got) != 1 {
+		t.Errorf("expected 1, got %v", got)
+	}
+}
+
+func TestCleanUpAdoptsLegacyRecords(t *testing.T) {
+	env := newTestEnv(t)
+	env.solver.ClusterID = "blue"
+
+	// presented by a version without cluster IDs
+	env.srv.AddRecord(rackspacetest.Record{
+		DomainID: env.domID,
+		Name:     "_acme-challenge.example.com",
+		Type:     "TXT",
+		Data:     "key",
+		Comment:  "created by " + DefaultUserAgent + "/0.1.0",
+	})
+	ch := challenge("_acme-challenge.example.com.", "key")
+
+	if err := env.solver.CleanUp(ch); err == nil || !strings.Contains(err.Error(), "leaving 1 of other clusters alone") {
+		t.Errorf("expected error %q, got %v", "leaving 1 of other clusters alone", err)
+	}
+	if got := env.srv.Records(env.domID); len(got) != 1 {
+		t.Errorf("expected 1, got %v", got)
+	}
+
+	env.solver.AdoptLegacyRecords = true
+	if err := env.solver.CleanUp(ch); err != nil {
+		t.Fatal(err)
+	}
+	if got := env.srv.Records(env.domID); len(got) != 0 {
+		t.Errorf("expected none, got %v", got)
+	}
+}
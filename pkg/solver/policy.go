@@ -0,0 +1,173 @@
+package solver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/cert-manager/cert-manager/pkg/acme/webhook/apis/acme/v1alpha1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"sigs.k8s.io/yaml"
+)
+
+// ErrZoneNotAllowed is returned for challenges that Solver.ZonePolicy does
+// not let their namespace solve.
+var ErrZoneNotAllowed = errors.New("zone not allowed by policy")
+
+// ZonePolicy restricts the zones each namespace may solve challenges for, so
+// that a namespace able to reference a shared account's Secret does not get
+// challenges solved for every zone of the account.
+type ZonePolicy struct {
+	// DenyByDefault denies the challenges of namespaces no rule matches,
+	// which may otherwise solve challenges for any zone.
+	DenyByDefault bool             `json:"denyByDefault,omitempty"`
+	Rules         []ZonePolicyRule `json:"rules"`
+}
+
+// ZonePolicyRule lets the namespaces it matches solve challenges for some
+// zones. A namespace matched by several rules may use the zones of all of
+// them, and one matched by rules allowing none may use no zone at all.
+type ZonePolicyRule struct {
+	// Namespaces names the namespaces the rule matches, and NamespaceSelector
+	// selects more by their labels. An empty selector matches them all.
+	Namespaces        []string              `json:"namespaces,omitempty"`
+	NamespaceSelector *metav1.LabelSelector `json:"namespaceSelector,omitempty"`
+	// Zones are zone suffixes or patterns as described on ZoneRoute.Zone. A
+	// suffix also matches the names under it in a parent zone.
+	Zones []string `json:"zones"`
+}
+
+// ParseZonePolicy reads a zone policy in YAML or JSON, rejecting unknown
+// fields and rules that could never match as intended.
+func ParseZonePolicy(data []byte) (*ZonePolicy, error) {
+	policy := &ZonePolicy{}
+	if err := yaml.UnmarshalStrict(data, policy); err != nil {
+		return nil, err
+	}
+
+	for i, rule := range policy.Rules {
+		if err := rule.validate(); err != nil {
+			return nil, fmt.Errorf("rule %d: %w", i, err)
+		}
+	}
+
+	return policy, nil
+}
+
+func (r ZonePolicyRule) validate() error {
+	if len(r.Namespaces) == 0 && r.NamespaceSelector == nil {
+		return errors.New("no namespaces or namespaceSelector")
+	}
+	if r.NamespaceSelector != nil {
+		if _, err := metav1.LabelSelectorAsSelector(r.NamespaceSelector); err != nil {
+			return fmt.Errorf("invalid namespaceSelector: %w", err)
+		}
+	}
+	for _, zone := range r.Zones {
+		if _, err := path.Match(zone, ""); err != nil {
+			return fmt.Errorf("invalid zone pattern `%s`: %w", zone, err)
+		}
+	}
+	return nil
+}
+
+// matches reports whether the rule applies to a namespace, looking its
+// labels up only when a selector needs them.
+func (r ZonePolicyRule) matches(namespace string, nsLabels func() (labels.Set, error)) (bool, error) {
+	for _, name := range r.Namespaces {
+		if name == namespace {
+			return true, nil
+		}
+	}
+
+	if r.NamespaceSelector == nil {
+		return false, nil
+	}
+	selector, err := metav1.LabelSelectorAsSelector(r.NamespaceSelector)
+	if err != nil {
+		return false, err
+	}
+	set, err := nsLabels()
+	if err != nil {
+		return false, err
+	}
+	return selector.Matches(set), nil
+}
+
+// authorizeZone checks that Solver.ZonePolicy lets the challenge's namespace
+// solve challenges for its zone, or for its name when a rule names a zone
+// suffix the name lies under in a zone shared with others. It runs before
+// anything is done for the challenge, credentials included.
+func (c *Solver) authorizeZone(ctx context.Context, ch *v1alpha1.ChallengeRequest) error {
+	policy := c.ZonePolicy
+	if policy == nil {
+		return nil
+	}
+
+	namespace := ch.ResourceNamespace
+	zone := normalizeName(ch.ResolvedZone)
+	fqdn := normalizeName(ch.ResolvedFQDN)
+
+	var set labels.Set
+	nsLabels := func() (labels.Set, error) {
+		if set != nil {
+			return set, nil
+		}
+		ns, err := c.Client.CoreV1().Namespaces().Get(ctx, namespace, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			// a namespace being deleted may still clean its challenges up
+			set = labels.Set{}
+			return set, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("unable to get namespace `%s` to check the zone policy: %w", namespace, err)
+		}
+		set = labels.Set(ns.Labels)
+		return set, nil
+	}
+
+	matched := false
+	var allowed []string
+	for _, rule := range policy.Rules {
+		ok, err := rule.matches(namespace, nsLabels)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			continue
+		}
+
+		matched = true
+		for _, pattern := range rule.Zones {
+			if ruleAllows(pattern, zone, fqdn) {
+				return nil
+			}
+		}
+		allowed = append(allowed, rule.Zones...)
+	}
+
+	switch {
+	case !matched && !policy.DenyByDefault:
+		return nil
+	case !matched:
+		return fmt.Errorf("%w: no rule matches namespace `%s`, which may not solve challenges for zone `%s`", ErrZoneNotAllowed, namespace, zone)
+	case len(allowed) == 0:
+		return fmt.Errorf("%w: namespace `%s` may not solve challenges for any zone, including `%s`", ErrZoneNotAllowed, namespace, zone)
+	default:
+		return fmt.Errorf("%w: namespace `%s` may only solve challenges for zones %s, not `%s` in `%s`", ErrZoneNotAllowed, namespace, strings.Join(allowed, ", "), fqdn, zone)
+	}
+}
+
+// ruleAllows reports whether a zone of a rule allows a challenge. Patterns
+// only match the zone, as `*.example.com` would otherwise match names in
+// example.com itself, while a zone suffix also matches names under it.
+func ruleAllows(pattern string, zone string, fqdn string) bool {
+	if zoneMatches(pattern, zone) {
+		return true
+	}
+	return !strings.ContainsAny(pattern, "*?[") && zoneMatches(pattern, fqdn)
+}
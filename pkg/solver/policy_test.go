@@ -0,0 +1,196 @@
+package solver
+
+import (
+	"context"
+	"errors"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func namespace(name string, labels map[string]string) *corev1.Namespace {
+	return &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels}}
+}
+
+func TestParseZonePolicy(t *testing.T) {
+	policy, err := ParseZonePolicy([]byte(`
+denyByDefault: true
+rules:
+  - namespaces: [team-a]
+    zones: [team-a.example.com]
+  - namespaceSelector:
+      matchLabels:
+        dns.example.com/shared: "true"
+    zones: ["*.shared.example.com"]
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !policy.DenyByDefault {
+		t.Error("expected denyByDefault")
+	}
+	if len(policy.Rules) != 2 {
+		t.Fatalf("expected 2, got %v", policy.Rules)
+	}
+	if got, want := policy.Rules[0].Namespaces, []string{"team-a"}; !slices.Equal(got, want) {
+		t.Errorf("policy.Rules[0].Namespaces = %v, want %v", got, want)
+	}
+	if got, want := policy.Rules[1].NamespaceSelector.MatchLabels, map[string]string{"dns.example.com/shared": "true"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("policy.Rules[1].NamespaceSelector.MatchLabels = %v, want %v", got, want)
+	}
+
+	for _, tc := range []struct {
+		policy string
+		err    string
+	}{
+		{policy: `rules: [{namespaces: [a], zone: [example.com]}]`, err: `unknown field "zone"`},
+		{policy: `rules: [{zones: [example.com]}]`, err: "rule 0: no namespaces or namespaceSelector"},
+		{policy: `rules: [{namespaces: [a], zones: ["[example.com"]}]`, err: "rule 0: invalid zone pattern `[example.com`"},
+		{policy: `rules: [{namespaceSelector: {matchExpressions: [{key: a, operator: Bogus}]}, zones: [example.com]}]`, err: "rule 0: invalid namespaceSelector"},
+	} {
+		_, err := ParseZonePolicy([]byte(tc.policy))
+		if err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Errorf("%v: expected error %q, got %v", tc.policy, tc.err, err)
+		}
+	}
+}
+
+func TestAuthorizeZone(t *testing.T) {
+	env := newTestEnv(t,
+		namespace("team-a", nil),
+		namespace("team-b", map[string]string{"team": "b"}),
+		namespace("team-c", map[string]string{"team": "c"}),
+		namespace("other", nil),
+	)
+
+	policy := &ZonePolicy{Rules: []ZonePolicyRule{
+		{Namespaces: []string{"team-a"}, Zones: []string{"example.com"}},
+		{NamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"team": "b"}}, Zones: []string{"*.example.org"}},
+		{Namespaces: []string{"team-b"}, Zones: []string{"example.net"}},
+		{Namespaces: []string{"team-c"}},
+	}}
+	env.solver.ZonePolicy = policy
+
+	for _, tc := range []struct {
+		namespace     string
+		zone          string
+		denyByDefault bool
+		err           string
+	}{
+		{namespace: "team-a", zone: "example.com."},
+		{namespace: "team-a", zone: "sub.example.com."},
+		{namespace: "team-a", zone: "example.org.", err: "namespace `team-a` may only solve challenges for zones example.com, not `_acme-challenge.example.org` in `example.org`"},
+		{namespace: "team-b", zone: "b.example.org."},
+		{namespace: "team-b", zone: "example.net."},
+		{namespace: "team-b", zone: "example.com.", err: "namespace `team-b` may only solve challenges for zones *.example.org, example.net, not `_acme-challenge.example.com` in `example.com`"},
+		{namespace: "team-c", zone: "example.com.", err: "namespace `team-c` may not solve challenges for any zone, including `example.com`"},
+		{namespace: "other", zone: "example.com."},
+		{namespace: "other", zone: "example.com.", denyByDefault: true, err: "no rule matches namespace `other`, which may not solve challenges for zone `example.com`"},
+		{namespace: "deleted", zone: "example.com.", denyByDefault: true, err: "no rule matches namespace `deleted`"},
+	} {
+		policy.DenyByDefault = tc.denyByDefault
+
+		ch := challenge("_acme-challenge."+tc.zone, "key")
+		ch.ResourceNamespace = tc.namespace
+		ch.ResolvedZone = tc.zone
+
+		err := env.solver.authorizeZone(context.Background(), ch)
+		if tc.err == "" {
+			if err != nil {
+				t.Errorf("%s in %s: %v", tc.zone, tc.namespace, err)
+			}
+			continue
+		}
+		if !errors.Is(err, ErrZoneNotAllowed) {
+			t.Errorf("expected %v, got %v", ErrZoneNotAllowed, err)
+		}
+		if err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Errorf("expected error %q, got %v", tc.err, err)
+		}
+	}
+}
+
+// TestAuthorizeSubZone lets a namespace solve challenges for the names under
+// its zone suffix in a zone shared with others.
+func TestAuthorizeSubZone(t *testing.T) {
+	env := newTestEnv(t, namespace("team-a", nil))
+	env.solver.ZonePolicy = &ZonePolicy{Rules: []ZonePolicyRule{
+		{Namespaces: []string{"team-a"}, Zones: []string{"team-a.example.com", "*.example.org"}},
+	}}
+
+	for _, tc := range []struct {
+		fqdn string
+		zone string
+		err  string
+	}{
+		{fqdn: "_acme-challenge.team-a.example.com.", zone: "example.com."},
+		{fqdn: "_acme-challenge.www.team-a.example.com.", zone: "example.com."},
+		{fqdn: "_acme-challenge.team-a.example.com.", zone: "team-a.example.com."},
+		{fqdn: "_acme-challenge.example.com.", zone: "example.com.", err: "not `_acme-challenge.example.com` in `example.com`"},
+		{fqdn: "_acme-challenge.team-b.example.com.", zone: "example.com.", err: "not `_acme-challenge.team-b.example.com` in `example.com`"},
+		{fqdn: "_acme-challenge.evilteam-a.example.com.", zone: "example.com.", err: "not `_acme-challenge.evilteam-a.example.com` in `example.com`"},
+		{fqdn: "_acme-challenge.www.example.org.", zone: "www.example.org."},
+		// patterns only match zones
+		{fqdn: "_acme-challenge.www.example.org.", zone: "example.org.", err: "not `_acme-challenge.www.example.org` in `example.org`"},
+	} {
+		ch := challenge(tc.fqdn, "key")
+		ch.ResourceNamespace = "team-a"
+		ch.ResolvedZone = tc.zone
+
+		err := env.solver.authorizeZone(context.Background(), ch)
+		if tc.err == "" {
+			if err != nil {
+				t.Errorf("%s in %s: %v", tc.fqdn, tc.zone, err)
+			}
+			continue
+		}
+		if !errors.Is(err, ErrZoneNotAllowed) {
+			t.Errorf("expected %v, got %v", ErrZoneNotAllowed, err)
+		}
+		if err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Errorf("expected error %q, got %v", tc.err, err)
+		}
+	}
+}
+
+func TestZonePolicyDenied(t *testing.T) {
+	env := newTestEnv(t, namespace(testNamespace, nil))
+	env.solver.CleanUpRetryDelay = time.Hour
+	defer env.solver.Shutdown()
+
+	env.solver.ZonePolicy = &ZonePolicy{Rules: []ZonePolicyRule{
+		{Namespaces: []string{testNamespace}, Zones: []string{"example.org"}},
+	}}
+	ch := challenge("_acme-challenge.example.com.", "key")
+
+	err := env.solver.Present(ch)
+	if err == nil || !strings.Contains(err.Error(), "zone not allowed by policy: namespace `default` may only solve challenges for zones example.org, not `_acme-challenge.example.com` in `example.com`") {
+		t.Errorf("expected error %q, got %v", "zone not allowed by policy: namespace `default` may only solve challenges for zones example.org, not `_acme-challenge.example.com` in `example.com`", err)
+	}
+	if err := env.solver.CleanUp(ch); err == nil {
+		t.Fatal("expected an error")
+	}
+
+	// denied before logging in, and a denied CleanUp is not retried
+	if got := env.srv.Requests(); len(got) != 0 {
+		t.Errorf("expected none, got %v", got)
+	}
+	if got := debugCleanUps(t, env.solver); len(got) != 0 {
+		t.Errorf("expected none, got %v", got)
+	}
+
+	env.solver.ZonePolicy.Rules[0].Zones = append(env.solver.ZonePolicy.Rules[0].Zones, "example.com")
+	if err := env.solver.Present(ch); err != nil {
+		t.Fatal(err)
+	}
+	if err := env.solver.CleanUp(ch); err != nil {
+		t.Fatal(err)
+	}
+	if got := env.srv.TXTRecords("_acme-challenge.example.com"); len(got) != 0 {
+		t.Errorf("expected none, got %v", got)
+	}
+}
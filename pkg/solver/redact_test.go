@@ -0,0 +1,118 @@
+package solver
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cert-manager/cert-manager/pkg/acme/webhook/apis/acme/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	extapi "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+const (
+	leakUser   = "leaky-username"
+	leakKey    = "leaky-api-key-0123456789"
+	leakToken  = "leaky-token-abcdef"
+	leakTenant = "9876543"
+)
+
+// leakyRackspace echoes credentials back in its responses, the worst case
+// for anything that includes response bodies in errors.
+func leakyRackspace(t *testing.T, authOK bool) *httptest.Server {
+	t.Helper()
+
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		if r.URL.Path == "/v2.0/tokens" {
+			if !authOK {
+				w.WriteHeader(http.StatusUnauthorized)
+				fmt.Fprintf(w, `{"unauthorized":{"code":401,"message":"bad key %s for %s"}}`, leakKey, leakUser)
+				return
+			}
+			_ = json.NewEncoder(w).Encode(map[string]any{
+				"access": map[string]any{
+					"token": map[string]any{
+						"id":      leakToken,
+						"expires": time.Now().Add(time.Hour).UTC().Format("2006-01-02T15:04:05.000Z"),
+						"tenant":  map[string]any{"id": leakTenant, "name": leakTenant},
+					},
+					"serviceCatalog": []map[string]any{{
+						"name": "cloudDNS",
+						"type": "rax:dns",
+						"endpoints": []map[string]any{{
+							"publicURL": srv.URL + "/v1.0/" + leakTenant,
+							"tenantId":  leakTenant,
+						}},
+					}},
+					"user": map[string]any{"id": "1", "name": leakUser},
+				},
+			})
+			return
+		}
+
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintf(w, `{"message":"token %s for tenant %s"}`, leakToken, leakTenant)
+	}))
+	t.Cleanup(srv.Close)
+
+	return srv
+}
+
+func leakTestSolver(t *testing.T, authOK bool) (*Solver, *v1alpha1.ChallengeRequest) {
+	t.Helper()
+
+	srv := leakyRackspace(t, authOK)
+
+	client := fake.NewSimpleClientset(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "creds", Namespace: "default"},
+		Data: map[string][]byte{
+			"username": []byte(leakUser),
+			"api-key":  []byte(leakKey),
+		},
+	})
+
+	ch := &v1alpha1.ChallengeRequest{
+		ResourceNamespace: "default",
+		ResolvedZone:      "example.com.",
+		ResolvedFQDN:      "_acme-challenge.example.com.",
+		Key:               "challenge-key",
+		Config:            &extapi.JSON{Raw: []byte(`{"authSecretRef":"creds"}`)},
+	}
+
+	return &Solver{IdentityEndpoint: srv.URL + "/v2.0/", Client: client}, ch
+}
+
+func TestErrorsDoNotLeakSecrets(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		authOK bool
+	}{
+		{"authentication failure", false},
+		{"api failure", true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			solver, ch := leakTestSolver(t, tc.authOK)
+
+			for _, err := range []error{solver.Present(ch), solver.CleanUp(ch)} {
+				if err == nil {
+					t.Error("expected an error")
+					continue
+				}
+				for _, secret := range []string{leakUser, leakKey, leakToken, leakTenant} {
+					if got := err.Error(); strings.Contains(got, secret) {
+						t.Errorf("unexpected %q in %q", secret, got)
+					}
+				}
+			}
+		})
+	}
+}
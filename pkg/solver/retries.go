@@ -0,0 +1,294 @@
+package solver
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/cert-manager/cert-manager/pkg/acme/webhook/apis/acme/v1alpha1"
+	"k8s.io/client-go/util/workqueue"
+	"k8s.io/component-base/metrics"
+	"k8s.io/component-base/metrics/legacyregistry"
+	"k8s.io/klog/v2"
+
+	"github.com/rackerlabs/cert-manager-webhook-rackspace/internal/redact"
+)
+
+// DefaultCleanUpRetryMaxAge is how long a failed CleanUp is retried in the
+// background when Solver.CleanUpRetryMaxAge is zero.
+const DefaultCleanUpRetryMaxAge = time.Hour
+
+// DefaultCleanUpRetryDelay is the first delay before retrying a failed
+// CleanUp when Solver.CleanUpRetryDelay is zero. It doubles with every
+// failure up to cleanUpRetryMaxDelay.
+const DefaultCleanUpRetryDelay = 5 * time.Second
+
+const cleanUpRetryMaxDelay = 5 * time.Minute
+
+const metricsNamespace = "cert_manager_webhook_rackspace"
+
+var (
+	cleanUpRetryQueueDepth = metrics.NewGauge(&metrics.GaugeOpts{
+		Namespace:      metricsNamespace,
+		Name:           "cleanup_retry_queue_depth",
+		Help:           "Failed CleanUps waiting to be retried in the background.",
+		StabilityLevel: metrics.ALPHA,
+	})
+	cleanUpRetries = metrics.NewCounterVec(&metrics.CounterOpts{
+		Namespace:      metricsNamespace,
+		Name:           "cleanup_retries_total",
+		Help:           "Background CleanUp retries by result, one of succeeded, failed or expired.",
+		StabilityLevel: metrics.ALPHA,
+	}, []string{"result"})
+)
+
+func init() {
+	legacyregistry.MustRegister(cleanUpRetryQueueDepth, cleanUpRetries)
+}
+
+// retryQueue takes over CleanUps that failed, so that their records are
+// deleted even when cert-manager gives up on them or deletes the Challenge.
+// The zero value is ready to use, its worker starts with the first failure.
+type retryQueue struct {
+	start sync.Once
+	queue workqueue.RateLimitingInterface
+
+	mu      sync.Mutex
+	pending map[string]*pendingCleanUp // by accountKey
+	// done remembers the records a retry deleted, so that cert-manager
+	// cleaning them up again is not an error
+	done map[string]time.Time
+}
+
+// pendingCleanUp is a failed CleanUp waiting for its next retry.
+type pendingCleanUp struct {
+	ch *v1alpha1.ChallengeRequest
+
+	Namespace    string    `json:"namespace"`
+	FQDN         string    `json:"fqdn"`
+	FirstFailure time.Time `json:"firstFailure"`
+	LastFailure  time.Time `json:"lastFailure"`
+	Attempts     int       `json:"attempts"`
+	LastError    string    `json:"lastError"`
+}
+
+// retryCleanUp queues a failed CleanUp, or records another failure of one
+// that is already queued.
+func (c *Solver) retryCleanUp(ch *v1alpha1.ChallengeRequest, err error) {
+	if c.CleanUpRetryMaxAge < 0 || errors.Is(err, ErrShuttingDown) || errors.Is(err, ErrZoneNotAllowed) || recordGone(err) {
+		return
+	}
+
+	q := &c.retries
+	q.start.Do(func() {
+		q.queue = workqueue.NewRateLimitingQueueWithConfig(
+			workqueue.NewItemExponentialFailureRateLimiter(c.cleanUpRetryDelay(), cleanUpRetryMaxDelay),
+			workqueue.RateLimitingQueueConfig{Name: "cleanup-retries"},
+		)
+		go c.runRetries()
+	})
+	if q.queue == nil {
+		// shut down before it was ever started
+		return
+	}
+
+	key := accountKey(ch)
+	now := time.Now()
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.pending == nil {
+		q.pending = make(map[string]*pendingCleanUp)
+	}
+	item, ok := q.pending[key]
+	if !ok {
+		item = &pendingCleanUp{
+			ch:           ch.DeepCopy(),
+			Namespace:    ch.ResourceNamespace,
+			FQDN:         normalizeName(ch.ResolvedFQDN),
+			FirstFailure: now,
+		}
+		q.pending[key] = item
+		q.queue.AddRateLimited(key)
+		klog.Infof("Retrying the cleanup of %s in the background", ch.ResolvedFQDN)
+	}
+	item.LastFailure = now
+	item.LastError = redact.String(err.Error())
+
+	cleanUpRetryQueueDepth.Set(float64(len(q.pending)))
+}
+
+// cancelRetry drops a queued CleanUp that succeeded on its own, or whose
+// record was presented again, along with any earlier retry having deleted it.
+func (c *Solver) cancelRetry(ch *v1alpha1.ChallengeRequest) {
+	q := &c.retries
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	delete(q.done, accountKey(ch))
+	if _, ok := q.pending[accountKey(ch)]; ok {
+		delete(q.pending, accountKey(ch))
+		cleanUpRetryQueueDepth.Set(float64(len(q.pending)))
+	}
+}
+
+// retriedCleanUp reports whether a retry already deleted the challenge's
+// record.
+func (c *Solver) retriedCleanUp(ch *v1alpha1.ChallengeRequest) bool {
+	q := &c.retries
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for key, at := range q.done {
+		if time.Since(at) > c.cleanUpRetryMaxAge() {
+			delete(q.done, key)
+		}
+	}
+
+	_, ok := q.done[accountKey(ch)]
+	return ok
+}
+
+// runRetries retries queued CleanUps until the queue is shut down.
+func (c *Solver) runRetries() {
+	q := &c.retries
+	for {
+		key, shutdown := q.queue.Get()
+		if shutdown {
+			return
+		}
+		c.retry(key.(string))
+		q.queue.Done(key)
+	}
+}
+
+func (c *Solver) retry(key string) {
+	q := &c.retries
+
+	q.mu.Lock()
+	item, ok := q.pending[key]
+	q.mu.Unlock()
+	if !ok {
+		// cert-manager cleaned it up meanwhile
+		q.queue.Forget(key)
+		return
+	}
+
+	err := c.coalesce(flightCleanUp, item.ch, c.cleanUp)
+	if errors.Is(err, ErrShuttingDown) {
+		return
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	item.Attempts++
+	switch {
+	case err == nil || recordGone(err):
+		delete(q.pending, key)
+		if q.done == nil {
+			q.done = make(map[string]time.Time)
+		}
+		q.done[key] = time.Now()
+		q.queue.Forget(key)
+		cleanUpRetries.WithLabelValues("succeeded").Inc()
+		klog.Infof("Cleaned up %s after %d retries", item.FQDN, item.Attempts)
+	case time.Since(item.FirstFailure) > c.cleanUpRetryMaxAge():
+		delete(q.pending, key)
+		q.queue.Forget(key)
+		c.forgetRecord(item.ch)
+		cleanUpRetries.WithLabelValues("expired").Inc()
+		klog.Warningf("Giving up cleaning up %s after %d retries: %v", item.FQDN, item.Attempts, err)
+	default:
+		item.LastFailure = time.Now()
+		item.LastError = redact.String(err.Error())
+		q.queue.AddRateLimited(key)
+		cleanUpRetries.WithLabelValues("failed").Inc()
+	}
+
+	cleanUpRetryQueueDepth.Set(float64(len(q.pending)))
+}
+
+// recordGone reports whether a CleanUp failed only because no account held
+// the record, so that there is nothing left to retry.
+func recordGone(err error) bool {
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		errs := joined.Unwrap()
+		for _, err := range errs {
+			if !recordGone(err) {
+				return false
+			}
+		}
+		return len(errs) > 0
+	}
+	return errors.Is(err, errRecordNotFound)
+}
+
+// stopRetries shuts the queue down and logs the CleanUps left in it.
+func (c *Solver) stopRetries() {
+	q := &c.retries
+
+	// keeps the queue from starting from now on
+	q.start.Do(func() {})
+	if q.queue == nil {
+		return
+	}
+	q.queue.ShutDown()
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if len(q.pending) > 0 {
+		klog.Warningf("Abandoned %d queued cleanups on shutdown", len(q.pending))
+	}
+}
+
+// pendingCleanUps returns the queued CleanUps, oldest first.
+func (c *Solver) pendingCleanUps() []pendingCleanUp {
+	q := &c.retries
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	items := make([]pendingCleanUp, 0, len(q.pending))
+	for _, item := range q.pending {
+		items = append(items, *item)
+	}
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].FirstFailure.Before(items[j].FirstFailure)
+	})
+	return items
+}
+
+// DebugHandler serves the state of the solver for troubleshooting, the queued
+// CleanUp retries at /debug/cleanups and the metrics at /metrics. It is meant
+// for a listener that is not exposed outside the pod.
+func (c *Solver) DebugHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("GET /metrics", legacyregistry.Handler())
+	mux.HandleFunc("GET /debug/cleanups", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{"pending": c.pendingCleanUps()})
+	})
+	return mux
+}
+
+func (c *Solver) cleanUpRetryMaxAge() time.Duration {
+	if c.CleanUpRetryMaxAge > 0 {
+		return c.CleanUpRetryMaxAge
+	}
+	return DefaultCleanUpRetryMaxAge
+}
+
+func (c *Solver) cleanUpRetryDelay() time.Duration {
+	if c.CleanUpRetryDelay > 0 {
+		return c.CleanUpRetryDelay
+	}
+	return DefaultCleanUpRetryDelay
+}
@@ -0,0 +1,240 @@
+package solver
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rackerlabs/cert-manager-webhook-rackspace/pkg/rackspacetest"
+)
+
+// metricValue scrapes a sample from the debug handler's /metrics, zero when
+// it has not been recorded yet.
+func metricValue(t *testing.T, s *Solver, sample string) float64 {
+	t.Helper()
+
+	rec := httptest.NewRecorder()
+	s.DebugHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("rec.Code = %v, want %v", rec.Code, http.StatusOK)
+	}
+
+	scanner := bufio.NewScanner(rec.Body)
+	for scanner.Scan() {
+		name, value, ok := strings.Cut(scanner.Text(), " ")
+		if ok && name == sample {
+			v, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return v
+		}
+	}
+	return 0
+}
+
+func debugCleanUps(t *testing.T, s *Solver) []pendingCleanUp {
+	t.Helper()
+
+	rec := httptest.NewRecorder()
+	s.DebugHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/debug/cleanups", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("rec.Code = %v, want %v", rec.Code, http.StatusOK)
+	}
+
+	var body struct {
+		Pending []pendingCleanUp `json:"pending"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	return body.Pending
+}
+
+const (
+	retriesSucceeded = `cert_manager_webhook_rackspace_cleanup_retries_total{result="succeeded"}`
+	retriesFailed    = `cert_manager_webhook_rackspace_cleanup_retries_total{result="failed"}`
+	retriesExpired   = `cert_manager_webhook_rackspace_cleanup_retries_total{result="expired"}`
+)
+
+func TestCleanUpRetry(t *testing.T) {
+	env := newTestEnv(t)
+	env.solver.CleanUpRetryDelay = 10 * time.Millisecond
+	defer env.solver.Shutdown()
+
+	succeeded := metricValue(t, env.solver, retriesSucceeded)
+	failed := metricValue(t, env.solver, retriesFailed)
+
+	ch := challenge("_acme-challenge.example.com.", "key")
+	if err := env.solver.Present(ch); err != nil {
+		t.Fatal(err)
+	}
+
+	// the CleanUp and the first retry fail
+	env.srv.AddFault(rackspacetest.Fault{Method: http.MethodDelete, Path: "/records/", Status: http.StatusServiceUnavailable, Body: `{}`, Times: 2})
+	if err := env.solver.CleanUp(ch); err == nil {
+		t.Error("expected an error")
+	}
+
+	eventually(t, func() bool {
+		return len(env.srv.TXTRecords("_acme-challenge.example.com")) == 0
+	}, "record deleted by a retry")
+	eventually(t, func() bool {
+		return len(debugCleanUps(t, env.solver)) == 0
+	}, "retry dropped once it succeeds")
+
+	if got := metricValue(t, env.solver, retriesSucceeded); got != succeeded+1 {
+		t.Errorf("succeeded retries = %v, want %v", got, succeeded+1)
+	}
+	if got := metricValue(t, env.solver, retriesFailed); got != failed+1 {
+		t.Errorf("failed retries = %v, want %v", got, failed+1)
+	}
+
+	// cert-manager cleaning up again is not an error
+	if err := env.solver.CleanUp(ch); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCleanUpRetryPresentedAgain(t *testing.T) {
+	env := newTestEnv(t)
+	env.solver.CleanUpRetryDelay = 10 * time.Millisecond
+	defer env.solver.Shutdown()
+
+	ch := challenge("_acme-challenge.example.com.", "key")
+	if err := env.solver.Present(ch); err != nil {
+		t.Fatal(err)
+	}
+
+	env.srv.AddFault(rackspacetest.Fault{Method: http.MethodDelete, Path: "/records/", Status: http.StatusServiceUnavailable, Body: `{}`, Times: 1})
+	if err := env.solver.CleanUp(ch); err == nil {
+		t.Error("expected an error")
+	}
+	eventually(t, func() bool {
+		return len(debugCleanUps(t, env.solver)) == 0
+	}, "record deleted by a retry")
+
+	// the same challenge presented again is cleaned up again
+	if err := env.solver.Present(ch); err != nil {
+		t.Fatal(err)
+	}
+	if err := env.solver.CleanUp(ch); err != nil {
+		t.Fatal(err)
+	}
+	if got := env.srv.TXTRecords("_acme-challenge.example.com"); len(got) != 0 {
+		t.Errorf("expected none, got %v", got)
+	}
+}
+
+func TestCleanUpRetryPending(t *testing.T) {
+	env := newTestEnv(t)
+	env.solver.CleanUpRetryDelay = time.Hour
+	defer env.solver.Shutdown()
+
+	ch := challenge("_acme-challenge.example.com.", "key")
+	if err := env.solver.Present(ch); err != nil {
+		t.Fatal(err)
+	}
+
+	env.srv.AddFault(rackspacetest.Fault{Method: http.MethodDelete, Path: "/records/", Status: http.StatusServiceUnavailable, Body: `{}`, Times: 1})
+	if err := env.solver.CleanUp(ch); err == nil {
+		t.Fatal("expected an error")
+	}
+
+	pending := debugCleanUps(t, env.solver)
+	if len(pending) != 1 {
+		t.Fatalf("expected 1, got %v", pending)
+	}
+	if got := pending[0].Namespace; got != testNamespace {
+		t.Errorf("pending[0].Namespace = %v, want %v", got, testNamespace)
+	}
+	if got := pending[0].FQDN; got != "_acme-challenge.example.com" {
+		t.Errorf("pending[0].FQDN = %v, want %v", got, "_acme-challenge.example.com")
+	}
+	if got := pending[0].Attempts; got != 0 {
+		t.Errorf("pending[0].Attempts = %v", got)
+	}
+	if got := pending[0].LastError; !strings.Contains(got, "unable to delete DNS record") {
+		t.Errorf("expected %q in %q", "unable to delete DNS record", got)
+	}
+	if got := metricValue(t, env.solver, "cert_manager_webhook_rackspace_cleanup_retry_queue_depth"); got != 1.0 {
+		t.Errorf("got %v, want %v", got, 1.0)
+	}
+
+	// cert-manager succeeding on its own takes it out of the queue
+	if err := env.solver.CleanUp(ch); err != nil {
+		t.Fatal(err)
+	}
+	if got := debugCleanUps(t, env.solver); len(got) != 0 {
+		t.Errorf("expected none, got %v", got)
+	}
+	if got := metricValue(t, env.solver, "cert_manager_webhook_rackspace_cleanup_retry_queue_depth"); got != 0.0 {
+		t.Errorf("got %v, want %v", got, 0.0)
+	}
+}
+
+func TestCleanUpRetryExpires(t *testing.T) {
+	env := newTestEnv(t)
+	env.solver.CleanUpRetryDelay = 10 * time.Millisecond
+	env.solver.CleanUpRetryMaxAge = 50 * time.Millisecond
+	defer env.solver.Shutdown()
+
+	expired := metricValue(t, env.solver, retriesExpired)
+
+	ch := challenge("_acme-challenge.example.com.", "key")
+	if err := env.solver.Present(ch); err != nil {
+		t.Fatal(err)
+	}
+
+	env.srv.AddFault(rackspacetest.Fault{Method: http.MethodDelete, Path: "/records/", Status: http.StatusServiceUnavailable, Body: `{}`})
+	if err := env.solver.CleanUp(ch); err == nil {
+		t.Fatal("expected an error")
+	}
+
+	eventually(t, func() bool {
+		return metricValue(t, env.solver, retriesExpired) == expired+1
+	}, "retry expired")
+	if got := debugCleanUps(t, env.solver); len(got) != 0 {
+		t.Errorf("expected none, got %v", got)
+	}
+	if got, want := env.srv.TXTRecords("_acme-challenge.example.com"), []string{"key"}; !slices.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got := presentedRecords(env.solver); len(got) != 0 {
+		t.Errorf("remembers %v after giving up", got)
+	}
+}
+
+func TestCleanUpRetrySkipped(t *testing.T) {
+	env := newTestEnv(t)
+	env.solver.CleanUpRetryDelay = time.Hour
+	defer env.solver.Shutdown()
+
+	// nothing to retry when the record is not there
+	if err := env.solver.CleanUp(challenge("_acme-challenge.example.com.", "missing")); err == nil {
+		t.Fatal("expected an error")
+	}
+	if got := debugCleanUps(t, env.solver); len(got) != 0 {
+		t.Errorf("expected none, got %v", got)
+	}
+
+	// nor when retries are disabled
+	env.solver.CleanUpRetryMaxAge = -1
+	ch := challenge("_acme-challenge.example.com.", "key")
+	if err := env.solver.Present(ch); err != nil {
+		t.Fatal(err)
+	}
+	env.srv.AddFault(rackspacetest.Fault{Method: http.MethodDelete, Path: "/records/", Status: http.StatusServiceUnavailable, Body: `{}`, Times: 1})
+	if err := env.solver.CleanUp(ch); err == nil {
+		t.Fatal("expected an error")
+	}
+	if got := debugCleanUps(t, env.solver); len(got) != 0 {
+		t.Errorf("expected none, got %v", got)
+	}
+}
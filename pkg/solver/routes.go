@@ -0,0 +1,90 @@
+package solver
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// ZoneRoute maps challenges for a zone onto the Secret holding the
+// credentials of the account that hosts it.
+type ZoneRoute struct {
+	// Zone is either a zone suffix such as `example.com`, which also matches
+	// `sub.example.com`, or a pattern such as `*.example.com` following
+	// path.Match syntax.
+	Zone string `json:"zone"`
+	// Provider is the DNS provider of the account, the solver's own provider
+	// when empty.
+	Provider       string   `json:"provider,omitempty"`
+	AuthSecretRef  string   `json:"authSecretRef"`
+	AuthSecretRefs []string `json:"authSecretRefs,omitempty"`
+}
+
+// matches reports whether the route applies to the zone.
+func (r ZoneRoute) matches(zone string) bool {
+	return zoneMatches(r.Zone, zone)
+}
+
+// zoneMatches reports whether a zone suffix or pattern, as described on
+// ZoneRoute.Zone, matches the zone.
+func zoneMatches(pattern string, zone string) bool {
+	want := normalizeName(pattern)
+
+	if strings.ContainsAny(want, "*?[") {
+		ok, err := path.Match(want, zone)
+		return err == nil && ok
+	}
+
+	return zone == want || strings.HasSuffix(zone, "."+want)
+}
+
+// authSecretRefsFor picks the Secrets to use for the zone, in the order their
+// accounts should be tried, and the provider of those accounts. The most
+// specific matching route wins, falling back to the solver's own references.
+// No references and no error means the namespace's default credentials should
+// be used.
+func authSecretRefsFor(cfg Config, zone string) ([]string, string, error) {
+	zone = normalizeName(zone)
+
+	var best *ZoneRoute
+	for i, route := range cfg.Routes {
+		if !route.matches(zone) {
+			continue
+		}
+		if best == nil || len(route.Zone) > len(best.Zone) {
+			best = &cfg.Routes[i]
+		}
+	}
+
+	provider := cfg.Provider
+	if provider == "" {
+		provider = ProviderRackspace
+	}
+
+	if best != nil {
+		refs := secretRefs(best.AuthSecretRef, best.AuthSecretRefs)
+		if len(refs) == 0 {
+			return nil, "", fmt.Errorf("route for zone `%s` has no authSecretRef", best.Zone)
+		}
+		if best.Provider != "" {
+			provider = best.Provider
+		}
+		return refs, provider, nil
+	}
+
+	return secretRefs(cfg.AuthSecretRef, cfg.AuthSecretRefs), provider, nil
+}
+
+// secretRefs combines the single and list forms of a Secret reference.
+func secretRefs(ref string, refs []string) []string {
+	var out []string
+	if ref != "" {
+		out = append(out, ref)
+	}
+	for _, r := range refs {
+		if r != "" {
+			out = append(out, r)
+		}
+	}
+	return out
+}
@@ -0,0 +1,72 @@
+package solver
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestAuthSecretRefsFor(t *testing.T) {
+	cfg := Config{
+		AuthSecretRef: "default",
+		Routes: []ZoneRoute{
+			{Zone: "example.com", AuthSecretRef: "example"},
+			{Zone: "customer.example.com", AuthSecretRefs: []string{"customer", "customer-old"}},
+			{Zone: "*.customers.example.net", AuthSecretRef: "managed"},
+			{Zone: "openstack.example.org", Provider: ProviderDesignate, AuthSecretRef: "openstack"},
+			{Zone: "broken.example.org"},
+		},
+	}
+
+	for _, tc := range []struct {
+		zone     string
+		refs     []string
+		provider string
+		err      string
+	}{
+		{zone: "example.com.", refs: []string{"example"}},
+		{zone: "sub.example.com.", refs: []string{"example"}},
+		{zone: "customer.example.com.", refs: []string{"customer", "customer-old"}},
+		{zone: "shop.customer.example.com.", refs: []string{"customer", "customer-old"}},
+		{zone: "notexample.com.", refs: []string{"default"}},
+		{zone: "a.customers.example.net.", refs: []string{"managed"}},
+		{zone: "customers.example.net.", refs: []string{"default"}},
+		{zone: "openstack.example.org.", refs: []string{"openstack"}, provider: ProviderDesignate},
+		{zone: "broken.example.org.", err: "route for zone `broken.example.org` has no authSecretRef"},
+	} {
+		refs, provider, err := authSecretRefsFor(cfg, tc.zone)
+		if tc.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("%s: expected error %q, got %v", tc.zone, tc.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tc.zone, err)
+			continue
+		}
+
+		want := tc.provider
+		if want == "" {
+			want = ProviderRackspace
+		}
+		if !slices.Equal(refs, tc.refs) || provider != want {
+			t.Errorf("%s: got %v from %s, want %v from %s", tc.zone, refs, provider, tc.refs, want)
+		}
+	}
+}
+
+func TestAuthSecretRefsForDefaults(t *testing.T) {
+	refs, _, err := authSecretRefsFor(Config{AuthSecretRef: "primary", AuthSecretRefs: []string{"", "secondary"}}, "example.com.")
+	if err != nil || !slices.Equal(refs, []string{"primary", "secondary"}) {
+		t.Errorf("got %v and %v", refs, err)
+	}
+}
+
+func TestAuthSecretRefsForUnrouted(t *testing.T) {
+	// no references at all means the namespace's default credentials
+	refs, provider, err := authSecretRefsFor(Config{}, "example.com.")
+	if err != nil || refs != nil || provider != ProviderRackspace {
+		t.Errorf("got %v from %s and %v", refs, provider, err)
+	}
+}
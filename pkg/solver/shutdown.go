@@ -0,0 +1,162 @@
+package solver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s.io/klog/v2"
+)
+
+// DefaultShutdownGracePeriod bounds how long in-flight CleanUps may carry on
+// after shutdown began when Solver.ShutdownGracePeriod is zero.
+const DefaultShutdownGracePeriod = 20 * time.Second
+
+// ErrShuttingDown is returned for challenges that arrive once the solver has
+// begun shutting down. cert-manager retries them, usually on another replica.
+var ErrShuttingDown = errors.New("webhook is shutting down")
+
+// operations tracks the Present and CleanUp calls in flight and the contexts
+// they run under. The zero value is ready to use.
+//
+// On shutdown new calls are refused and in-flight Presents are cancelled at
+// once, as cert-manager presents the challenge again. CleanUps may finish
+// within the grace period, since an abandoned one leaves its record behind
+// until the challenge is cleaned up again.
+type operations struct {
+	mu       sync.Mutex
+	started  bool
+	draining bool
+	running  map[int]string // descriptions of the calls in flight
+	nextID   int
+	idle     chan struct{} // closed once draining and nothing is running
+
+	presents       context.Context
+	cancelPresents context.CancelFunc
+	cleanUps       context.Context
+	cancelCleanUps context.CancelFunc
+}
+
+func (o *operations) init() {
+	if o.started {
+		return
+	}
+	o.started = true
+	o.running = make(map[int]string)
+	o.idle = make(chan struct{})
+	o.cleanUps, o.cancelCleanUps = context.WithCancel(context.Background())
+	o.presents, o.cancelPresents = context.WithCancel(o.cleanUps)
+}
+
+// begin registers a call and returns the context it runs under, which ends
+// with the solver's timeout or on shutdown. end must be called once it
+// returned.
+func (o *operations) begin(action string, fqdn string, timeout time.Duration) (context.Context, func(), error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.init()
+	if o.draining {
+		return nil, nil, fmt.Errorf("refusing %s of `%s`: %w", action, fqdn, ErrShuttingDown)
+	}
+
+	parent := o.presents
+	if action == flightCleanUp {
+		parent = o.cleanUps
+	}
+	ctx, cancel := context.WithTimeout(parent, timeout)
+
+	id := o.nextID
+	o.nextID++
+	o.running[id] = action + " " + fqdn
+
+	return ctx, func() {
+		cancel()
+
+		o.mu.Lock()
+		defer o.mu.Unlock()
+
+		delete(o.running, id)
+		if o.draining && len(o.running) == 0 {
+			close(o.idle)
+		}
+	}, nil
+}
+
+// drain refuses new calls, cancels in-flight Presents and waits up to grace
+// for the rest before cancelling them too. It logs what it had to cut short.
+func (o *operations) drain(grace time.Duration) {
+	o.mu.Lock()
+	o.init()
+	if o.draining {
+		o.mu.Unlock()
+		return
+	}
+	o.draining = true
+	cancelled := o.describe(flightPresent)
+	inFlight := len(o.running)
+	if len(o.running) == 0 {
+		close(o.idle)
+	}
+	o.cancelPresents()
+	o.mu.Unlock()
+
+	klog.Infof("Shutting down, waiting up to %s for %d operations in flight", grace, inFlight)
+
+	timer := time.NewTimer(grace)
+	defer timer.Stop()
+
+	var abandoned []string
+	select {
+	case <-o.idle:
+	case <-timer.C:
+		o.mu.Lock()
+		abandoned = o.describe(flightCleanUp)
+		o.mu.Unlock()
+	}
+	o.cancelCleanUps()
+
+	if len(cancelled) > 0 {
+		klog.Warningf("Cancelled %d presents on shutdown, cert-manager will retry them: %s", len(cancelled), strings.Join(cancelled, ", "))
+	}
+	if len(abandoned) > 0 {
+		klog.Warningf("Abandoned %d cleanups still running after %s, their records stay until cleaned up again: %s", len(abandoned), grace, strings.Join(abandoned, ", "))
+	}
+	klog.Infof("Shutdown complete")
+}
+
+// describe lists the calls in flight of action, or all of them when empty,
+// must hold o.mu.
+func (o *operations) describe(action string) []string {
+	var running []string
+	for _, desc := range o.running {
+		if action == "" || strings.HasPrefix(desc, action+" ") {
+			running = append(running, desc)
+		}
+	}
+	sort.Strings(running)
+	return running
+}
+
+// Shutdown stops the solver taking new challenges and drains the ones in
+// flight as described on ShutdownGracePeriod. Initialize calls it once stopCh
+// is closed, embedders not running the webhook server may call it directly.
+// Every call returns once the drain has finished, so that a process may call
+// it before exiting even though stopCh already started it.
+func (c *Solver) Shutdown() {
+	c.shutdownOnce.Do(func() {
+		c.operations.drain(c.shutdownGracePeriod())
+		c.stopRetries()
+	})
+}
+
+func (c *Solver) shutdownGracePeriod() time.Duration {
+	if c.ShutdownGracePeriod > 0 {
+		return c.ShutdownGracePeriod
+	}
+	return DefaultShutdownGracePeriod
+}
@@ -0,0 +1,141 @@
+package solver
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// shutdown starts Shutdown and returns a channel closed once it returned.
+func shutdown(s *Solver) <-chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		s.Shutdown()
+	}()
+	return done
+}
+
+// async runs fn and returns a channel receiving its error.
+func async(fn func() error) <-chan error {
+	errc := make(chan error, 1)
+	go func() {
+		errc <- fn()
+	}()
+	return errc
+}
+
+func TestShutdownRefusesNewWork(t *testing.T) {
+	env := newTestEnv(t)
+	env.solver.Shutdown()
+
+	err := env.solver.Present(challenge("_acme-challenge.example.com.", "key"))
+	if !errors.Is(err, ErrShuttingDown) {
+		t.Errorf("expected %v, got %v", ErrShuttingDown, err)
+	}
+	if err == nil || !strings.Contains(err.Error(), "refusing present of `_acme-challenge.example.com.`") {
+		t.Errorf("expected error %q, got %v", "refusing present of `_acme-challenge.example.com.`", err)
+	}
+
+	err = env.solver.CleanUp(challenge("_acme-challenge.example.com.", "key"))
+	if !errors.Is(err, ErrShuttingDown) {
+		t.Errorf("expected %v, got %v", ErrShuttingDown, err)
+	}
+
+	if got := env.srv.Requests(); len(got) != 0 {
+		t.Errorf("nothing reached the API: expected none, got %v", got)
+	}
+
+	// shutting down twice is harmless
+	env.solver.Shutdown()
+}
+
+func TestShutdownDrainsCleanUps(t *testing.T) {
+	env := newTestEnv(t)
+	env.solver.ShutdownGracePeriod = 10 * time.Second
+
+	ch := challenge("_acme-challenge.example.com.", "key")
+	if err := env.solver.Present(ch); err != nil {
+		t.Fatal(err)
+	}
+
+	remove := gate(t, env.srv, http.MethodDelete, func(path string) bool { return strings.Contains(path, "/records/") })
+	cleanUp := async(func() error { return env.solver.CleanUp(ch) })
+	time.Sleep(50 * time.Millisecond)
+
+	done := shutdown(env.solver)
+	// such as the webhook's main shutting down after the signal handler did
+	again := shutdown(env.solver)
+	time.Sleep(50 * time.Millisecond)
+
+	select {
+	case <-done:
+		t.Fatal("shutdown did not wait for the cleanup in flight")
+	case <-again:
+		t.Fatal("shutting down again did not wait for the cleanup in flight")
+	default:
+	}
+	if err := env.solver.Present(challenge("_acme-challenge.example.com.", "other")); !errors.Is(err, ErrShuttingDown) {
+		t.Errorf("expected %v, got %v", ErrShuttingDown, err)
+	}
+
+	remove()
+	if err := <-cleanUp; err != nil {
+		t.Fatal(err)
+	}
+	<-done
+	<-again
+
+	if got := env.srv.TXTRecords("_acme-challenge.example.com"); len(got) != 0 {
+		t.Errorf("expected none, got %v", got)
+	}
+}
+
+func TestShutdownCancelsPresents(t *testing.T) {
+	env := newTestEnv(t)
+	env.solver.ShutdownGracePeriod = 10 * time.Second
+
+	create := gate(t, env.srv, http.MethodPost, func(path string) bool { return strings.HasSuffix(path, "/records") })
+	present := async(func() error { return env.solver.Present(challenge("_acme-challenge.example.com.", "key")) })
+	time.Sleep(50 * time.Millisecond)
+
+	start := time.Now()
+	<-shutdown(env.solver)
+
+	if err := <-present; err == nil || !strings.Contains(err.Error(), "context canceled") {
+		t.Errorf("expected error %q, got %v", "context canceled", err)
+	}
+	if d := time.Since(start); d >= 5*time.Second {
+		t.Errorf("presents are not waited for: shutdown took %s", d)
+	}
+
+	create()
+}
+
+func TestShutdownAbandonsSlowCleanUps(t *testing.T) {
+	env := newTestEnv(t)
+	env.solver.ShutdownGracePeriod = 100 * time.Millisecond
+
+	ch := challenge("_acme-challenge.example.com.", "key")
+	if err := env.solver.Present(ch); err != nil {
+		t.Fatal(err)
+	}
+
+	remove := gate(t, env.srv, http.MethodDelete, func(path string) bool { return strings.Contains(path, "/records/") })
+	cleanUp := async(func() error { return env.solver.CleanUp(ch) })
+	time.Sleep(50 * time.Millisecond)
+
+	start := time.Now()
+	<-shutdown(env.solver)
+
+	if d := time.Since(start); d >= 5*time.Second {
+		t.Errorf("shutdown took %s", d)
+	}
+	if err := <-cleanUp; err == nil || !strings.Contains(err.Error(), "context canceled") {
+		t.Errorf("expected error %q, got %v", "context canceled", err)
+	}
+
+	remove()
+}
@@ -0,0 +1,758 @@
+// Package solver implements the cert-manager webhook solver that presents
+// ACME DNS01 challenges through a dnsprovider.Provider, Rackspace Cloud DNS
+// or OpenStack Designate depending on the solver config.
+//
+//	solver := &solver.Solver{UserAgent: "my-tool/1.0"}
+//	cmd.RunWebhookServer(groupName, solver)
+package solver
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	extapi "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+
+	"k8s.io/klog/v2"
+
+	"golang.org/x/sync/singleflight"
+
+	"github.com/cert-manager/cert-manager/pkg/acme/webhook/apis/acme/v1alpha1"
+
+	"github.com/gophercloud/gophercloud/v2"
+	tokens2 "github.com/gophercloud/gophercloud/v2/openstack/identity/v2/tokens"
+
+	"github.com/rackerlabs/cert-manager-webhook-rackspace/internal/redact"
+	"github.com/rackerlabs/cert-manager-webhook-rackspace/pkg/designate"
+	"github.com/rackerlabs/cert-manager-webhook-rackspace/pkg/dnsprovider"
+	"github.com/rackerlabs/cert-manager-webhook-rackspace/pkg/rackspace"
+	"github.com/rackerlabs/goraxauth"
+)
+
+// DefaultUserAgent identifies the solver when Solver.UserAgent is empty.
+const DefaultUserAgent = "cert-manager-webhook-rackspace"
+
+// DefaultTimeout bounds a single Present or CleanUp call when Solver.Timeout
+// is zero.
+const DefaultTimeout = 60 * time.Second
+
+// Solver implements the provider-specific logic needed to 'present' an ACME
+// challenge TXT record for your own DNS provider.
+// To do so, it must implement the `github.com/cert-manager/cert-manager/pkg/acme/webhook.Solver`
+// interface.
+type Solver struct {
+	// UserAgent identifies the solver in API requests and in the comment of
+	// the records it creates, such as `cert-manager-webhook-rackspace/1.2.3`.
+	UserAgent string
+
+	// ClusterID names the cluster in the comment of the records the solver
+	// creates, so that clusters sharing an account tell their records apart.
+	// Once set, the solver only deletes records naming this cluster, and
+	// those naming none when AdoptLegacyRecords is set.
+	ClusterID          string
+	AdoptLegacyRecords bool
+
+	// IdentityEndpoint is the Rackspace identity service to log in to,
+	// rackspace.DefaultIdentityEndpoint when empty. Designate accounts name
+	// their Keystone endpoint in their Secret.
+	IdentityEndpoint string
+
+	// Transport carries every request to the DNS providers, nil meaning the
+	// default transport.
+	Transport http.RoundTripper
+
+	// RackspaceTransport carries the requests to Rackspace instead of
+	// Transport when set, leaving Designate alone.
+	RackspaceTransport http.RoundTripper
+
+	// Timeout bounds a single Present or CleanUp call, DefaultTimeout when
+	// zero.
+	Timeout time.Duration
+
+	// When a solver config does not reference any credentials, the solver
+	// looks in the challenge's namespace for a Secret named DefaultSecretName
+	// and then for a single Secret matching DefaultSecretSelector.
+	DefaultSecretName     string
+	DefaultSecretSelector string
+
+	// ZonePolicy restricts the zones each namespace may solve challenges
+	// for, checked before anything else is done for a challenge. Nil lets
+	// every namespace solve challenges for any zone its credentials host.
+	ZonePolicy *ZonePolicy
+
+	// NewProvider connects to the DNS provider of an account, defaulting to
+	// rackspace.Connect or designate.Connect when nil. Tests and other tools
+	// replace it to swap the backend.
+	NewProvider func(ctx context.Context, a Account) (dnsprovider.Provider, error)
+
+	// Client reads credential Secrets, it is set by Initialize.
+	Client kubernetes.Interface
+
+	// LeaseNamespace enables locking names through coordination.k8s.io
+	// Leases in this namespace, so that replicas of the webhook do not change
+	// the same name at once. Empty disables it.
+	LeaseNamespace string
+	// LeaseDuration is how long a Lease outlives a replica that stopped
+	// renewing it, DefaultLeaseDuration when zero.
+	LeaseDuration time.Duration
+	// LeaseHolder identifies this replica in the Leases it holds, the
+	// hostname when empty.
+	LeaseHolder string
+
+	// IntentNamespace enables recording every presented record in the
+	// ConfigMap IntentConfigMap of this namespace until it is cleaned up, so
+	// that records orphaned by a crash or failing CleanUps are deleted once
+	// their Challenge is gone. A single replica reconciles them at a time,
+	// holding a Lease in this namespace. Empty disables it.
+	IntentNamespace string
+	// IntentConfigMap is DefaultIntentConfigMap when empty.
+	IntentConfigMap string
+	// DynamicClient looks up Challenges when reconciling intents, it is set
+	// by Initialize.
+	DynamicClient dynamic.Interface
+
+	// CleanUpRetryMaxAge is how long a failed CleanUp is retried in the
+	// background, DefaultCleanUpRetryMaxAge when zero and never when
+	// negative. Retries start after CleanUpRetryDelay, DefaultCleanUpRetryDelay
+	// when zero, and back off exponentially.
+	CleanUpRetryMaxAge time.Duration
+	CleanUpRetryDelay  time.Duration
+
+	// Sweeper enables periodically deleting the stale records of some zones,
+	// those the solver created and that were never cleaned up. Nil disables
+	// it.
+	Sweeper *SweepConfig
+
+	// ShutdownGracePeriod is how long CleanUps in flight may carry on once
+	// shutdown began, DefaultShutdownGracePeriod when zero. New challenges
+	// are refused and Presents in flight are cancelled right away.
+	ShutdownGracePeriod time.Duration
+
+	// presented remembers where each presented record was created, so that
+	// CleanUp deletes it from the same account by its ID.
+	presented   map[string]presentedRecord
+	presentedMu sync.Mutex
+
+	// names serializes Present and CleanUp calls for the same name.
+	names nameLocks
+
+	// flights coalesces concurrent identical challenge requests, logins and
+	// zone lookups.
+	flights singleflight.Group
+
+	// operations tracks the calls in flight for Shutdown.
+	operations   operations
+	shutdownOnce sync.Once
+
+	intents intentStore
+
+	// retries holds the failed CleanUps being retried in the background.
+	retries retryQueue
+}
+
+// Config is a structure that is used to decode into when
+// solving a DNS01 challenge.
+// This information is provided by cert-manager, and may be a reference to
+// additional configuration that's needed to solve the challenge for this
+// particular certificate or issuer.
+// This typically includes references to Secret resources containing DNS
+// provider credentials, in cases where a 'multi-tenant' DNS solver is being
+// created.
+// You should not include sensitive information here. If credentials need to
+// be used by your provider here, you should reference a Kubernetes Secret
+// resource and fetch these credentials using a Kubernetes clientset.
+type Config struct {
+	// Provider is the DNS provider hosting the zones, ProviderRackspace when
+	// empty. Routes may name a different one.
+	Provider       string      `json:"provider,omitempty"`
+	DomainName     string      `json:"domainName"`
+	AuthSecretRef  string      `json:"authSecretRef"`
+	AuthSecretRefs []string    `json:"authSecretRefs,omitempty"`
+	Routes         []ZoneRoute `json:"routes,omitempty"`
+
+	// AuthMethod and Scope configure how Designate accounts log in to
+	// Keystone, the values in an account's Secret taking precedence.
+	AuthMethod string         `json:"authMethod,omitempty"`
+	Scope      *KeystoneScope `json:"scope,omitempty"`
+}
+
+// Name is used as the name for this DNS solver when referencing it on the ACME
+// Issuer resource.
+// This should be unique **within the group name**, i.e. you can have two
+// solvers configured with the same Name() **so long as they do not co-exist
+// within a single webhook deployment**.
+// For example, `cloudflare` may be used as the name of a solver.
+func (c *Solver) Name() string {
+	return "rackspace"
+}
+
+// Present is responsible for actually presenting the DNS record with the
+// DNS provider.
+// This method should tolerate being called multiple times with the same value.
+// cert-manager itself will later perform a self check to ensure that the
+// solver has correctly configured the DNS provider.
+func (c *Solver) Present(ch *v1alpha1.ChallengeRequest) error {
+	return redact.Error(c.coalesce(flightPresent, ch, c.present))
+}
+
+func (c *Solver) present(ch *v1alpha1.ChallengeRequest) error {
+	klog.V(6).Infof("call function Present: namespace=%s, zone=%s, fqdn=%s",
+		ch.ResourceNamespace, ch.ResolvedZone, ch.ResolvedFQDN)
+
+	ctx, end, err := c.operations.begin(flightPresent, ch.ResolvedFQDN, c.timeout())
+	if err != nil {
+		return err
+	}
+	defer end()
+
+	if err := c.authorizeZone(ctx, ch); err != nil {
+		return err
+	}
+
+	unlock, err := c.names.lock(ctx, lockKey(ch))
+	if err != nil {
+		return fmt.Errorf("timed out waiting for another operation on `%s`: %w", ch.ResolvedFQDN, err)
+	}
+	defer unlock()
+
+	accounts, err := clientConfig(ctx, c, ch)
+	if err != nil {
+		return fmt.Errorf("unable to get secret from namespace `%s`: %w", ch.ResourceNamespace, err)
+	}
+
+	domainName := normalizeName(ch.ResolvedZone)
+	fqdn := normalizeName(ch.ResolvedFQDN)
+
+	provider, account, domId, err := c.loadAccountDomainId(ctx, accounts, domainName)
+	if err != nil {
+		return fmt.Errorf("unable to find domain ID for domain `%s`: %w", ch.ResolvedZone, err)
+	}
+
+	unlockLease, err := c.lockLease(ctx, account, fqdn)
+	if err != nil {
+		return err
+	}
+	defer unlockLease()
+
+	// a Present repeated by cert-manager keeps the record it created before,
+	// so that CleanUp leaves no duplicate behind
+	existing, add, err := c.findTXTRecords(ctx, provider, domId, fqdn, ch.Key)
+	if err != nil {
+		return fmt.Errorf("unable to look up DNS record `%v`: %w", ch.ResolvedFQDN, err)
+	}
+	var record dnsprovider.Record
+	if i := slices.IndexFunc(existing, c.owns); i >= 0 {
+		record = existing[i]
+		klog.Infof("Txt record %v is already presented as %v", ch.ResolvedFQDN, record.ID)
+	} else {
+		record, err = add(dnsprovider.Record{
+			Name:    fqdn,
+			Type:    "TXT",
+			Data:    ch.Key,
+			TTL:     300,
+			Comment: c.recordComment(),
+		})
+		if err != nil {
+			return fmt.Errorf("unable to create DNS record `%v`: %w", ch.ResolvedFQDN, err)
+		}
+		klog.Infof("Presented txt record %v as %v", ch.ResolvedFQDN, record.ID)
+	}
+
+	c.rememberRecord(ch, presentedRecord{SecretRef: account.SecretRef, DomainID: domId, RecordID: record.ID})
+	c.recordIntent(ctx, ch, account, domId, record.ID)
+	// a retry of an earlier CleanUp must neither delete the new record nor
+	// stand in for cleaning it up
+	c.cancelRetry(ch)
+
+	return nil
+}
+
+// CleanUp should delete the relevant TXT record from the DNS provider console.
+// If multiple TXT records exist with the same record name (e.g.
+// _acme-challenge.example.com) then **only** the record with the same `key`
+// value provided on the ChallengeRequest should be cleaned up.
+// This is in order to facilitate multiple DNS validations for the same domain
+// concurrently.
+//
+// A failed CleanUp is also retried in the background, which cert-manager
+// cleaning the challenge up again joins.
+func (c *Solver) CleanUp(ch *v1alpha1.ChallengeRequest) error {
+	if c.retriedCleanUp(ch) {
+		klog.Infof("Deleted txt record %v on an earlier retry", ch.ResolvedFQDN)
+		return nil
+	}
+
+	err := c.coalesce(flightCleanUp, ch, c.cleanUp)
+	if err != nil {
+		c.retryCleanUp(ch, err)
+	} else {
+		c.cancelRetry(ch)
+	}
+
+	return redact.Error(err)
+}
+
+func (c *Solver) cleanUp(ch *v1alpha1.ChallengeRequest) error {
+	klog.V(6).Infof("call function CleanUp: namespace=%s, zone=%s, fqdn=%s",
+		ch.ResourceNamespace, ch.ResolvedZone, ch.ResolvedFQDN)
+
+	ctx, end, err := c.operations.begin(flightCleanUp, ch.ResolvedFQDN, c.timeout())
+	if err != nil {
+		return err
+	}
+	defer end()
+
+	if err := c.authorizeZone(ctx, ch); err != nil {
+		return err
+	}
+
+	unlock, err := c.names.lock(ctx, lockKey(ch))
+	if err != nil {
+		return fmt.Errorf("timed out waiting for another operation on `%s`: %w", ch.ResolvedFQDN, err)
+	}
+	defer unlock()
+
+	accounts, err := clientConfig(ctx, c, ch)
+	if err != nil {
+		return fmt.Errorf("unable to get secret from namespace `%s`: %w", ch.ResourceNamespace, err)
+	}
+
+	domainName := normalizeName(ch.ResolvedZone)
+
+	// the account the record was presented in is the one to delete it from,
+	// the others are only searched when that is unknown or fails
+	stored := c.storedRecord(ctx, ch)
+
+	var errs []error
+	for _, account := range preferAccount(stored.SecretRef, accounts) {
+		err := c.cleanUpRecord(ctx, account, domainName, ch, stored)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		c.forgetRecord(ch)
+		c.forgetIntent(ctx, ch)
+
+		klog.Infof("Deleted txt record %v", ch.ResolvedFQDN)
+
+		return nil
+	}
+
+	return errors.Join(errs...)
+}
+
+// cleanUpRecord deletes the challenge's TXT record from a single account, by
+// the IDs stored when it was presented in that account and otherwise by
+// searching for its name and key.
+func (c *Solver) cleanUpRecord(ctx context.Context, account Account, domainName string, ch *v1alpha1.ChallengeRequest, stored presentedRecord) error {
+	provider, err := c.provider(ctx, account)
+	if err != nil {
+		return fmt.Errorf("unable to authenticate to %s: %w", account.Provider, err)
+	}
+
+	klog.Infof("Configured %s DNS client", account.Provider)
+
+	domId, recordId := stored.DomainID, stored.RecordID
+	if stored.SecretRef != account.SecretRef || domId == "" || recordId == "" {
+		recordId = ""
+		domId, err = c.findZone(ctx, provider, account, domainName)
+		if err != nil {
+			return fmt.Errorf("unable to find domain ID for domain `%s`: %w", ch.ResolvedZone, err)
+		}
+	}
+
+	unlockLease, err := c.lockLease(ctx, account, ch.ResolvedFQDN)
+	if err != nil {
+		return err
+	}
+	defer unlockLease()
+
+	if recordId != "" {
+		deleteErr := provider.DeleteRecord(ctx, domId, recordId)
+		if !gophercloud.ResponseCodeIs(deleteErr, http.StatusNotFound) {
+			if deleteErr != nil {
+				return fmt.Errorf("unable to delete DNS record for `%s`: %w", ch.ResolvedFQDN, deleteErr)
+			}
+			return nil
+		}
+		// someone else deleted it, or deleted and recreated it
+		klog.Infof("Stored ID of txt record %v is gone, searching for it", ch.ResolvedFQDN)
+	}
+
+	recordId, err = c.loadRecordId(ctx, provider, domId, ch)
+	if err != nil {
+		return fmt.Errorf("unable to find DNS record for `%s`: %w", ch.ResolvedFQDN, err)
+	}
+
+	deleteErr := provider.DeleteRecord(ctx, domId, recordId)
+	if deleteErr != nil {
+		return fmt.Errorf("unable to delete DNS record for `%s`: %w", ch.ResolvedFQDN, deleteErr)
+	}
+
+	return nil
+}
+
+// accountKey identifies a presented record across Present and CleanUp.
+func accountKey(ch *v1alpha1.ChallengeRequest) string {
+	return normalizeName(ch.ResolvedFQDN) + "|" + ch.Key
+}
+
+// presentedRecordTTL is how long a presented record is remembered when no
+// CleanUp forgets it, as when cert-manager deletes the challenge first. A
+// later CleanUp looks it up in its intent or searches for it instead.
+const presentedRecordTTL = 24 * time.Hour
+
+// presentedRecord is where a challenge's record was created.
+type presentedRecord struct {
+	SecretRef string
+	DomainID  string
+	RecordID  string
+
+	// remembered is when this replica presented the record
+	remembered time.Time
+}
+
+// rememberRecord records where a challenge's record was created, and forgets
+// the records presented longer than presentedRecordTTL ago.
+func (c *Solver) rememberRecord(ch *v1alpha1.ChallengeRequest, record presentedRecord) {
+	c.presentedMu.Lock()
+	defer c.presentedMu.Unlock()
+
+	for key, r := range c.presented {
+		if time.Since(r.remembered) > presentedRecordTTL {
+			delete(c.presented, key)
+		}
+	}
+
+	if c.presented == nil {
+		c.presented = make(map[string]presentedRecord)
+	}
+	record.remembered = time.Now()
+	c.presented[accountKey(ch)] = record
+}
+
+// forgetRecord drops a record that was cleaned up, or that no longer will be.
+func (c *Solver) forgetRecord(ch *v1alpha1.ChallengeRequest) {
+	c.presentedMu.Lock()
+	defer c.presentedMu.Unlock()
+
+	delete(c.presented, accountKey(ch))
+}
+
+// storedRecord returns where a challenge's record was created, as remembered
+// by this replica or, after a restart or on another replica, as stored in
+// its cleanup intent. It is empty when unknown.
+func (c *Solver) storedRecord(ctx context.Context, ch *v1alpha1.ChallengeRequest) presentedRecord {
+	c.presentedMu.Lock()
+	record, ok := c.presented[accountKey(ch)]
+	c.presentedMu.Unlock()
+
+	if ok {
+		return record
+	}
+
+	if in, ok := c.storedIntent(ctx, ch); ok {
+		return presentedRecord{SecretRef: in.SecretRef, DomainID: in.DomainID, RecordID: in.RecordID}
+	}
+
+	return presentedRecord{}
+}
+
+// preferAccount moves the account holding secretRef to the front.
+func preferAccount(secretRef string, accounts []Account) []Account {
+	if secretRef == "" {
+		return accounts
+	}
+
+	ordered := make([]Account, 0, len(accounts))
+	for _, account := range accounts {
+		if account.SecretRef == secretRef {
+			ordered = append(ordered, account)
+		}
+	}
+	for _, account := range accounts {
+		if account.SecretRef != secretRef {
+			ordered = append(ordered, account)
+		}
+	}
+	return ordered
+}
+
+// Initialize will be called when the webhook first starts.
+// This method can be used to instantiate the webhook, i.e. initialising
+// connections or warming up caches.
+// Typically, the kubeClientConfig parameter is used to build a Kubernetes
+// client that can be used to fetch resources from the Kubernetes API, e.g.
+// Secret resources containing credentials used to authenticate with DNS
+// provider accounts.
+// The stopCh can be used to handle early termination of the webhook, in cases
+// where a SIGTERM or similar signal is sent to the webhook process. Once it
+// is closed the solver shuts down as described on Shutdown.
+func (c *Solver) Initialize(kubeClientConfig *rest.Config, stopCh <-chan struct{}) error {
+	cl, err := kubernetes.NewForConfig(kubeClientConfig)
+	if err != nil {
+		return err
+	}
+
+	c.Client = cl
+
+	dyn, err := dynamic.NewForConfig(kubeClientConfig)
+	if err != nil {
+		return err
+	}
+
+	c.DynamicClient = dyn
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go c.reconcileIntentsElected(ctx)
+
+	if c.Sweeper != nil {
+		go c.runSweeper(ctx)
+	}
+
+	go func() {
+		<-stopCh
+		cancel()
+		c.Shutdown()
+	}()
+
+	return nil
+}
+
+func (c *Solver) userAgent() string {
+	if c.UserAgent != "" {
+		return c.UserAgent
+	}
+	return DefaultUserAgent
+}
+
+func (c *Solver) timeout() time.Duration {
+	if c.Timeout > 0 {
+		return c.Timeout
+	}
+	return DefaultTimeout
+}
+
+func (c *Solver) rackspaceTransport() http.RoundTripper {
+	if c.RackspaceTransport != nil {
+		return c.RackspaceTransport
+	}
+	return c.Transport
+}
+
+func (c *Solver) identityEndpoint() string {
+	if c.IdentityEndpoint != "" {
+		return c.IdentityEndpoint
+	}
+	return rackspace.DefaultIdentityEndpoint
+}
+
+func stringFromSecretData(secretData map[string][]byte, key string) (string, error) {
+	data, ok := secretData[key]
+	if !ok {
+		return "", fmt.Errorf("key %q not found in secret data", key)
+	}
+	return string(data), nil
+}
+
+// loadConfig is a small helper function that decodes JSON configuration into
+// the typed config struct.
+func loadConfig(cfgJSON *extapi.JSON) (Config, error) {
+	cfg := Config{}
+	// handle the 'base case' where no configuration has been provided
+	if cfgJSON == nil {
+		return cfg, nil
+	}
+	if err := json.Unmarshal(cfgJSON.Raw, &cfg); err != nil {
+		return cfg, fmt.Errorf("error decoding solver config: %w", err)
+	}
+
+	return cfg, nil
+}
+
+// clientConfig loads the credentials of every account that may host the
+// challenge's zone, in the order they should be tried.
+func clientConfig(ctx context.Context, c *Solver, ch *v1alpha1.ChallengeRequest) ([]Account, error) {
+	cfg, err := loadConfig(ch.Config)
+	if err != nil {
+		return nil, err
+	}
+
+	secretNames, providerName, err := authSecretRefsFor(cfg, ch.ResolvedZone)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(secretNames) == 0 {
+		secretName, err := defaultSecretRef(ctx, c, ch.ResourceNamespace)
+		if err != nil {
+			return nil, err
+		}
+		secretNames = []string{secretName}
+	}
+
+	accounts := make([]Account, 0, len(secretNames))
+	for _, secretName := range secretNames {
+		account, err := secretConfig(ctx, c, ch.ResourceNamespace, secretName, providerName, cfg)
+		if err != nil {
+			return nil, err
+		}
+
+		account.Rackspace.DomainName = cfg.DomainName
+		accounts = append(accounts, account)
+	}
+
+	return accounts, nil
+}
+
+// secretConfig builds the credentials for a single account of the provider
+// from its Secret.
+func secretConfig(ctx context.Context, c *Solver, namespace string, secretName string, providerName string, cfg Config) (Account, error) {
+	account := Account{Provider: providerName, SecretRef: namespace + "/" + secretName}
+
+	sec, err := c.Client.CoreV1().Secrets(namespace).Get(ctx, secretName, metav1.GetOptions{})
+
+	if err != nil {
+		return account, fmt.Errorf("unable to get secret `%s/%s`: %w", namespace, secretName, err)
+	}
+
+	switch providerName {
+	case ProviderRackspace:
+		account.Rackspace, err = rackspaceConfig(c, sec.Data, account.SecretRef)
+	case ProviderDesignate:
+		account.Designate, err = designateConfig(c, sec.Data, account.SecretRef, cfg)
+	default:
+		err = fmt.Errorf("unknown provider `%s`", providerName)
+	}
+
+	return account, err
+}
+
+// rackspaceConfig reads the API key credentials of a Rackspace account.
+func rackspaceConfig(c *Solver, data map[string][]byte, secretRef string) (rackspace.Config, error) {
+	var config rackspace.Config
+
+	username, err := stringFromSecretData(data, "username")
+	if err != nil {
+		return config, fmt.Errorf("unable to get username from secret `%s`: %w", secretRef, err)
+	}
+
+	apiKey, err := stringFromSecretData(data, "api-key")
+	if err != nil {
+		return config, fmt.Errorf("unable to get api-key from secret `%s`: %w", secretRef, err)
+	}
+
+	// the secondary key is optional and only used while rotating keys
+	apiKeyNext, _ := stringFromSecretData(data, "api-key-next")
+
+	redact.Register(username, apiKey, apiKeyNext)
+
+	ao := goraxauth.AuthOptions{
+		AuthOptions: tokens2.AuthOptions{
+			IdentityEndpoint: c.identityEndpoint(),
+			Username:         username,
+		},
+		ApiKey: apiKey,
+	}
+
+	config.AuthOptions = ao
+	config.SecondaryApiKey = apiKeyNext
+	config.SecretRef = secretRef
+	config.Transport = c.rackspaceTransport()
+	config.UserAgent = c.userAgent()
+
+	return config, nil
+}
+
+// connect logs in to the DNS provider of an account.
+func (c *Solver) connect(ctx context.Context, account Account) (dnsprovider.Provider, error) {
+	if c.NewProvider != nil {
+		return c.NewProvider(ctx, account)
+	}
+
+	switch account.Provider {
+	case ProviderDesignate:
+		return designate.Connect(ctx, account.Designate)
+	default:
+		return rackspace.Connect(ctx, account.Rackspace)
+	}
+}
+
+// loadAccountDomainId goes through the accounts in order and returns the
+// first one that actually hosts the domain.
+func (c *Solver) loadAccountDomainId(ctx context.Context, accounts []Account, domainName string) (dnsprovider.Provider, Account, string, error) {
+	var errs []error
+	for _, account := range accounts {
+		provider, err := c.provider(ctx, account)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("unable to authenticate to %s: %w", account.Provider, err))
+			continue
+		}
+
+		klog.Infof("Configured %s DNS client", account.Provider)
+
+		domId, err := c.findZone(ctx, provider, account, domainName)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("account from secret `%s`: %w", account.SecretRef, err))
+			continue
+		}
+
+		return provider, account, domId, nil
+	}
+
+	return nil, Account{}, "", errors.Join(errs...)
+}
+
+// normalizeName puts a DNS name in the form used for every provider call.
+// Rackspace will create any case but reply back with lower case, it doesn't
+// like trailing dots so make our calls consistent on create and lookup/delete.
+func normalizeName(name string) string {
+	return strings.ToLower(strings.TrimRight(name, "."))
+}
+
+// errRecordNotFound is returned by loadRecordId when the challenge's record
+// is not there.
+var errRecordNotFound = errors.New("failed to find DNS record")
+
+// loadRecordId finds the single TXT record of this cluster holding the
+// challenge's key.
+func (c *Solver) loadRecordId(ctx context.Context, provider dnsprovider.Provider, domId string, ch *v1alpha1.ChallengeRequest) (string, error) {
+	fqdn := normalizeName(ch.ResolvedFQDN)
+
+	matched, err := provider.FindTXTRecords(ctx, domId, fqdn, ch.Key)
+	if err != nil {
+		return "", err
+	}
+
+	// another cluster solving the same name may hold the same key
+	var found []dnsprovider.Record
+	for _, record := range matched {
+		if c.owns(record) {
+			found = append(found, record)
+		}
+	}
+	if len(found) == 0 && len(matched) > 0 {
+		return "", fmt.Errorf("%w `%s` owned by this cluster, leaving %d of other clusters alone", errRecordNotFound, ch.ResolvedFQDN, len(matched))
+	}
+
+	if len(found) > 1 {
+		return "", fmt.Errorf("multiple records matched `%s`, manual cleanup required. count %d", ch.ResolvedFQDN, len(found))
+	}
+
+	if len(found) == 0 {
+		return "", fmt.Errorf("%w `%s`", errRecordNotFound, ch.ResolvedFQDN)
+	}
+
+	return found[0].ID, nil
+}
@@ -0,0 +1,865 @@
+package solver
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cert-manager/cert-manager/pkg/acme/webhook/apis/acme/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	extapi "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/rackerlabs/cert-manager-webhook-rackspace/pkg/dnsprovider"
+	"github.com/rackerlabs/cert-manager-webhook-rackspace/pkg/rackspacetest"
+)
+
+const testNamespace = "default"
+
+// testEnv is a solver wired to a fake Rackspace API holding `example.com`
+// in the account of the `creds` Secret.
+type testEnv struct {
+	srv    *rackspacetest.Server
+	tenant string
+	domID  string
+	solver *Solver
+}
+
+func newTestEnv(t *testing.T, objects ...runtime.Object) *testEnv {
+	t.Helper()
+
+	srv := rackspacetest.NewServer()
+	t.Cleanup(srv.Close)
+
+	tenant := srv.AddAccount("user", "api-key")
+	domID := srv.AddDomain(tenant, "example.com")
+
+	objects = append([]runtime.Object{credsSecret("creds", map[string]string{
+		"username": "user",
+		"api-key":  "api-key",
+	})}, objects...)
+
+	env := &testEnv{
+		srv:    srv,
+		tenant: tenant,
+		domID:  domID,
+		solver: &Solver{
+			UserAgent:        DefaultUserAgent + "/test",
+			IdentityEndpoint: srv.IdentityEndpoint(),
+			Client:           fake.NewSimpleClientset(objects...),
+		},
+	}
+	// stops the cleanup retries a test left queued
+	t.Cleanup(env.solver.Shutdown)
+
+	return env
+}
+
+func (e *testEnv) provider(t *testing.T) dnsprovider.Provider {
+	t.Helper()
+
+	cfgs, err := clientConfig(context.Background(), e.solver, challenge("_acme-challenge.example.com.", "key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	provider, err := e.solver.provider(context.Background(), cfgs[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return provider
+}
+
+func credsSecret(name string, data map[string]string) *corev1.Secret {
+	sec := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: testNamespace},
+		Data:       make(map[string][]byte),
+	}
+	for k, v := range data {
+		sec.Data[k] = []byte(v)
+	}
+	return sec
+}
+
+func challenge(fqdn string, key string) *v1alpha1.ChallengeRequest {
+	return challengeWithConfig(fqdn, key, `{"authSecretRef":"creds"}`)
+}
+
+func challengeWithConfig(fqdn string, key string, config string) *v1alpha1.ChallengeRequest {
+	return &v1alpha1.ChallengeRequest{
+		ResourceNamespace: testNamespace,
+		ResolvedZone:      "example.com.",
+		ResolvedFQDN:      fqdn,
+		Key:               key,
+		Config:            &extapi.JSON{Raw: []byte(config)},
+	}
+}
+
+// eventually fails the test if cond does not hold within a few seconds.
+func eventually(t *testing.T, cond func() bool, msg string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting: %s", msg)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// sameElements compares got and want ignoring order.
+func sameElements(got []string, want []string) bool {
+	got, want = slices.Clone(got), slices.Clone(want)
+	slices.Sort(got)
+	slices.Sort(want)
+	return slices.Equal(got, want)
+}
+
+// presentedRecords returns the keys of the records s remembers presenting.
+func presentedRecords(s *Solver) []string {
+	s.presentedMu.Lock()
+	defer s.presentedMu.Unlock()
+
+	keys := make([]string, 0, len(s.presented))
+	for key := range s.presented {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+func TestLoadConfig(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		cfgJSON *extapi.JSON
+		want    Config
+		wantErr string
+	}{
+		{
+			name: "no config",
+		},
+		{
+			name:    "full config",
+			cfgJSON: &extapi.JSON{Raw: []byte(`{"domainName":"example.com","authSecretRef":"creds","routes":[{"zone":"example.org","authSecretRefs":["a","b"]}]}`)},
+			want: Config{
+				DomainName:    "example.com",
+				AuthSecretRef: "creds",
+				Routes:        []ZoneRoute{{Zone: "example.org", AuthSecretRefs: []string{"a", "b"}}},
+			},
+		},
+		{
+			name:    "invalid json",
+			cfgJSON: &extapi.JSON{Raw: []byte(`{"authSecretRef":`)},
+			wantErr: "error decoding solver config",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := loadConfig(tc.cfgJSON)
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Errorf("expected error %q, got %v", tc.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("got %+v, want %+v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestClientConfig(t *testing.T) {
+	labelled := credsSecret("labelled", map[string]string{"username": "user", "api-key": "api-key"})
+	labelled.Labels = map[string]string{"rackspace": "default"}
+
+	for _, tc := range []struct {
+		name         string
+		secrets      []runtime.Object
+		config       string
+		zone         string
+		defaultName  string
+		selector     string
+		wantRefs     []string
+		wantNextKey  string
+		wantProvider string
+		wantErr      string
+	}{
+		{
+			name:     "single secret",
+			config:   `{"authSecretRef":"creds"}`,
+			wantRefs: []string{"default/creds"},
+		},
+		{
+			name: "secondary api key",
+			secrets: []runtime.Object{credsSecret("rotating", map[string]string{
+				"username": "user", "api-key": "old", "api-key-next": "new",
+			})},
+			config:      `{"authSecretRef":"rotating"}`,
+			wantRefs:    []string{"default/rotating"},
+			wantNextKey: "new",
+		},
+		{
+			name:    "missing secret",
+			config:  `{"authSecretRef":"missing"}`,
+			wantErr: "unable to get secret `default/missing`",
+		},
+		{
+			name:    "missing username",
+			secrets: []runtime.Object{credsSecret("nouser", map[string]string{"api-key": "api-key"})},
+			config:  `{"authSecretRef":"nouser"}`,
+			wantErr: "unable to get username from secret `default/nouser`",
+		},
+		{
+			name:    "missing api-key",
+			secrets: []runtime.Object{credsSecret("nokey", map[string]string{"username": "user"})},
+			config:  `{"authSecretRef":"nokey"}`,
+			wantErr: "unable to get api-key from secret `default/nokey`",
+		},
+		{
+			name:     "ordered accounts",
+			secrets:  []runtime.Object{credsSecret("second", map[string]string{"username": "u2", "api-key": "k2"})},
+			config:   `{"authSecretRefs":["second","creds"]}`,
+			wantRefs: []string{"default/second", "default/creds"},
+		},
+		{
+			name:     "route by zone suffix",
+			secrets:  []runtime.Object{credsSecret("routed", map[string]string{"username": "u2", "api-key": "k2"})},
+			config:   `{"authSecretRef":"creds","routes":[{"zone":"example.com","authSecretRef":"routed"}]}`,
+			zone:     "sub.Example.com.",
+			wantRefs: []string{"default/routed"},
+		},
+		{
+			name:     "route falls back to default",
+			config:   `{"authSecretRef":"creds","routes":[{"zone":"*.example.org","authSecretRef":"routed"}]}`,
+			wantRefs: []string{"default/creds"},
+		},
+		{
+			name: "designate password",
+			secrets: []runtime.Object{credsSecret("openstack", map[string]string{
+				"auth-url": "https://keystone.example.com/v3", "username": "u2", "password": "p2", "project-name": "dns",
+			})},
+			config:       `{"provider":"designate","authSecretRef":"openstack"}`,
+			wantRefs:     []string{"default/openstack"},
+			wantProvider: ProviderDesignate,
+		},
+		{
+			name: "designate application credential",
+			secrets: []runtime.Object{credsSecret("openstack", map[string]string{
+				"auth-url": "https://keystone.example.com/v3", "application-credential-id": "id", "application-credential-secret": "secret",
+			})},
+			config:       `{"provider":"designate","authSecretRef":"openstack"}`,
+			wantRefs:     []string{"default/openstack"},
+			wantProvider: ProviderDesignate,
+		},
+		{
+			name: "designate without project",
+			secrets: []runtime.Object{credsSecret("openstack", map[string]string{
+				"auth-url": "https://keystone.example.com/v3", "username": "u2", "password": "p2",
+			})},
+			config:  `{"provider":"designate","authSecretRef":"openstack"}`,
+			wantErr: "unable to get project-id or project-name from secret `default/openstack`",
+		},
+		{
+			name:    "designate without auth-url",
+			config:  `{"provider":"designate","authSecretRef":"creds"}`,
+			wantErr: "unable to get auth-url from secret `default/creds`",
+		},
+		{
+			name: "route to designate",
+			secrets: []runtime.Object{credsSecret("openstack", map[string]string{
+				"auth-url": "https://keystone.example.com/v3", "application-credential-id": "id", "application-credential-secret": "secret",
+			})},
+			config:       `{"authSecretRef":"creds","routes":[{"zone":"example.com","provider":"designate","authSecretRef":"openstack"}]}`,
+			wantRefs:     []string{"default/openstack"},
+			wantProvider: ProviderDesignate,
+		},
+		{
+			name:    "unknown provider",
+			config:  `{"provider":"route53","authSecretRef":"creds"}`,
+			wantErr: "unknown provider `route53`",
+		},
+		{
+			name:        "default secret name",
+			config:      `{}`,
+			defaultName: "creds",
+			wantRefs:    []string{"default/creds"},
+		},
+		{
+			name:     "default secret by label",
+			secrets:  []runtime.Object{labelled},
+			config:   `{}`,
+			selector: "rackspace=default",
+			wantRefs: []string{"default/labelled"},
+		},
+		{
+			name:        "no default secret",
+			config:      `{}`,
+			defaultName: "absent",
+			selector:    "rackspace=default",
+			wantErr:     "looked for secret `default/absent` and secrets in `default` labelled `rackspace=default`",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			env := newTestEnv(t, tc.secrets...)
+			env.solver.DefaultSecretName = tc.defaultName
+			env.solver.DefaultSecretSelector = tc.selector
+
+			ch := challengeWithConfig("_acme-challenge.example.com.", "key", tc.config)
+			if tc.zone != "" {
+				ch.ResolvedZone = tc.zone
+			}
+
+			cfgs, err := clientConfig(context.Background(), env.solver, ch)
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Errorf("expected error %q, got %v", tc.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var refs []string
+			for _, cfg := range cfgs {
+				refs = append(refs, cfg.SecretRef)
+			}
+			if !slices.Equal(refs, tc.wantRefs) {
+				t.Errorf("refs = %v, want %v", refs, tc.wantRefs)
+			}
+			if got := cfgs[0].Rackspace.SecondaryApiKey; got != tc.wantNextKey {
+				t.Errorf("cfgs[0].Rackspace.SecondaryApiKey = %v, want %v", got, tc.wantNextKey)
+			}
+
+			if tc.wantProvider == "" {
+				tc.wantProvider = ProviderRackspace
+			}
+			if got := cfgs[0].Provider; got != tc.wantProvider {
+				t.Errorf("cfgs[0].Provider = %v, want %v", got, tc.wantProvider)
+			}
+		})
+	}
+}
+
+func TestLoadRecordId(t *testing.T) {
+	const name = "_acme-challenge.example.com"
+
+	for _, tc := range []struct {
+		name    string
+		records []rackspacetest.Record
+		fqdn    string
+		wantErr string
+	}{
+		{
+			name: "found",
+			records: []rackspacetest.Record{
+				{Name: name, Type: "TXT", Data: "key"},
+				{Name: name, Type: "TXT", Data: "other"},
+			},
+			fqdn: "_acme-challenge.Example.com.",
+		},
+		{
+			name:    "not found",
+			records: []rackspacetest.Record{{Name: name, Type: "TXT", Data: "other"}},
+			fqdn:    "_acme-challenge.example.com.",
+			wantErr: "failed to find DNS record `_acme-challenge.example.com.`",
+		},
+		{
+			name: "duplicate records",
+			records: []rackspacetest.Record{
+				{Name: name, Type: "TXT", Data: "key"},
+				{Name: name, Type: "TXT", Data: "key"},
+			},
+			fqdn:    "_acme-challenge.example.com.",
+			wantErr: "multiple records matched",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			env := newTestEnv(t)
+
+			var wantID string
+			for _, r := range tc.records {
+				r.DomainID = env.domID
+				id := env.srv.AddRecord(r)
+				if r.Data == "key" {
+					wantID = id
+				}
+			}
+
+			recordId, err := env.solver.loadRecordId(context.Background(), env.provider(t), env.domID, challenge(tc.fqdn, "key"))
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Errorf("expected error %q, got %v", tc.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if recordId != wantID {
+				t.Errorf("recordId = %v, want %v", recordId, wantID)
+			}
+		})
+	}
+}
+
+func TestPresent(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		fqdn     string
+		zone     string
+		jobError string
+		wantName string
+		wantErr  string
+	}{
+		{
+			name:     "creates record",
+			fqdn:     "_acme-challenge.example.com.",
+			wantName: "_acme-challenge.example.com",
+		},
+		{
+			name:     "normalizes name",
+			fqdn:     "_acme-challenge.WWW.Example.COM.",
+			wantName: "_acme-challenge.www.example.com",
+		},
+		{
+			name:    "unknown domain",
+			fqdn:    "_acme-challenge.example.org.",
+			zone:    "example.org.",
+			wantErr: "unable to find domain ID for domain `example.org.`",
+		},
+		{
+			name:     "create job fails",
+			fqdn:     "_acme-challenge.example.com.",
+			jobError: "Domain is locked",
+			wantErr:  "Domain is locked",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			env := newTestEnv(t)
+			env.srv.SetJobError(tc.jobError)
+
+			ch := challenge(tc.fqdn, "key")
+			if tc.zone != "" {
+				ch.ResolvedZone = tc.zone
+			}
+
+			err := env.solver.Present(ch)
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Errorf("expected error %q, got %v", tc.wantErr, err)
+				}
+				if got := env.srv.Records(env.domID); len(got) != 0 {
+					t.Errorf("expected none, got %v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			records := env.srv.Records(env.domID)
+			if len(records) != 1 {
+				t.Fatalf("expected 1, got %v", records)
+			}
+			if got := records[0].Name; got != tc.wantName {
+				t.Errorf("records[0].Name = %v, want %v", got, tc.wantName)
+			}
+			if got := records[0].Type; got != "TXT" {
+				t.Errorf("records[0].Type = %v, want %v", got, "TXT")
+			}
+			if got := records[0].Data; got != "key" {
+				t.Errorf("records[0].Data = %v, want %v", got, "key")
+			}
+			if got := records[0].Comment; !strings.Contains(got, DefaultUserAgent) {
+				t.Errorf("expected %q in %q", DefaultUserAgent, got)
+			}
+		})
+	}
+}
+
+func TestPresentAgain(t *testing.T) {
+	env := newTestEnv(t)
+	env.solver.ClusterID = "blue"
+
+	// the same key presented by another cluster is left to it
+	addTXT(env, "_acme-challenge.example.com", "key", "created by "+DefaultUserAgent+" in cluster green", 0)
+
+	ch := challenge("_acme-challenge.example.com.", "key")
+	for range 3 {
+		if err := env.solver.Present(ch); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := env.srv.Records(env.domID); len(got) != 2 {
+		t.Fatalf("presenting again created another record: expected 2, got %v", got)
+	}
+
+	if err := env.solver.CleanUp(ch); err != nil {
+		t.Fatal(err)
+	}
+	records := env.srv.Records(env.domID)
+	if len(records) != 1 {
+		t.Fatalf("expected 1, got %v", records)
+	}
+	if got := records[0].Comment; !strings.HasSuffix(got, "in cluster green") {
+		t.Errorf("left %q, want the record of the other cluster", got)
+	}
+}
+
+func TestCleanUp(t *testing.T) {
+	const name = "_acme-challenge.example.com"
+
+	for _, tc := range []struct {
+		name     string
+		records  []rackspacetest.Record
+		fault    *rackspacetest.Fault
+		jobError string
+		wantLeft []string
+		wantErr  string
+	}{
+		{
+			name: "deletes only the matching key",
+			records: []rackspacetest.Record{
+				{Name: name, Type: "TXT", Data: "key"},
+				{Name: name, Type: "TXT", Data: "other"},
+			},
+			wantLeft: []string{"other"},
+		},
+		{
+			name:     "missing record",
+			records:  []rackspacetest.Record{{Name: name, Type: "TXT", Data: "other"}},
+			wantLeft: []string{"other"},
+			wantErr:  "unable to find DNS record",
+		},
+		{
+			name: "duplicate records",
+			records: []rackspacetest.Record{
+				{Name: name, Type: "TXT", Data: "key"},
+				{Name: name, Type: "TXT", Data: "key"},
+			},
+			wantLeft: []string{"key", "key"},
+			wantErr:  "multiple records matched",
+		},
+		{
+			name:     "delete request fails",
+			records:  []rackspacetest.Record{{Name: name, Type: "TXT", Data: "key"}},
+			fault:    &rackspacetest.Fault{Method: http.MethodDelete, Status: http.StatusInternalServerError},
+			wantLeft: []string{"key"},
+			wantErr:  "unable to delete DNS record",
+		},
+		{
+			name:     "delete job fails",
+			records:  []rackspacetest.Record{{Name: name, Type: "TXT", Data: "key"}},
+			jobError: "Domain is locked",
+			wantLeft: []string{"key"},
+			wantErr:  "Domain is locked",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			env := newTestEnv(t)
+			for _, r := range tc.records {
+				r.DomainID = env.domID
+				env.srv.AddRecord(r)
+			}
+			if tc.fault != nil {
+				env.srv.AddFault(*tc.fault)
+			}
+			env.srv.SetJobError(tc.jobError)
+
+			err := env.solver.CleanUp(challenge("_acme-challenge.Example.com.", "key"))
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Errorf("expected error %q, got %v", tc.wantErr, err)
+				}
+			} else {
+				if err != nil {
+					t.Error(err)
+				}
+			}
+
+			if got := env.srv.TXTRecords(name); !slices.Equal(got, tc.wantLeft) {
+				t.Errorf("env.srv.TXTRecords(name) = %v, want %v", got, tc.wantLeft)
+			}
+		})
+	}
+}
+
+func TestPresentFailsOver(t *testing.T) {
+	env := newTestEnv(t,
+		credsSecret("empty", map[string]string{"username": "migrated", "api-key": "empty-key"}),
+		credsSecret("revoked", map[string]string{"username": "user", "api-key": "revoked-key"}),
+	)
+	// an account that does not host the domain
+	env.srv.AddAccount("migrated", "empty-key")
+
+	ch := challengeWithConfig("_acme-challenge.example.com.", "key", `{"authSecretRefs":["empty","revoked","creds"]}`)
+	if err := env.solver.Present(ch); err != nil {
+		t.Fatal(err)
+	}
+	if got := env.srv.TXTRecords("_acme-challenge.example.com"); len(got) != 1 {
+		t.Fatalf("expected the record in the account hosting the domain, got %v", got)
+	}
+	if stored := env.solver.storedRecord(context.Background(), ch); stored.SecretRef != testNamespace+"/creds" {
+		t.Errorf("remembered the record in %q", stored.SecretRef)
+	}
+
+	// no account hosts it
+	ch = challengeWithConfig("_acme-challenge.example.com.", "key", `{"authSecretRefs":["empty","revoked"]}`)
+	err := env.solver.Present(ch)
+	for _, want := range []string{
+		"`default/empty`: failed to find domain `example.com`",
+		"unable to authenticate to rackspace",
+	} {
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("expected %q in %v", want, err)
+		}
+	}
+}
+
+func TestCleanUpUsesPresentAccount(t *testing.T) {
+	env := newTestEnv(t)
+
+	// the domain also exists in a second account during a migration
+	other := env.srv.AddAccount("other", "other-key")
+	otherDomID := env.srv.AddDomain(other, "example.com")
+
+	client := env.solver.Client.(*fake.Clientset)
+	_, err := client.CoreV1().Secrets(testNamespace).Create(context.Background(),
+		credsSecret("other", map[string]string{"username": "other", "api-key": "other-key"}), metav1.CreateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ch := challengeWithConfig("_acme-challenge.example.com.", "key", `{"authSecretRefs":["other","creds"]}`)
+
+	if err := env.solver.Present(ch); err != nil {
+		t.Fatal(err)
+	}
+	if got := env.srv.Records(otherDomID); len(got) != 1 {
+		t.Errorf("expected 1, got %v", got)
+	}
+	if got := env.srv.Records(env.domID); len(got) != 0 {
+		t.Errorf("expected none, got %v", got)
+	}
+
+	// a record with the same name and key in the other account must survive
+	env.srv.AddRecord(rackspacetest.Record{DomainID: env.domID, Name: "_acme-challenge.example.com", Type: "TXT", Data: "key"})
+
+	reordered := challengeWithConfig("_acme-challenge.example.com.", "key", `{"authSecretRefs":["creds","other"]}`)
+	if err := env.solver.CleanUp(reordered); err != nil {
+		t.Fatal(err)
+	}
+	if got := env.srv.Records(otherDomID); len(got) != 0 {
+		t.Errorf("expected none, got %v", got)
+	}
+	if got := env.srv.Records(env.domID); len(got) != 1 {
+		t.Errorf("expected 1, got %v", got)
+	}
+}
+
+func TestCleanUpByStoredID(t *testing.T) {
+	env := newTestEnv(t)
+
+	ch := challenge("_acme-challenge.example.com.", "key")
+	if err := env.solver.Present(ch); err != nil {
+		t.Fatal(err)
+	}
+
+	// the same name and key in another record must survive
+	env.srv.AddRecord(rackspacetest.Record{DomainID: env.domID, Name: "_acme-challenge.example.com", Type: "TXT", Data: "key"})
+	env.srv.ResetRequests()
+
+	if err := env.solver.CleanUp(ch); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := env.srv.TXTRecords("_acme-challenge.example.com"), []string{"key"}; !slices.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	requests := env.srv.Requests()
+	if got := requests["DELETE /v1.0/{tenant}/domains/{domain}/records/{record}"]; got != 1 {
+		t.Errorf("got %v, want %v", got, 1)
+	}
+	if got := requests["GET /v1.0/{tenant}/domains"]; got != 0 {
+		t.Errorf("the domain is not looked up: got %v", got)
+	}
+	if got := requests["GET /v1.0/{tenant}/domains/{domain}/records"]; got != 0 {
+		t.Errorf("the record is not searched for: got %v", got)
+	}
+}
+
+func TestCleanUpStoredIDGone(t *testing.T) {
+	env := newTestEnv(t)
+
+	ch := challenge("_acme-challenge.example.com.", "key")
+	if err := env.solver.Present(ch); err != nil {
+		t.Fatal(err)
+	}
+
+	// someone deleted the record and created it again
+	records := env.srv.Records(env.domID)
+	if len(records) != 1 {
+		t.Fatalf("expected 1, got %v", records)
+	}
+	if err := env.provider(t).DeleteRecord(context.Background(), env.domID, records[0].ID); err != nil {
+		t.Fatal(err)
+	}
+	env.srv.AddRecord(rackspacetest.Record{DomainID: env.domID, Name: "_acme-challenge.example.com", Type: "TXT", Data: "key"})
+
+	if err := env.solver.CleanUp(ch); err != nil {
+		t.Fatal(err)
+	}
+	if got := env.srv.Records(env.domID); len(got) != 0 {
+		t.Errorf("expected none, got %v", got)
+	}
+}
+
+func TestPresentedRecordsDrain(t *testing.T) {
+	env := newTestEnv(t)
+
+	old := challenge("_acme-challenge.example.com.", "old")
+	if err := env.solver.Present(old); err != nil {
+		t.Fatal(err)
+	}
+
+	// cert-manager deleted the challenge without cleaning it up
+	env.solver.presentedMu.Lock()
+	record := env.solver.presented[accountKey(old)]
+	record.remembered = record.remembered.Add(-presentedRecordTTL - time.Minute)
+	env.solver.presented[accountKey(old)] = record
+	env.solver.presentedMu.Unlock()
+
+	ch := challenge("_acme-challenge.www.example.com.", "key")
+	if err := env.solver.Present(ch); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := presentedRecords(env.solver), []string{accountKey(ch)}; !slices.Equal(got, want) {
+		t.Errorf("remembers %v, want %v", got, want)
+	}
+
+	if err := env.solver.CleanUp(ch); err != nil {
+		t.Fatal(err)
+	}
+	if got := presentedRecords(env.solver); len(got) != 0 {
+		t.Errorf("remembers %v after cleaning up", got)
+	}
+
+	// the forgotten record is still found and cleaned up
+	if err := env.solver.CleanUp(old); err != nil {
+		t.Fatal(err)
+	}
+	if got := env.srv.Records(env.domID); len(got) != 0 {
+		t.Errorf("expected none, got %v", got)
+	}
+}
+
+func TestCleanUpByIntentID(t *testing.T) {
+	env := newTestEnv(t)
+	env.solver.IntentNamespace = intentNamespace
+
+	ch := challenge("_acme-challenge.example.com.", "key")
+	if err := env.solver.Present(ch); err != nil {
+		t.Fatal(err)
+	}
+
+	// another replica, or this one after a restart, reads the ID from the
+	// intent
+	s := restarted(env)
+	env.srv.ResetRequests()
+
+	if err := s.CleanUp(ch); err != nil {
+		t.Fatal(err)
+	}
+	if got := env.srv.Records(env.domID); len(got) != 0 {
+		t.Errorf("expected none, got %v", got)
+	}
+	if got := env.srv.Requests()["GET /v1.0/{tenant}/domains/{domain}/records"]; got != 0 {
+		t.Errorf("the record is not searched for: got %v", got)
+	}
+	if got := intents(t, s); len(got) != 0 {
+		t.Errorf("expected none, got %v", got)
+	}
+}
+
+// memoryProvider is a dnsprovider.Provider holding a single zone in memory.
+type memoryProvider struct {
+	zone    string
+	records map[string]dnsprovider.Record
+	nextID  int
+}
+
+func (p *memoryProvider) FindZone(_ context.Context, zone string) (string, error) {
+	if zone != p.zone {
+		return "", fmt.Errorf("no zone `%s`", zone)
+	}
+	return "zone-1", nil
+}
+
+func (p *memoryProvider) CreateTXTRecord(_ context.Context, _ string, record dnsprovider.Record) (dnsprovider.Record, error) {
+	p.nextID++
+	record.ID = fmt.Sprintf("record-%d", p.nextID)
+	p.records[record.ID] = record
+	return record, nil
+}
+
+func (p *memoryProvider) FindTXTRecords(_ context.Context, _ string, name string, data string) ([]dnsprovider.Record, error) {
+	var found []dnsprovider.Record
+	for _, r := range p.records {
+		if r.Name == name && r.Data == data {
+			found = append(found, r)
+		}
+	}
+	return found, nil
+}
+
+func (p *memoryProvider) DeleteRecord(_ context.Context, _ string, recordID string) error {
+	delete(p.records, recordID)
+	return nil
+}
+
+func TestSwappedProvider(t *testing.T) {
+	provider := &memoryProvider{zone: "example.com", records: make(map[string]dnsprovider.Record)}
+
+	solver := &Solver{
+		Client: fake.NewSimpleClientset(credsSecret("creds", map[string]string{"username": "user", "api-key": "api-key"})),
+		NewProvider: func(context.Context, Account) (dnsprovider.Provider, error) {
+			return provider, nil
+		},
+	}
+
+	ch := challenge("_acme-challenge.Example.com.", "key")
+
+	if err := solver.Present(ch); err != nil {
+		t.Fatal(err)
+	}
+	if len(provider.records) != 1 {
+		t.Fatalf("expected 1, got %v", provider.records)
+	}
+	for _, r := range provider.records {
+		if r.Name != "_acme-challenge.example.com" {
+			t.Errorf("r.Name = %v, want %v", r.Name, "_acme-challenge.example.com")
+		}
+		if r.Comment != "created by "+DefaultUserAgent {
+			t.Errorf("r.Comment = %v, want %v", r.Comment, "created by "+DefaultUserAgent)
+		}
+	}
+
+	if err := solver.CleanUp(ch); err != nil {
+		t.Fatal(err)
+	}
+	if len(provider.records) != 0 {
+		t.Errorf("expected none, got %v", provider.records)
+	}
+}
@@ -0,0 +1,307 @@
+package solver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"slices"
+	"time"
+
+	coordinationv1 "k8s.io/api/coordination/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/component-base/metrics"
+	"k8s.io/component-base/metrics/legacyregistry"
+	"k8s.io/klog/v2"
+
+	"github.com/rackerlabs/cert-manager-webhook-rackspace/pkg/dnsprovider"
+)
+
+// DefaultSweepInterval is the time between sweeps when SweepConfig.Interval
+// is zero.
+const DefaultSweepInterval = time.Hour
+
+// DefaultSweepMaxAge is how old a record must be for a sweep to delete it
+// when SweepConfig.MaxAge is zero. Challenges are cleaned up long before.
+const DefaultSweepMaxAge = 24 * time.Hour
+
+// sweepLeaseName names the Lease held by the replica that sweeps.
+const sweepLeaseName = DefaultUserAgent + "-sweeper"
+
+var sweptRecords = metrics.NewCounterVec(&metrics.CounterOpts{
+	Namespace:      metricsNamespace,
+	Name:           "swept_records_total",
+	Help:           "Stale records found by the sweeper by zone and result, one of deleted, dry_run or failed.",
+	StabilityLevel: metrics.ALPHA,
+}, []string{"zone", "result"})
+
+func init() {
+	legacyregistry.MustRegister(sweptRecords)
+}
+
+// SweepConfig configures the periodic deletion of TXT records that the
+// solver created and that were never cleaned up.
+type SweepConfig struct {
+	// Namespace holds the credentials Secrets of the zones and the Lease
+	// electing the replica that sweeps. Without it every replica sweeps.
+	Namespace string `json:"namespace,omitempty"`
+	// Interval is the time between sweeps, DefaultSweepInterval when zero.
+	Interval metav1.Duration `json:"interval,omitempty"`
+	// MaxAge is how old a record must be to be deleted, DefaultSweepMaxAge
+	// when zero.
+	MaxAge metav1.Duration `json:"maxAge,omitempty"`
+	// DryRun only logs the records that would be deleted.
+	DryRun bool `json:"dryRun,omitempty"`
+	// Zones are the zones to sweep.
+	Zones []SweepZone `json:"zones"`
+}
+
+// SweepZone is a zone to sweep and the account hosting it.
+type SweepZone struct {
+	Zone string `json:"zone"`
+	// Provider is ProviderRackspace when empty.
+	Provider string `json:"provider,omitempty"`
+	// AuthSecretRef names the account's credentials Secret in
+	// SweepConfig.Namespace, Solver.DefaultSecretName when empty.
+	AuthSecretRef string         `json:"authSecretRef,omitempty"`
+	AuthMethod    string         `json:"authMethod,omitempty"`
+	Scope         *KeystoneScope `json:"scope,omitempty"`
+	// Allow limits the sweep to records whose name matches one of the
+	// patterns, and records matching one of Deny are never swept. Patterns
+	// are matched as by path.Match, such as `_acme-challenge.*.example.com`.
+	Allow []string `json:"allow,omitempty"`
+	Deny  []string `json:"deny,omitempty"`
+}
+
+func (s *SweepConfig) interval() time.Duration {
+	if s.Interval.Duration > 0 {
+		return s.Interval.Duration
+	}
+	return DefaultSweepInterval
+}
+
+func (s *SweepConfig) maxAge() time.Duration {
+	if s.MaxAge.Duration > 0 {
+		return s.MaxAge.Duration
+	}
+	return DefaultSweepMaxAge
+}
+
+// allows reports whether the allow and deny lists of the zone let a record
+// named name be swept.
+func (z SweepZone) allows(name string) bool {
+	if matchAny(z.Deny, name) {
+		return false
+	}
+	return len(z.Allow) == 0 || matchAny(z.Allow, name)
+}
+
+func matchAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(normalizeName(pattern), name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// validate rejects patterns that could never match, which would silently
+// turn a deny list off.
+func (z SweepZone) validate() error {
+	for _, pattern := range slices.Concat(z.Allow, z.Deny) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid pattern `%s`: %w", pattern, err)
+		}
+	}
+	return nil
+}
+
+// Sweep deletes once the stale records of the zones in Solver.Sweeper: TXT
+// records whose comment marks them as created by the solver for this cluster
+// and that are older than SweepConfig.MaxAge.
+func (c *Solver) Sweep(ctx context.Context) error {
+	if c.Sweeper == nil {
+		return nil
+	}
+
+	var errs []error
+	for _, zone := range c.Sweeper.Zones {
+		if err := c.sweepZone(ctx, zone); err != nil {
+			errs = append(errs, fmt.Errorf("unable to sweep zone `%s`: %w", zone.Zone, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func (c *Solver) sweepZone(ctx context.Context, zone SweepZone) error {
+	if err := zone.validate(); err != nil {
+		return err
+	}
+
+	providerName := zone.Provider
+	if providerName == "" {
+		providerName = ProviderRackspace
+	}
+	secretName := zone.AuthSecretRef
+	if secretName == "" {
+		secretName = c.DefaultSecretName
+	}
+	if secretName == "" {
+		return errors.New("no authSecretRef")
+	}
+
+	account, err := secretConfig(ctx, c, c.Sweeper.Namespace, secretName, providerName, Config{AuthMethod: zone.AuthMethod, Scope: zone.Scope})
+	if err != nil {
+		return err
+	}
+
+	provider, err := c.provider(ctx, account)
+	if err != nil {
+		return fmt.Errorf("unable to authenticate to %s: %w", account.Provider, err)
+	}
+
+	zoneName := normalizeName(zone.Zone)
+	zoneID, err := c.findZone(ctx, provider, account, zoneName)
+	if err != nil {
+		return err
+	}
+
+	lister, ok := provider.(dnsprovider.Lister)
+	if !ok {
+		return fmt.Errorf("%s does not support listing records", account.Provider)
+	}
+	records, err := lister.ListTXTRecords(ctx, zoneID)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	var errs []error
+	for _, record := range records {
+		if !c.stale(zone, record, now) {
+			continue
+		}
+		age := now.Sub(record.Created).Truncate(time.Second)
+
+		if c.Sweeper.DryRun {
+			klog.Infof("Would delete stale txt record %s created %s ago", record.Name, age)
+			sweptRecords.WithLabelValues(zoneName, "dry_run").Inc()
+			continue
+		}
+
+		if err := provider.DeleteRecord(ctx, zoneID, record.ID); err != nil {
+			errs = append(errs, fmt.Errorf("unable to delete stale record `%s`: %w", record.Name, err))
+			sweptRecords.WithLabelValues(zoneName, "failed").Inc()
+			continue
+		}
+		klog.Infof("Deleted stale txt record %s created %s ago", record.Name, age)
+		sweptRecords.WithLabelValues(zoneName, "deleted").Inc()
+	}
+
+	return errors.Join(errs...)
+}
+
+// stale reports whether a sweep of the zone deletes the record.
+func (c *Solver) stale(zone SweepZone, record dnsprovider.Record, now time.Time) bool {
+	if record.Created.IsZero() || now.Sub(record.Created) < c.Sweeper.maxAge() {
+		return false
+	}
+
+	if _, ok := c.commentOwner(record.Comment); !ok || !c.owns(record) {
+		return false
+	}
+
+	return zone.allows(normalizeName(record.Name))
+}
+
+// runSweeper sweeps every SweepConfig.Interval until ctx ends. Initialize
+// runs it when Solver.Sweeper is set.
+func (c *Solver) runSweeper(ctx context.Context) {
+	var election *sweepElection
+	if c.Sweeper.Namespace != "" {
+		election = &sweepElection{lock: &leaseLock{
+			client: c.Client.CoordinationV1().Leases(c.Sweeper.Namespace),
+			name:   sweepLeaseName,
+			holder: c.leaseHolder(),
+			// renewed before every sweep, a sweep running late must not
+			// let another replica take over
+			duration: 2 * c.Sweeper.interval(),
+		}}
+		defer election.resign()
+	}
+
+	ticker := time.NewTicker(c.Sweeper.interval())
+	defer ticker.Stop()
+
+	for {
+		if election.elect(ctx) {
+			if err := c.Sweep(ctx); err != nil {
+				klog.Warningf("Unable to sweep stale records: %v", err)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// sweepElection elects the replica that sweeps through a Lease, which the
+// replica keeps for as long as it renews it before every sweep.
+type sweepElection struct {
+	lock *leaseLock
+	// lease is nil unless this replica holds it
+	lease *coordinationv1.Lease
+}
+
+// elect renews the Lease or takes it over, and reports whether this replica
+// sweeps. Without an election every replica does.
+func (e *sweepElection) elect(ctx context.Context) bool {
+	if e == nil {
+		return true
+	}
+
+	if e.lease != nil {
+		next := e.lease.DeepCopy()
+		now := metav1.NowMicro()
+		next.Spec.RenewTime = &now
+
+		updated, err := e.lock.client.Update(ctx, next, metav1.UpdateOptions{})
+		if err == nil {
+			e.lease = updated
+			return true
+		}
+		if !apierrors.IsConflict(err) && !apierrors.IsNotFound(err) {
+			klog.Warningf("Unable to renew lease %s: %v", e.lock.name, err)
+			return false
+		}
+		// it expired and another replica took it over
+		e.lease = nil
+	}
+
+	lease, holder, err := e.lock.tryAcquire(ctx)
+	if err != nil {
+		klog.Warningf("Unable to acquire lease %s: %v", e.lock.name, err)
+		return false
+	}
+	if lease == nil {
+		klog.V(4).Infof("Leaving the sweep to %s", holder)
+		return false
+	}
+
+	klog.Infof("Sweeping stale records as %s", e.lock.holder)
+	e.lease = lease
+	return true
+}
+
+// resign releases the Lease so that another replica sweeps right away.
+func (e *sweepElection) resign() {
+	if e.lease != nil {
+		e.lock.release(e.lease)
+		e.lease = nil
+	}
+}
@@ -0,0 +1,252 @@
+package solver
+
+import (
+	"context"
+	"slices"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/rackerlabs/cert-manager-webhook-rackspace/pkg/rackspacetest"
+)
+
+// addTXT adds a TXT record to example.com created age ago.
+func addTXT(env *testEnv, name string, data string, comment string, age time.Duration) {
+	env.srv.AddRecord(rackspacetest.Record{
+		DomainID: env.domID,
+		Name:     name,
+		Type:     "TXT",
+		Data:     data,
+		Comment:  comment,
+		Created:  time.Now().Add(-age),
+	})
+}
+
+// remaining lists the data of the TXT records left in example.com.
+func remaining(env *testEnv) []string {
+	var data []string
+	for _, r := range env.srv.Records(env.domID) {
+		if r.Type == "TXT" {
+			data = append(data, r.Data)
+		}
+	}
+	sort.Strings(data)
+	return data
+}
+
+func sweepConfig(zones ...SweepZone) *SweepConfig {
+	return &SweepConfig{
+		Namespace: testNamespace,
+		MaxAge:    metav1.Duration{Duration: time.Hour},
+		Zones:     zones,
+	}
+}
+
+func TestSweep(t *testing.T) {
+	env := newTestEnv(t)
+	env.solver.Sweeper = sweepConfig(SweepZone{
+		Zone:          "example.com",
+		AuthSecretRef: "creds",
+		Allow:         []string{"_acme-challenge.*"},
+		Deny:          []string{"_acme-challenge.prod.example.com"},
+	})
+
+	const marker = "created by " + DefaultUserAgent + "/0.9.0"
+
+	addTXT(env, "_acme-challenge.old.example.com", "stale", marker, 2*time.Hour)
+	addTXT(env, "_acme-challenge.other.example.com", "other-cluster", marker+" in cluster green", 2*time.Hour)
+	addTXT(env, "_acme-challenge.www.example.com", "recent", marker, 10*time.Minute)
+	addTXT(env, "_acme-challenge.manual.example.com", "unmarked", "", 2*time.Hour)
+	addTXT(env, "_acme-challenge.fork.example.com", "fork", "created by "+DefaultUserAgent+"-fork/1.0", 2*time.Hour)
+	addTXT(env, "_acme-challenge.prod.example.com", "denied", marker, 2*time.Hour)
+	addTXT(env, "verify.example.com", "not-allowed", marker, 2*time.Hour)
+
+	if err := env.solver.Sweep(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// without a cluster ID, records of clusters with one are not this one's
+	if got, want := remaining(env), []string{"denied", "fork", "not-allowed", "other-cluster", "recent", "unmarked"}; !slices.Equal(got, want) {
+		t.Errorf("remaining(env) = %v, want %v", got, want)
+	}
+}
+
+func TestSweepDryRun(t *testing.T) {
+	env := newTestEnv(t)
+	env.solver.Sweeper = sweepConfig(SweepZone{Zone: "example.com", AuthSecretRef: "creds"})
+	env.solver.Sweeper.DryRun = true
+
+	const sample = `cert_manager_webhook_rackspace_swept_records_total{result="dry_run",zone="example.com"}`
+	before := metricValue(t, env.solver, sample)
+
+	addTXT(env, "_acme-challenge.example.com", "stale", "created by "+DefaultUserAgent+"/0.9.0", 2*time.Hour)
+
+	if err := env.solver.Sweep(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := remaining(env), []string{"stale"}; !slices.Equal(got, want) {
+		t.Errorf("remaining(env) = %v, want %v", got, want)
+	}
+	if got := metricValue(t, env.solver, sample); got != before+1 {
+		t.Errorf("swept records = %v, want %v", got, before+1)
+	}
+}
+
+func TestSweepOwnership(t *testing.T) {
+	env := newTestEnv(t)
+	env.solver.ClusterID = "blue"
+	env.solver.Sweeper = sweepConfig(SweepZone{Zone: "example.com", AuthSecretRef: "creds"})
+
+	const marker = "created by " + DefaultUserAgent + "/0.9.0"
+
+	addTXT(env, "_acme-challenge.blue.example.com", "blue", marker+" in cluster blue", 2*time.Hour)
+	addTXT(env, "_acme-challenge.green.example.com", "green", marker+" in cluster green", 2*time.Hour)
+	addTXT(env, "_acme-challenge.legacy.example.com", "legacy", marker, 2*time.Hour)
+
+	if err := env.solver.Sweep(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := remaining(env), []string{"green", "legacy"}; !slices.Equal(got, want) {
+		t.Errorf("remaining(env) = %v, want %v", got, want)
+	}
+
+	env.solver.AdoptLegacyRecords = true
+	if err := env.solver.Sweep(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := remaining(env), []string{"green"}; !slices.Equal(got, want) {
+		t.Errorf("remaining(env) = %v, want %v", got, want)
+	}
+}
+
+func TestSweepErrors(t *testing.T) {
+	env := newTestEnv(t)
+	env.solver.Sweeper = sweepConfig(
+		SweepZone{Zone: "example.org", AuthSecretRef: "creds"},
+		SweepZone{Zone: "example.com", AuthSecretRef: "creds", Deny: []string{"[_acme-challenge"}},
+		SweepZone{Zone: "example.com", AuthSecretRef: "missing"},
+		SweepZone{Zone: "example.com"},
+	)
+	addTXT(env, "_acme-challenge.example.com", "stale", "created by "+DefaultUserAgent+"/0.9.0", 2*time.Hour)
+
+	err := env.solver.Sweep(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "unable to sweep zone `example.org`: failed to find domain `example.org`") {
+		t.Errorf("expected error %q, got %v", "unable to sweep zone `example.org`: failed to find domain `example.org`", err)
+	}
+	if err == nil || !strings.Contains(err.Error(), "unable to sweep zone `example.com`: invalid pattern `[_acme-challenge`") {
+		t.Errorf("expected error %q, got %v", "unable to sweep zone `example.com`: invalid pattern `[_acme-challenge`", err)
+	}
+	if err == nil || !strings.Contains(err.Error(), "unable to get secret `default/missing`") {
+		t.Errorf("expected error %q, got %v", "unable to get secret `default/missing`", err)
+	}
+	if err == nil || !strings.Contains(err.Error(), "no authSecretRef") {
+		t.Errorf("expected error %q, got %v", "no authSecretRef", err)
+	}
+
+	// an invalid deny list never lets anything through
+	if got, want := remaining(env), []string{"stale"}; !slices.Equal(got, want) {
+		t.Errorf("remaining(env) = %v, want %v", got, want)
+	}
+}
+
+func TestSweepElection(t *testing.T) {
+	env := newTestEnv(t)
+
+	election := func(holder string, duration time.Duration) *sweepElection {
+		return &sweepElection{lock: &leaseLock{
+			client:   env.solver.Client.CoordinationV1().Leases(testNamespace),
+			name:     sweepLeaseName,
+			holder:   holder,
+			duration: duration,
+		}}
+	}
+	ctx := context.Background()
+
+	a := election("replica-a", time.Hour)
+	b := election("replica-b", time.Hour)
+
+	if !a.elect(ctx) {
+		t.Fatal("a was not elected")
+	}
+	if b.elect(ctx) {
+		t.Error("b was elected too")
+	}
+	// the leader keeps sweeping
+	if !a.elect(ctx) {
+		t.Error("a was not elected")
+	}
+	if b.elect(ctx) {
+		t.Error("b was elected too")
+	}
+
+	// resigning hands over right away
+	a.resign()
+	if !b.elect(ctx) {
+		t.Error("b was not elected")
+	}
+	if a.elect(ctx) {
+		t.Error("a was elected too")
+	}
+	b.resign()
+
+	// a leader that stopped renewing is taken over
+	c := election("replica-c", time.Second)
+	if !c.elect(ctx) {
+		t.Fatal("c was not elected")
+	}
+	lease, err := env.solver.Client.CoordinationV1().Leases(testNamespace).Get(ctx, sweepLeaseName, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	past := metav1.NewMicroTime(time.Now().Add(-time.Minute))
+	lease.Spec.RenewTime = &past
+	_, err = env.solver.Client.CoordinationV1().Leases(testNamespace).Update(ctx, lease, metav1.UpdateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !a.elect(ctx) {
+		t.Error("a was not elected")
+	}
+}
+
+func TestRunSweeper(t *testing.T) {
+	env := newTestEnv(t)
+	env.solver.Sweeper = sweepConfig(SweepZone{Zone: "example.com", AuthSecretRef: "creds"})
+	env.solver.Sweeper.Interval = metav1.Duration{Duration: 10 * time.Millisecond}
+	env.solver.LeaseHolder = "replica-a"
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		env.solver.runSweeper(ctx)
+	}()
+
+	// a record going stale while the webhook runs is swept on a later round
+	addTXT(env, "_acme-challenge.example.com", "stale", "created by "+DefaultUserAgent+"/0.9.0", 2*time.Hour)
+	eventually(t, func() bool {
+		return len(remaining(env)) == 0
+	}, "stale record swept")
+
+	lease, err := env.solver.Client.CoordinationV1().Leases(testNamespace).Get(ctx, sweepLeaseName, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := *lease.Spec.HolderIdentity; got != "replica-a" {
+		t.Errorf("*lease.Spec.HolderIdentity = %v, want %v", got, "replica-a")
+	}
+
+	cancel()
+	<-done
+
+	_, err = env.solver.Client.CoordinationV1().Leases(testNamespace).Get(context.Background(), sweepLeaseName, metav1.GetOptions{})
+	if !apierrors.IsNotFound(err) {
+		t.Error("the lease is released on shutdown")
+	}
+}